@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// first file descriptor passed by systemd, after stdin, stdout and stderr
+const listenFdsStart = 3
+
+// listeners passed by systemd socket activation by their FileDescriptorName, taken by listen
+var activated = struct {
+	sync.Mutex
+	named map[string]net.Listener
+	// in the order of the sockets in the unit
+	unnamed []net.Listener
+}{}
+
+// reads the sockets systemd passed with LISTEN_FDS and LISTEN_FDNAMES, if they are meant for this process
+// the variables are unset so processes started by the server don't take them as well
+func loadActivatedListeners() {
+	activated.Lock()
+	defer activated.Unlock()
+	if activated.named != nil {
+		return
+	}
+	activated.named = make(map[string]net.Listener)
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i := fd - listenFdsStart; i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		// the listener uses a duplicate of fd, which isn't inherited by child processes
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			log.Println("socket activation:", name, "is not a listening socket:", err)
+			continue
+		}
+		log.Println("Using socket", l.Addr(), "passed by systemd as", name)
+		if name == "http" || name == "https" {
+			activated.named[name] = l
+		} else {
+			activated.unnamed = append(activated.unnamed, l)
+		}
+	}
+}
+
+// returns the socket passed by systemd whose FileDescriptorName is name, or the next unnamed one
+// a new socket listening on addr is opened if systemd passed none, e.g. when the server is started by hand
+func listen(name string, addr string) (net.Listener, error) {
+	loadActivatedListeners()
+	activated.Lock()
+	defer activated.Unlock()
+	if l, ok := activated.named[name]; ok {
+		delete(activated.named, name)
+		return l, nil
+	}
+	if len(activated.unnamed) > 0 {
+		l := activated.unnamed[0]
+		activated.unnamed = activated.unnamed[1:]
+		return l, nil
+	}
+	return net.Listen("tcp", addr)
+}
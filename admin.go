@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"currconv/pkg/rates"
+)
+
+// RuntimeStats stores the state of the process for /debug/runtime
+type RuntimeStats struct {
+	Goroutines int    `json:"goroutines"`
+	HeapAlloc  uint64 `json:"heap_alloc"`
+	HeapSys    uint64 `json:"heap_sys"`
+	NumGC      uint32 `json:"num_gc"`
+	GoVersion  string `json:"go_version"`
+}
+
+// checks whether a and b are equal without leaking their common prefix through timing
+func secureEqual(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// checks whether r carries the admin credentials, the bearer token set by admin_token
+// or the user and password set by admin_user and admin_password
+func isAdmin(r *http.Request) bool {
+	if hasAdminToken(r) {
+		return true
+	}
+	if user, password := os.Getenv("admin_user"), os.Getenv("admin_password"); user != "" && password != "" {
+		u, p, ok := r.BasicAuth()
+		// both compared so a wrong user takes as long as a wrong password
+		userOK, passwordOK := secureEqual(u, user), secureEqual(p, password)
+		if ok && userOK && passwordOK {
+			return true
+		}
+	}
+	return false
+}
+
+// checks whether r carries the bearer token set by admin_token
+func hasAdminToken(r *http.Request) bool {
+	token := os.Getenv("admin_token")
+	auth := r.Header.Get("Authorization")
+	return token != "" && strings.HasPrefix(auth, "Bearer ") && secureEqual(strings.TrimPrefix(auth, "Bearer "), token)
+}
+
+// wraps h so only requests with the admin credentials are handled
+// the routes don't exist if no credentials are configured
+// browsers resend basic auth credentials with forms posted by other sites, so requests changing state that are
+// authenticated with them must also send an X-Requested-With header, which other sites can't add without CORS
+func adminOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if os.Getenv("admin_token") == "" && (os.Getenv("admin_user") == "" || os.Getenv("admin_password") == "") {
+			writeError(w, http.StatusNotFound, "no such endpoint "+r.URL.Path)
+			return
+		}
+		if !isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			writeError(w, http.StatusUnauthorized, "admin credentials required")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !hasAdminToken(r) && r.Header.Get("X-Requested-With") == "" {
+				writeError(w, http.StatusForbidden, "requests authenticated with basic auth must send an X-Requested-With header")
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// fetches new rates regardless of the age of the current ones
+func adminRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	refreshCurrentData(r.Context())
+	d := getCurrentData(r.Context())
+	writeJSON(w, map[string]interface{}{"timestamp": d.Timestamp, "time": rfc3339(d.Timestamp)})
+}
+
+// deletes the snapshots and backups older than their retention, as the cleanup job does
+func adminCleanupHandler(w http.ResponseWriter, r *http.Request) {
+	cleanup()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// starts backfilling the history of the server with the rates of every day between the start and end form values
+// (YYYY-MM-DD, end defaults to today) in the background, waiting delay (1s) between requests, see backfillCommand
+// days already stored are only fetched again if force is true
+func adminBackfillHandler(w http.ResponseWriter, r *http.Request) {
+	err := parseForm(r)
+	if bodyTooLarge(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "the form could not be parsed")
+		return
+	}
+	start, err := time.Parse("2006-01-02", r.FormValue("start"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "start must be given as YYYY-MM-DD")
+		return
+	}
+	end := time.Now()
+	if v := r.FormValue("end"); v != "" {
+		if end, err = time.Parse("2006-01-02", v); err != nil {
+			writeError(w, http.StatusBadRequest, "end must be given as YYYY-MM-DD")
+			return
+		}
+	}
+	delay := time.Second
+	if v := r.FormValue("delay"); v != "" {
+		if delay, err = time.ParseDuration(v); err != nil || delay < 0 {
+			writeError(w, http.StatusBadRequest, "delay must be a duration, e.g. 1s")
+			return
+		}
+	}
+	force := r.FormValue("force") == "true"
+
+	s := serverFrom(r.Context())
+	if s.history.unreadable {
+		writeError(w, http.StatusConflict, "the history file can't be read, repair or remove it first")
+		return
+	}
+	if !s.backfilling.TryLock() {
+		writeError(w, http.StatusConflict, "the server is already backfilling")
+		return
+	}
+	release, ok := acquireBackfillLease()
+	if !ok {
+		s.backfilling.Unlock()
+		writeError(w, http.StatusConflict, "another replica is backfilling")
+		return
+	}
+	go func() {
+		defer s.backfilling.Unlock()
+		defer release()
+		backfill(context.WithoutCancel(r.Context()), s.provider, s.history, start, end, delay, force)
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// writes goroutine and memory statistics of the process
+func debugRuntimeHandler(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	writeJSON(w, RuntimeStats{runtime.NumGoroutine(), m.HeapAlloc, m.HeapSys, m.NumGC, runtime.Version()})
+}
+
+// AdminPage stores variables for admin.html
+type AdminPage struct {
+	Status Status
+	// age after which the rates are refreshed and time API responses are cached for
+	MaxAge      time.Duration
+	ResponseTTL time.Duration
+	Cache       rates.CacheStats
+	// percentage of the lookups answered without refreshing and mean duration of a refresh
+	HitPercent  float64
+	MeanRefresh time.Duration
+	// lookups of cached API responses by result: hit, miss or expired
+	Responses          map[string]uint64
+	ResponseHitPercent float64
+	Providers          []rates.ProviderStats
+}
+
+// shows the state of the rates, the hit ratios of the caches and the providers, so the TTLs can be tuned
+func adminDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	cache := serverFrom(r.Context()).cache
+	p := AdminPage{
+		Status:      currentStatus(r.Context()),
+		MaxAge:      cache.MaxAge,
+		ResponseTTL: getResponseCacheTTL(),
+		Cache:       cache.Stats(),
+		Responses:   responseCacheLookups(),
+		Providers:   providerStats(),
+	}
+	p.HitPercent = p.Cache.HitRatio() * 100
+	if p.Cache.Refreshes.Count > 0 {
+		p.MeanRefresh = time.Duration(p.Cache.Refreshes.Sum / float64(p.Cache.Refreshes.Count) * float64(time.Second)).Round(time.Millisecond)
+	}
+	if total := p.Responses["hit"] + p.Responses["miss"] + p.Responses["expired"]; total > 0 {
+		p.ResponseHitPercent = float64(p.Responses["hit"]) / float64(total) * 100
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	renderTemplate(w, r, "admin", &p)
+}
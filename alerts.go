@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"currconv/pkg/convert"
+	"currconv/pkg/rates"
+)
+
+// most alerts stored at once, so anonymous clients can't fill the disk
+const maxAlerts = 10000
+
+// pushes sent at once and the time the pushes of one refresh may take in total
+const (
+	pushWorkers  = 8
+	pushDeadline = 2 * time.Minute
+)
+
+// Alert notifies a browser through its push subscription once the rate of a pair rises to Above or falls to Below
+// an alert fires once and again only after the rate has been back between Below and Above
+type Alert struct {
+	ID   string `json:"id"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	// 0 if not set
+	Above        float64          `json:"above,omitempty"`
+	Below        float64          `json:"below,omitempty"`
+	Subscription PushSubscription `json:"subscription"`
+	Triggered    bool             `json:"triggered"`
+}
+
+// AlertNotification is the payload of a push, shown by the service worker
+type AlertNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url"`
+}
+
+// Alerts stores the rate alerts by their id
+type Alerts struct {
+	mutex sync.Mutex
+	// file the alerts are persisted to
+	path   string
+	Alerts map[string]Alert
+	// alerts by id shared with the other replicas instead of path, nil if no Redis server is set
+	shared *RedisHash
+}
+
+// reads the alerts stored at path
+// returns no alerts if the file does not exist yet
+func loadAlerts(path string) *Alerts {
+	a := &Alerts{path: path, Alerts: make(map[string]Alert)}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return a
+	}
+	if err != nil {
+		log.Println(err)
+		return a
+	}
+
+	err = json.Unmarshal(b, &a.Alerts)
+	if err != nil {
+		log.Println(err)
+	}
+	return a
+}
+
+// writes the alerts to disk, the mutex must be held
+func (a *Alerts) save() {
+	b, err := json.Marshal(a.Alerts)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = ioutil.WriteFile(a.path, b, 0644)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// stores alert under a new random id, which is needed to delete it again
+func (a *Alerts) add(alert Alert) (Alert, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	n := int64(len(a.Alerts))
+	if a.shared != nil {
+		var err error
+		if n, err = a.shared.len(context.Background()); err != nil {
+			return alert, err
+		}
+	}
+	if n >= maxAlerts {
+		return alert, errors.New("no more alerts can be created at the moment")
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return alert, err
+	}
+	alert.ID = hex.EncodeToString(id)
+	if a.shared != nil {
+		return alert, a.shared.set(context.Background(), alert.ID, alert)
+	}
+	a.Alerts[alert.ID] = alert
+	a.save()
+	return alert, nil
+}
+
+// deletes the alert with id, returns whether it existed
+func (a *Alerts) remove(id string) bool {
+	if a.shared != nil {
+		ok, err := a.shared.del(context.Background(), id)
+		if err != nil {
+			log.Println(err)
+		}
+		return ok
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, ok := a.Alerts[id]; !ok {
+		return false
+	}
+	delete(a.Alerts, id)
+	a.save()
+	return true
+}
+
+// checks every alert against d, marks those whose threshold was crossed and returns them
+// alerts whose rate is back between their thresholds are armed again
+func (a *Alerts) crossed(d rates.Data) []Alert {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	all := a.Alerts
+	if a.shared != nil {
+		var err error
+		if all, err = a.loadShared(); err != nil {
+			log.Println(err)
+			return nil
+		}
+	}
+
+	var fired []Alert
+	changed := false
+	for id, alert := range all {
+		if !convert.Available(d, alert.From, alert.To) {
+			continue
+		}
+		rate := convert.Rate(d, alert.From, alert.To)
+		crossed := (alert.Above > 0 && rate >= alert.Above) || (alert.Below > 0 && rate <= alert.Below)
+		if crossed == alert.Triggered {
+			continue
+		}
+		alert.Triggered = crossed
+		all[id] = alert
+		changed = true
+		if a.shared != nil {
+			if err := a.shared.set(context.Background(), id, alert); err != nil {
+				log.Println(err)
+			}
+		}
+		if crossed {
+			fired = append(fired, alert)
+		}
+	}
+	if changed && a.shared == nil {
+		a.save()
+	}
+	return fired
+}
+
+// returns the alerts stored in the shared Redis server
+func (a *Alerts) loadShared() (map[string]Alert, error) {
+	values, err := a.shared.all(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	all := make(map[string]Alert, len(values))
+	for id, v := range values {
+		var alert Alert
+		if err := json.Unmarshal([]byte(v), &alert); err != nil {
+			log.Println(err)
+			continue
+		}
+		all[id] = alert
+	}
+	return all, nil
+}
+
+// returns the notification of alert for the rate of its pair in d
+func alertNotification(alert Alert, d rates.Data) AlertNotification {
+	rate := convert.Rate(d, alert.From, alert.To)
+	direction, threshold := "above", alert.Above
+	if alert.Below > 0 && rate <= alert.Below {
+		direction, threshold = "below", alert.Below
+	}
+	return AlertNotification{
+		Title: alert.From + "/" + alert.To + " is " + direction + " " + strconv.FormatFloat(threshold, 'f', -1, 64),
+		Body:  "1 " + alert.From + " = " + strconv.FormatFloat(rate, 'f', 4, 64) + " " + alert.To,
+		URL:   "/chart/" + alert.From + "/" + alert.To,
+	}
+}
+
+// pushes a notification for every alert whose threshold d crosses, deleting alerts whose subscription is gone
+// only the leader sends them, so subscribers aren't notified by every replica, the alerts are shared through Redis
+// with the replicas then so those created on any of them are sent
+// the alerts are those of the server of ctx
+func notifyAlerts(ctx context.Context, d rates.Data) {
+	if !isLeader() {
+		return
+	}
+	alerts := serverFrom(ctx).alerts
+	// the pushes of a refresh are sent by pushWorkers at a time and given up after pushDeadline
+	ctx, cancel := context.WithTimeout(ctx, pushDeadline)
+	defer cancel()
+	slots := make(chan struct{}, pushWorkers)
+	var wg sync.WaitGroup
+	for _, alert := range alerts.crossed(d) {
+		payload, err := json.Marshal(alertNotification(alert, d))
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			log.Println("warning: alerts not sent within", pushDeadline)
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(alert Alert) {
+			defer func() { <-slots; wg.Done() }()
+			err := sendPush(ctx, alert.Subscription, payload)
+			if errors.Is(err, errPushGone) {
+				alerts.remove(alert.ID)
+				return
+			}
+			if err != nil {
+				log.Println(err)
+			}
+		}(alert)
+	}
+	wg.Wait()
+}
+
+// checks the keys and endpoint of a subscription sent by a browser
+func validSubscription(sub PushSubscription) bool {
+	u, err := url.Parse(sub.Endpoint)
+	if err != nil || u.Scheme != "https" || !allowedPushHost(u.Hostname()) {
+		return false
+	}
+	key, err := decodeBase64URL(sub.Keys.P256dh)
+	if err == nil {
+		_, err = ecdh.P256().NewPublicKey(key)
+	}
+	if err != nil {
+		return false
+	}
+	auth, err := decodeBase64URL(sub.Keys.Auth)
+	return err == nil && len(auth) == 16
+}
+
+// writes the public VAPID key browsers need to subscribe to alerts
+func apiPushKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, err := vapidPublicKey()
+	if err != nil {
+		log.Println(err)
+		writeError(w, http.StatusServiceUnavailable, "push notifications are not available")
+		return
+	}
+	writeJSON(w, map[string]string{"public_key": key})
+}
+
+// creates an alert from the json body {pair, above, below, subscription} and writes it with its id
+func apiCreateAlertHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Pair         string           `json:"pair"`
+		Above        float64          `json:"above"`
+		Below        float64          `json:"below"`
+		Subscription PushSubscription `json:"subscription"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if bodyTooLarge(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "body must be a json object with pair, above or below and subscription")
+		return
+	}
+
+	from, to, ok := parsePair(getCurrentData(r.Context()), body.Pair)
+	switch {
+	case !ok:
+		writeError(w, http.StatusBadRequest, "pair must be given as FROM/TO, e.g. EUR/USD")
+		return
+	case body.Above < 0 || body.Below < 0 || (body.Above == 0 && body.Below == 0):
+		writeError(w, http.StatusBadRequest, "above or below must be a positive rate")
+		return
+	case body.Above > 0 && body.Below >= body.Above:
+		writeError(w, http.StatusBadRequest, "below must be less than above")
+		return
+	case !validSubscription(body.Subscription):
+		writeError(w, http.StatusBadRequest, "subscription must be a push subscription with the https endpoint of a known push service and its keys")
+		return
+	}
+
+	alert, err := serverFrom(r.Context()).alerts.add(Alert{From: from, To: to, Above: body.Above, Below: body.Below, Subscription: body.Subscription})
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	// set before the status, writeJSON would be too late
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, alert)
+}
+
+// deletes the alert named by the path
+func apiDeleteAlertHandler(w http.ResponseWriter, r *http.Request) {
+	if !serverFrom(r.Context()).alerts.remove(pathParam(r, "id")) {
+		writeError(w, http.StatusNotFound, "unknown alert")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serves the service worker showing the pushed alerts, from the root so it may control every page
+func serviceWorkerHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, "static/sw.js")
+}
+
+// AlertsPage stores variables for /alerts/
+type AlertsPage struct {
+	Pair string
+}
+
+// renders the form subscribing to an alert, the pair parameter preselects its pair
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+	renderTemplate(w, r, "alerts", &AlertsPage{getQueryDefault(r.URL.Query().Get("pair"), "EUR/USD")})
+}
@@ -0,0 +1,65 @@
+package main
+
+import "strings"
+
+// ISO codes of the currencies written by a common name or symbol instead, keys are upper case
+// symbols shared by several currencies map to the one most people mean, $ to USD and ¥ to JPY
+var currencyAliases = map[string]string{
+	"$":        "USD",
+	"US$":      "USD",
+	"€":        "EUR",
+	"EURO":     "EUR",
+	"£":        "GBP",
+	"STG":      "GBP",
+	"¥":        "JPY",
+	"YEN":      "JPY",
+	"RMB":      "CNY",
+	"YUAN":     "CNY",
+	"CN¥":      "CNY",
+	"元":        "CNY",
+	"NT$":      "TWD",
+	"NTD":      "TWD",
+	"₹":        "INR",
+	"₩":        "KRW",
+	"₽":        "RUB",
+	"₺":        "TRY",
+	"₪":        "ILS",
+	"NIS":      "ILS",
+	"₱":        "PHP",
+	"฿":        "THB",
+	"₫":        "VND",
+	"₦":        "NGN",
+	"ZŁ":       "PLN",
+	"A$":       "AUD",
+	"AU$":      "AUD",
+	"C$":       "CAD",
+	"CA$":      "CAD",
+	"HK$":      "HKD",
+	"NZ$":      "NZD",
+	"S$":       "SGD",
+	"R$":       "BRL",
+	"MX$":      "MXN",
+	"FR.":      "CHF",
+	"SFR":      "CHF",
+	"BITCOIN":  "BTC",
+	"₿":        "BTC",
+	"DIRHAM":   "AED",
+	"RUPEE":    "INR",
+	"RUPEES":   "INR",
+	"RAND":     "ZAR",
+	"EUROS":    "EUR",
+	"DOLLAR":   "USD",
+	"DOLLARS":  "USD",
+	"POUND":    "GBP",
+	"POUNDS":   "GBP",
+	"STERLING": "GBP",
+}
+
+// returns the ISO code of the currency the user typed as c, which may be an alias, a symbol or a code in any case
+func currencyCode(c string) string {
+	c = strings.ToUpper(strings.TrimSpace(c))
+	if code, ok := currencyAliases[c]; ok {
+		return code
+	}
+	return c
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"currconv/pkg/rates"
+)
+
+// returns p wrapped so new rates that changed by more than anomaly_threshold percent (10 by default) per anomaly_window (1h)
+// since the previous refresh are logged and mailed to alert_email, p itself if anomaly_threshold is 0
+// if anomaly_reject is true the previous rates of those currencies are kept
+func withAnomalyCheck(p rates.Provider) rates.Provider {
+	threshold, err := strconv.ParseFloat(getEnv("anomaly_threshold", "10"), 64)
+	if err != nil || threshold < 0 {
+		log.Println("anomaly_threshold must be a positive percentage")
+		threshold = 10
+	}
+	window, err := time.ParseDuration(getEnv("anomaly_window", "1h"))
+	if err != nil || window <= 0 {
+		log.Println("anomaly_window must be a positive duration, e.g. 1h")
+		window = time.Hour
+	}
+	if threshold == 0 {
+		return p
+	}
+	return &rates.Guarded{Provider: p, MaxChange: threshold, Window: window, Reject: getEnv("anomaly_reject", "false") == "true", OnAnomaly: reportAnomalies}
+}
+
+// logs the anomalies of a refresh and mails them to alert_email if it and an smtp server are configured
+func reportAnomalies(anomalies []rates.Anomaly) {
+	var sb strings.Builder
+	for _, a := range anomalies {
+		action := "kept"
+		if a.Rejected {
+			action = "rejected, keeping the previous rate"
+		}
+		line := fmt.Sprintf("%s moved %+.2f%% in %s from %g to %g, %s", a.Currency, a.Change, a.Elapsed.Round(time.Second), a.Previous, a.Rate, action)
+		log.Println("Rate anomaly:", line)
+		sb.WriteString(line + "\n")
+	}
+
+	to := os.Getenv("alert_email")
+	if to == "" || os.Getenv("smtp_host") == "" {
+		return
+	}
+	go func() {
+		err := sendMail(to, "Implausible exchange rates", "The provider sent rates that changed implausibly fast:\n\n"+sb.String())
+		if err != nil {
+			log.Println(err)
+		}
+	}()
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"currconv/pkg/convert"
+	"currconv/pkg/rates"
+)
+
+// writes v as json response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// checks whether the Accept header of r prefers json to html
+func wantsJSON(r *http.Request) bool {
+	for _, mediaType := range parseAccept(r.Header.Get("Accept")) {
+		switch strings.ToLower(mediaType) {
+		case "application/json":
+			return true
+		case "text/html", "text/*", "*/*":
+			return false
+		}
+	}
+	return false
+}
+
+// Conversion stores the result of a currency conversion
+type Conversion struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float64 `json:"amount"`
+	// rate of the requested side, the mid-market rate by default
+	Rate float64 `json:"rate"`
+	// buy, sell or mid
+	Side string `json:"side"`
+	// final amount including the markup
+	Result float64 `json:"result"`
+	// amount at the rate before the markup
+	BaseResult float64        `json:"base_result"`
+	Markup     *AppliedMarkup `json:"markup,omitempty"`
+	Timestamp  int64          `json:"timestamp"`
+	// Timestamp in RFC 3339
+	Time string `json:"time"`
+	Date string `json:"date"`
+	// id of the quote whose locked rate was used
+	Quote string `json:"quote,omitempty"`
+	VAT   *VAT   `json:"vat,omitempty"`
+}
+
+// VAT splits the converted amount into net, tax and gross amounts in the target currency
+type VAT struct {
+	Percent float64 `json:"percent"`
+	// the converted amount
+	Net   float64 `json:"net"`
+	Tax   float64 `json:"tax"`
+	Gross float64 `json:"gross"`
+}
+
+// returns the VAT of percent on top of the net amount
+func newVAT(net float64, percent float64) *VAT {
+	tax := convert.RoundTo2Decimals(net * percent / 100)
+	return &VAT{percent, net, tax, convert.RoundTo2Decimals(net + tax)}
+}
+
+// AppliedMarkup stores the markup charged on a conversion
+type AppliedMarkup struct {
+	Percent float64 `json:"percent"`
+	Fee     float64 `json:"fee"`
+	// difference between the final amount and the amount before the markup
+	Amount float64 `json:"amount"`
+	// multiple the result was rounded to by the conversion rules
+	Round float64 `json:"round,omitempty"`
+}
+
+// formats a unix timestamp as RFC 3339 in UTC
+func rfc3339(timestamp int64) string {
+	return time.Unix(timestamp, 0).UTC().Format(time.RFC3339)
+}
+
+// APIError is the json body of failed API requests
+type APIError struct {
+	Error string `json:"error"`
+	// currencies an unknown currency might have meant
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// writes an APIError with the given status code
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeAPIError(w, status, APIError{Error: msg})
+}
+
+// writes e with the given status code
+func writeAPIError(w http.ResponseWriter, status int, e APIError) {
+	// set before the status is sent, writeJSON would be too late
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	writeJSON(w, e)
+}
+
+// returns the markup configured by the markup_percent and markup_fee environment variables
+func getMarkup() convert.Markup {
+	var m convert.Markup
+	var err error
+	if v := os.Getenv("markup_percent"); v != "" {
+		if m.Percent, err = strconv.ParseFloat(v, 64); err != nil {
+			log.Println(err)
+		}
+	}
+	if v := os.Getenv("markup_fee"); v != "" {
+		if m.Fee, err = strconv.ParseFloat(v, 64); err != nil {
+			log.Println(err)
+		}
+	}
+	return m
+}
+
+// returns the spread configured by the spread_percent and spreads environment variables
+// spreads overrides the percentage per currency, e.g. "TRY:4,ARS:8"
+func getSpread() convert.Spread {
+	s := convert.Spread{Currencies: make(map[string]float64)}
+	var err error
+	if v := os.Getenv("spread_percent"); v != "" {
+		if s.Percent, err = strconv.ParseFloat(v, 64); err != nil {
+			log.Println(err)
+		}
+	}
+	for _, part := range strings.Split(os.Getenv("spreads"), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		percent, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		s.Currencies[strings.ToUpper(kv[0])] = percent
+	}
+	return s
+}
+
+// converts amount of from into to at rate and applies markup m and the conversion rules
+// the amounts are rounded with the decimals and rounding mode of p
+func applyRate(from string, to string, rate float64, amount float64, m convert.Markup, p Preferences) (result float64, baseResult float64, applied *AppliedMarkup) {
+	m, round := applyRules(from, to, rate, amount, m)
+	base := amount * rate
+	result = convert.RoundToMultiple(m.Apply(base), round)
+	if !m.IsZero() || round > 0 {
+		applied = &AppliedMarkup{m.Percent, m.Fee, p.round(result - base), round}
+	}
+	return p.round(result), p.round(base), applied
+}
+
+// converts amount of from into to at the rate of side using d and applies markup m, rounded as p asks for
+func newConversion(d rates.Data, from string, to string, amount float64, side convert.Side, m convert.Markup, p Preferences) Conversion {
+	rate := midRate(d, from, to)
+	if side != convert.Mid {
+		rate = convert.SideRate(d, from, to, side, getSpread())
+	}
+	result, baseResult, applied := applyRate(from, to, rate, amount, m, p)
+	return Conversion{
+		From:       from,
+		To:         to,
+		Amount:     amount,
+		Rate:       rate,
+		Side:       string(side),
+		Result:     result,
+		BaseResult: baseResult,
+		Markup:     applied,
+		Timestamp:  d.Timestamp,
+		Time:       rfc3339(d.Timestamp),
+		Date:       d.Date,
+	}
+}
+
+// converts the amount given in the url query and writes the result as json
+// uses the locked rate of the quote parameter if given
+// the side parameter (buy, sell or mid) selects the rate of the spread
+// the date parameter (YYYY-MM-DD) converts with the rates of a past day
+// the vat parameter adds the tax of that percentage on top of the result
+func apiConvertHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	d, err := dataFor(r.Context(), query.Get("date"))
+	if err != nil {
+		failRequest(w, r, err)
+		return
+	}
+
+	from := currencyCode(query.Get("from"))
+	to := currencyCode(query.Get("to"))
+	amount, err := parseAmount(query.Get("amount"))
+	if err != nil {
+		failRequest(w, r, err)
+		return
+	}
+	side, ok := convert.ParseSide(query.Get("side"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "side must be buy, sell or mid")
+		return
+	}
+	var vat float64
+	if v := query.Get("vat"); v != "" {
+		vat, err = strconv.ParseFloat(v, 64)
+		if err != nil || vat < 0 {
+			writeError(w, http.StatusBadRequest, "vat must be a percentage")
+			return
+		}
+	}
+
+	var c Conversion
+	if id := query.Get("quote"); id != "" {
+		if c, ok = convertWithQuote(w, id, from, to, amount, markupFor(r), preferencesFor(r)); !ok {
+			return
+		}
+	} else {
+		d = withRetiredRates(d, from, to)
+		if err := checkCurrencies(d, from, to); err != nil {
+			failRequest(w, r, err)
+			return
+		}
+		c = newConversion(d, from, to, amount, side, markupFor(r), preferencesFor(r))
+	}
+
+	if vat > 0 {
+		c.VAT = newVAT(c.Result, vat)
+	}
+	setRateWarnings(w, r, d)
+	setRetiredWarnings(w, from, to)
+	writeJSON(w, c)
+}
+
+// returns the base currency given by the base parameter of r, the preferences of its session or the base_currency environment variable
+// defaults to the base of d
+func requestedBase(r *http.Request, d rates.Data) string {
+	base := r.URL.Query().Get("base")
+	if base == "" {
+		base = preferencesFor(r).Base
+	}
+	if base == "" {
+		base = getEnv("base_currency", d.Base)
+	}
+	return currencyCode(base)
+}
+
+// returns d rebased to the requested base currency of r
+// writes an error and returns false if the base currency is unknown
+func rebaseFor(w http.ResponseWriter, r *http.Request, d rates.Data) (rates.Data, bool) {
+	base := requestedBase(r, d)
+	d, err := d.Rebase(base)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "unknown base currency "+base)
+		return d, false
+	}
+	return d, true
+}
+
+// UnitRate stores the rate of one unit of a pair in both directions
+type UnitRate struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// value of one from in to
+	Rate float64 `json:"rate"`
+	// value of one to in from
+	Inverse   float64 `json:"inverse"`
+	Timestamp int64   `json:"timestamp"`
+	Time      string  `json:"time"`
+	Date      string  `json:"date"`
+}
+
+// writes the direct and inverse mid-market rate of the pair given in the path (/api/rate/{from}/{to}) or url query as json
+func apiRateHandler(w http.ResponseWriter, r *http.Request) {
+	d, err := dataFor(r.Context(), "")
+	if err != nil {
+		failRequest(w, r, err)
+		return
+	}
+
+	from := currencyCode(getParam(r, "from"))
+	to := currencyCode(getParam(r, "to"))
+	d = withRetiredRates(d, from, to)
+	if err := checkCurrencies(d, from, to); err != nil {
+		failRequest(w, r, err)
+		return
+	}
+
+	setCacheHeaders(w, r, d)
+	writeJSON(w, UnitRate{from, to, midRate(d, from, to), midRate(d, to, from), d.Timestamp, rfc3339(d.Timestamp), d.Date})
+}
+
+// RateTable stores the rates of all currencies in the base currency
+type RateTable struct {
+	Base      string             `json:"base"`
+	Date      string             `json:"date"`
+	Timestamp int64              `json:"timestamp"`
+	Time      string             `json:"time"`
+	Rates     map[string]float64 `json:"rates"`
+}
+
+// writes the current rate table as json
+// the base parameter selects the base currency
+func apiRatesHandler(w http.ResponseWriter, r *http.Request) {
+	d, err := dataFor(r.Context(), "")
+	if err != nil {
+		failRequest(w, r, err)
+		return
+	}
+	d, ok := rebaseFor(w, r, d)
+	if !ok {
+		return
+	}
+	setCacheHeaders(w, r, d)
+	writeJSON(w, RateTable{d.Base, d.Date, d.Timestamp, rfc3339(d.Timestamp), d.Rates})
+}
+
+// TimeseriesPoint stores the rate of a pair on one day
+type TimeseriesPoint struct {
+	Date string  `json:"date"`
+	Rate float64 `json:"rate"`
+}
+
+// writes the daily rates of from in to between start and end as json object with from, to, start, end and rates
+// like writeJSON, but the rates are encoded while the history is read, so multi-year ranges are streamed
+// instead of being collected in memory first
+func writeTimeseries(w http.ResponseWriter, r *http.Request, from string, to string, start string, end string) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	io.WriteString(w, `{"from":`)
+	enc.Encode(from)
+	io.WriteString(w, `,"to":`)
+	enc.Encode(to)
+	io.WriteString(w, `,"start":`)
+	enc.Encode(start)
+	io.WriteString(w, `,"end":`)
+	enc.Encode(end)
+	io.WriteString(w, `,"rates":[`)
+	first := true
+	err := eachTimeseriesPoint(r.Context(), from, to, start, end, func(p TimeseriesPoint) error {
+		if !first {
+			io.WriteString(w, ",")
+		}
+		first = false
+		return enc.Encode(p)
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	io.WriteString(w, "]}\n")
+}
+
+// calls fn with the daily rate of from in to on every day between start and end (inclusive, YYYY-MM-DD) stored in the
+// history of the server of ctx, oldest first, stops at the first error fn returns and returns it
+func eachTimeseriesPoint(ctx context.Context, from string, to string, start string, end string, fn func(p TimeseriesPoint) error) error {
+	return serverFrom(ctx).history.each(start, end, func(day rates.Data) error {
+		if !convert.Available(day, from, to) {
+			return nil
+		}
+		return fn(TimeseriesPoint{day.Date, convert.Rate(day, from, to)})
+	})
+}
+
+// returns the daily rates of from in to between start and end (inclusive, YYYY-MM-DD) stored in the history of the
+// server of ctx, oldest first, for the charts and statistics computed from them
+// results are cached until the history changes and must not be modified, exports use eachTimeseriesPoint instead
+func timeseries(ctx context.Context, from string, to string, start string, end string) []TimeseriesPoint {
+	history := serverFrom(ctx).history
+	key := "timeseries " + strconv.Itoa(history.getVersion()) + " " + from + "/" + to + " " + start + " " + end
+	if v, ok := queryCache.get(key); ok {
+		return v.([]TimeseriesPoint)
+	}
+
+	points := []TimeseriesPoint{}
+	eachTimeseriesPoint(ctx, from, to, start, end, func(p TimeseriesPoint) error {
+		points = append(points, p)
+		return nil
+	})
+	queryCache.add(key, points)
+	return points
+}
+
+// writes the rate table of the date given in the url query as json, fetching it once if it is not stored yet
+// the base parameter selects the base currency
+func apiHistoricalHandler(w http.ResponseWriter, r *http.Request) {
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		writeError(w, http.StatusBadRequest, "date must be given as YYYY-MM-DD")
+		return
+	}
+	d, err := dataFor(r.Context(), date)
+	if err != nil {
+		failRequest(w, r, err)
+		return
+	}
+	d, ok := rebaseFor(w, r, d)
+	if !ok {
+		return
+	}
+	if date < time.Now().UTC().Format("2006-01-02") {
+		// the rates of past days never change
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		setCacheHeaders(w, r, d)
+	}
+	writeJSON(w, RateTable{d.Base, d.Date, d.Timestamp, rfc3339(d.Timestamp), d.Rates})
+}
+
+// writes the stored daily rates of the pair given in the url query as json
+// the range can be limited with the start and end parameters (YYYY-MM-DD)
+func apiTimeseriesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	start := query.Get("start")
+	end := query.Get("end")
+
+	from, to, ok := parsePair(getCurrentData(r.Context()), query.Get("pair"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "pair must be given as FROM/TO, e.g. EUR/USD")
+		return
+	}
+	if !validDate(start) || !validDate(end) {
+		writeError(w, http.StatusBadRequest, "start and end must be given as YYYY-MM-DD")
+		return
+	}
+
+	writeTimeseries(w, r, from, to, start, end)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// window lengths in days offered for moving averages
+var averageWindows = []int{7, 30, 90}
+
+// Average names a moving average, e.g. the simple average of 30 days
+type Average struct {
+	// "sma" or "ema"
+	Type   string `json:"type"`
+	Window int    `json:"window"`
+}
+
+// parses an average written like sma30 or ema7
+func parseAverage(s string) (Average, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) > 3 {
+		a := Average{Type: s[:3]}
+		window, err := strconv.Atoi(s[3:])
+		a.Window = window
+		if err == nil && a.valid() == nil {
+			return a, nil
+		}
+	}
+	return Average{}, fmt.Errorf("%q must be sma or ema followed by the window in days, e.g. sma30", s)
+}
+
+// checks the type and window of a
+func (a Average) valid() error {
+	if a.Type != "sma" && a.Type != "ema" {
+		return errors.New("type must be sma or ema")
+	}
+	for _, w := range averageWindows {
+		if a.Window == w {
+			return nil
+		}
+	}
+	windows := make([]string, len(averageWindows))
+	for i, w := range averageWindows {
+		windows[i] = strconv.Itoa(w)
+	}
+	return errors.New("window must be one of " + strings.Join(windows, ", "))
+}
+
+func (a Average) String() string {
+	return a.Type + strconv.Itoa(a.Window)
+}
+
+// Name returns a for display, e.g. SMA 30
+func (a Average) Name() string {
+	return strings.ToUpper(a.Type) + " " + strconv.Itoa(a.Window)
+}
+
+// returns the average of the points as far as they cover a whole window
+// the window counts stored days, so days without rates don't shorten it
+func (a Average) of(points []TimeseriesPoint) []TimeseriesPoint {
+	if a.Type == "ema" {
+		return exponentialAverage(points, a.Window)
+	}
+	return simpleAverage(points, a.Window)
+}
+
+// returns the mean of every window of points, dated with the last day of the window
+func simpleAverage(points []TimeseriesPoint, window int) []TimeseriesPoint {
+	averages := []TimeseriesPoint{}
+	sum := 0.0
+	for i, p := range points {
+		sum += p.Rate
+		if i >= window {
+			sum -= points[i-window].Rate
+		}
+		if i >= window-1 {
+			averages = append(averages, TimeseriesPoint{p.Date, sum / float64(window)})
+		}
+	}
+	return averages
+}
+
+// returns the exponential moving average of points weighting each day by 2/(window+1),
+// started with the simple average of the first window
+func exponentialAverage(points []TimeseriesPoint, window int) []TimeseriesPoint {
+	averages := []TimeseriesPoint{}
+	if len(points) < window {
+		return averages
+	}
+	k := 2 / float64(window+1)
+	ema := simpleAverage(points[:window], window)[0].Rate
+	averages = append(averages, TimeseriesPoint{points[window-1].Date, ema})
+	for _, p := range points[window:] {
+		ema = p.Rate*k + ema*(1-k)
+		averages = append(averages, TimeseriesPoint{p.Date, ema})
+	}
+	return averages
+}
+
+// returns the average a of the pair from/to on the days between start and end (inclusive, YYYY-MM-DD)
+// the days before start are taken into account, so the average is available from start on if enough history is stored
+func movingAverage(ctx context.Context, a Average, from string, to string, start string, end string) []TimeseriesPoint {
+	averages := a.of(timeseries(ctx, from, to, "", end))
+	for i, p := range averages {
+		if p.Date >= start {
+			return averages[i:]
+		}
+	}
+	return []TimeseriesPoint{}
+}
+
+// MovingAverage stores a moving average of a pair between two dates
+type MovingAverage struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Average
+	Start string            `json:"start"`
+	End   string            `json:"end"`
+	Rates []TimeseriesPoint `json:"rates"`
+}
+
+// writes the moving average of the pair given in the url query as json,
+// the simple one by default or the exponential one if the type parameter is ema
+// the window parameter sets its length in days, 30 by default,
+// the range can be limited with the start and end parameters (YYYY-MM-DD)
+func apiAverageHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	start := query.Get("start")
+	end := query.Get("end")
+
+	from, to, ok := parsePair(getCurrentData(r.Context()), query.Get("pair"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "pair must be given as FROM/TO, e.g. EUR/USD")
+		return
+	}
+	if !validDate(start) || !validDate(end) {
+		writeError(w, http.StatusBadRequest, "start and end must be given as YYYY-MM-DD")
+		return
+	}
+	a := Average{Type: strings.ToLower(getQueryDefault(query.Get("type"), "sma")), Window: 30}
+	if window := query.Get("window"); window != "" {
+		a.Window, _ = strconv.Atoi(window)
+	}
+	if err := a.valid(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, MovingAverage{from, to, a, start, end, movingAverage(r.Context(), a, from, to, start, end)})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"currconv/pkg/rates"
+)
+
+// Bucket stores the location and credentials of an S3 compatible object storage bucket
+// Google Cloud Storage can be used through its S3 compatible endpoint with HMAC keys
+type Bucket struct {
+	// e.g. https://s3.eu-central-1.amazonaws.com or https://storage.googleapis.com
+	Endpoint  string
+	Name      string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// ListBucketResult stores the parts of an S3 ListObjectsV2 response that are needed for pruning
+type ListBucketResult struct {
+	Keys                  []string `xml:"Contents>Key"`
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+var backupClient = &http.Client{Timeout: 30 * time.Second}
+
+// returns the backup bucket configured by the backup_* environment variables
+// returns false if no bucket is configured
+func getBackupBucket() (Bucket, bool) {
+	b := Bucket{
+		Endpoint:  strings.TrimSuffix(os.Getenv("backup_endpoint"), "/"),
+		Name:      os.Getenv("backup_bucket"),
+		Region:    getEnv("backup_region", "us-east-1"),
+		AccessKey: os.Getenv("backup_access_key"),
+		SecretKey: os.Getenv("backup_secret_key"),
+	}
+	return b, b.Endpoint != "" && b.Name != ""
+}
+
+// returns the hex encoded sha256 hash of b
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// returns the HMAC-SHA256 of data using key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sends a request for key to the bucket, signed with AWS signature version 4
+func (b Bucket) do(method string, key string, query url.Values, body []byte) (*http.Response, error) {
+	u, err := url.Parse(b.Endpoint + "/" + b.Name + "/" + key)
+	if err != nil {
+		return nil, err
+	}
+	// Encode sorts the parameters by key as required for the canonical request
+	u.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := method + "\n" +
+		u.EscapedPath() + "\n" +
+		u.RawQuery + "\n" +
+		"host:" + u.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n" +
+		"\n" + signedHeaders + "\n" +
+		payloadHash
+
+	scope := now.Format("20060102") + "/" + b.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	signingKey := hmacSHA256([]byte("AWS4"+b.SecretKey), now.Format("20060102"))
+	signingKey = hmacSHA256(signingKey, b.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+b.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+
+	resp, err := backupClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, errors.New(method + " " + key + ": " + resp.Status + " " + string(msg))
+	}
+	return resp, nil
+}
+
+// uploads body to key
+func (b Bucket) put(key string, body []byte) error {
+	resp, err := b.do(http.MethodPut, key, nil, body)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// deletes key
+func (b Bucket) remove(key string) error {
+	resp, err := b.do(http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// returns all keys starting with prefix
+func (b Bucket) list(prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := b.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result ListBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, result.Keys...)
+		if !result.IsTruncated {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// uploads d as the snapshot of its day to <backup_prefix><date>.json in the backup bucket
+// uploading again on the same day replaces the day's snapshot
+func backupSnapshot(d rates.Data) {
+	bucket, ok := getBackupBucket()
+	if !ok || !d.Success {
+		return
+	}
+
+	body, err := json.Marshal(d)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	err = bucket.put(getEnv("backup_prefix", "snapshots/")+d.Date+".json", body)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// deletes the daily snapshots in the backup bucket that are older than cutoff
+func pruneBackups(cutoff time.Time) {
+	bucket, ok := getBackupBucket()
+	if !ok {
+		return
+	}
+
+	prefix := getEnv("backup_prefix", "snapshots/")
+	keys, err := bucket.list(prefix)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, key := range keys {
+		date, err := time.Parse("2006-01-02", strings.TrimSuffix(strings.TrimPrefix(key, prefix), ".json"))
+		if err != nil || !date.Before(cutoff) {
+			continue
+		}
+		err = bucket.remove(key)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
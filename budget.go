@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"currconv/pkg/convert"
+	"currconv/pkg/rates"
+)
+
+// BudgetPart is a category or day a travel budget is split into
+type BudgetPart struct {
+	Name string `json:"name"`
+	// share of the budget relative to the other parts
+	Weight float64 `json:"weight"`
+	// allocated amount in the destination currency
+	Amount float64 `json:"amount"`
+}
+
+// Budget stores a total budget converted into the destination currency and split into parts
+type Budget struct {
+	From  string  `json:"from"`
+	To    string  `json:"to"`
+	Total float64 `json:"total"`
+	// total in the destination currency
+	Converted float64 `json:"converted"`
+	// cash unit the parts are rounded down to
+	Unit  float64      `json:"unit"`
+	Parts []BudgetPart `json:"parts"`
+	// part of the converted total that is left after rounding
+	Remainder float64 `json:"remainder"`
+	Timestamp int64   `json:"timestamp"`
+	Time      string  `json:"time"`
+	Date      string  `json:"date"`
+}
+
+// BudgetPage stores variables for /budget/
+type BudgetPage struct {
+	Budget  *Budget
+	Message string
+}
+
+// parses categories given as "Food:3, Hotel:5, Transport" (weight 1 if omitted)
+// returns one part per day instead if days is greater than 0
+func parseBudgetParts(categories string, days int) ([]BudgetPart, error) {
+	var parts []BudgetPart
+	if days > 0 {
+		if days > 366 {
+			return nil, errors.New("days must be at most 366")
+		}
+		for i := 1; i <= days; i++ {
+			parts = append(parts, BudgetPart{Name: "Day " + strconv.Itoa(i), Weight: 1})
+		}
+		return parts, nil
+	}
+
+	for _, c := range strings.Split(categories, ",") {
+		nameWeight := strings.SplitN(c, ":", 2)
+		name := strings.TrimSpace(nameWeight[0])
+		if name == "" {
+			continue
+		}
+		weight := 1.0
+		if len(nameWeight) == 2 {
+			w, err := strconv.ParseFloat(strings.TrimSpace(nameWeight[1]), 64)
+			if err != nil || w <= 0 {
+				return nil, errors.New("invalid weight for category " + name)
+			}
+			weight = w
+		}
+		parts = append(parts, BudgetPart{Name: name, Weight: weight})
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("categories or days must be given")
+	}
+	return parts, nil
+}
+
+// returns the largest power of ten that is at most 1% of total, at least 1
+// e.g. 10 for 1100 USD and 1000 for 130500 JPY
+func cashUnit(total float64) float64 {
+	if total < 100 {
+		return 1
+	}
+	return math.Pow(10, math.Floor(math.Log10(total/100)))
+}
+
+// allocates the converted total to the parts by weight, rounding every part down to the unit
+func (b *Budget) split() {
+	var weights float64
+	for _, p := range b.Parts {
+		weights += p.Weight
+	}
+
+	allocated := 0.0
+	for i, p := range b.Parts {
+		b.Parts[i].Amount = math.Floor(b.Converted*p.Weight/weights/b.Unit) * b.Unit
+		allocated += b.Parts[i].Amount
+	}
+	b.Remainder = convert.RoundTo2Decimals(b.Converted - allocated)
+}
+
+// creates the budget given by the from, to, total, categories or days and optional round values
+func newBudget(d rates.Data, values url.Values) (Budget, error) {
+	from := currencyCode(values.Get("from"))
+	to := currencyCode(values.Get("to"))
+	d = withRetiredRates(d, from, to)
+	if !convert.Available(d, from, to) {
+		return Budget{}, fmt.Errorf("%w pair %s/%s", rates.ErrUnknownCurrency, from, to)
+	}
+	total, err := strconv.ParseFloat(values.Get("total"), 64)
+	if err != nil || total <= 0 {
+		return Budget{}, errors.New("total must be a positive number")
+	}
+	days := 0
+	if v := values.Get("days"); v != "" {
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 0 {
+			return Budget{}, errors.New("days must be a whole number")
+		}
+	}
+	parts, err := parseBudgetParts(values.Get("categories"), days)
+	if err != nil {
+		return Budget{}, err
+	}
+
+	b := Budget{
+		From:      from,
+		To:        to,
+		Total:     total,
+		Converted: convert.RoundTo2Decimals(convert.Convert(d, from, to, total)),
+		Parts:     parts,
+		Timestamp: d.Timestamp,
+		Time:      rfc3339(d.Timestamp),
+		Date:      d.Date,
+	}
+	b.Unit = cashUnit(b.Converted)
+	if v := values.Get("round"); v != "" {
+		b.Unit, err = strconv.ParseFloat(v, 64)
+		if err != nil || b.Unit <= 0 {
+			return Budget{}, errors.New("round must be a positive number")
+		}
+	}
+	b.split()
+	return b, nil
+}
+
+// writes the budget given in the url query as json
+func apiBudgetHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := newBudget(getCurrentData(r.Context()), r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, b)
+}
+
+// renders the budget form and the split of the budget given in the url query
+func budgetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("total") == "" {
+		renderTemplate(w, r, "budget", &BudgetPage{})
+		return
+	}
+
+	b, err := newBudget(getCurrentData(r.Context()), r.URL.Query())
+	if err != nil {
+		renderTemplate(w, r, "budget", &BudgetPage{Message: err.Error()})
+		return
+	}
+	renderTemplate(w, r, "budget", &BudgetPage{Budget: &b})
+}
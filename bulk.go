@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"currconv/pkg/convert"
+	"currconv/pkg/rates"
+)
+
+// maximum size of uploaded csv files, see bodyLimit
+const maxBulkSize = 10 << 20
+
+// BulkPage stores variables for /bulk/
+type BulkPage struct {
+	Message string
+	CSRF    string
+}
+
+// returns the index of the column named name in header (case insensitive), -1 if it is missing
+func columnIndex(header []string, name string) int {
+	for i, column := range header {
+		if strings.EqualFold(strings.TrimSpace(column), name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// BulkConversion converts the rows of a csv with amount and currency columns and an optional date column (YYYY-MM-DD)
+type BulkConversion struct {
+	// cancels fetching the rates of past days
+	ctx    context.Context
+	reader *csv.Reader
+	header []string
+	to     string
+	// column indexes in header, dateColumn is -1 if there is none
+	amountColumn, currencyColumn, dateColumn int
+	// rates of every date in the file, fetched once, "" maps to the current data
+	days map[string]rates.Data
+	// dates whose rates couldn't be fetched, they aren't tried again for later rows
+	failed map[string]bool
+	// number of dates that weren't stored in the history, at most getBulkMaxFetches are fetched from the provider
+	fetches int
+}
+
+// returns how many days missing from the history one bulk conversion may fetch, set by bulk_max_fetches (31)
+func getBulkMaxFetches() int {
+	n, err := strconv.Atoi(getEnv("bulk_max_fetches", "31"))
+	if err != nil || n < 0 {
+		log.Println("bulk_max_fetches must be a number")
+		return 31
+	}
+	return n
+}
+
+// reads the header of the csv in and checks that it can be converted into to with d
+// rows with a date are converted with the rates of that day, other rows with d
+func newBulkConversion(ctx context.Context, in io.Reader, d rates.Data, to string) (*BulkConversion, error) {
+	reader := csv.NewReader(in)
+	// rows with missing columns get an error instead of failing the whole file
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b := &BulkConversion{
+		ctx:            ctx,
+		reader:         reader,
+		header:         header,
+		to:             to,
+		amountColumn:   columnIndex(header, "amount"),
+		currencyColumn: columnIndex(header, "currency"),
+		dateColumn:     columnIndex(header, "date"),
+		days:           map[string]rates.Data{"": d},
+		failed:         make(map[string]bool),
+	}
+	if b.amountColumn < 0 || b.currencyColumn < 0 {
+		return nil, errors.New("the first row must name an amount and a currency column")
+	}
+	if _, ok := d.Rates[to]; !ok {
+		return nil, unknownCurrency(to)
+	}
+	return b, nil
+}
+
+// returns record with the columns to, rate, converted and error appended
+func (b *BulkConversion) convert(record []string) []string {
+	n := len(record)
+	// short rows are padded so the appended columns line up with the header
+	for len(record) < len(b.header) {
+		record = append(record, "")
+	}
+	row := append(record, b.to, "", "", "")
+	rateColumn, convertedColumn, errorColumn := len(record)+1, len(record)+2, len(record)+3
+
+	date := ""
+	if b.dateColumn >= 0 && b.dateColumn < n {
+		date = strings.TrimSpace(record[b.dateColumn])
+	}
+	day, ok := b.days[date]
+	if !ok {
+		if b.failed[date] {
+			row[errorColumn] = "no rates available for " + date
+			return row
+		}
+		if _, stored := serverFrom(b.ctx).history.get(date); !stored && date != "" {
+			if b.fetches >= getBulkMaxFetches() {
+				row[errorColumn] = "no rates available for " + date + ", the file has too many dates without stored rates"
+				return row
+			}
+			b.fetches++
+		}
+		var err error
+		day, err = dataFor(b.ctx, date)
+		if err != nil {
+			log.Println(err)
+			b.failed[date] = true
+			row[errorColumn] = "no rates available for " + date
+			return row
+		}
+		b.days[date] = day
+	}
+
+	if b.amountColumn >= n || b.currencyColumn >= n {
+		row[errorColumn] = "missing amount or currency"
+		return row
+	}
+	amount, err := strconv.ParseFloat(strings.TrimSpace(record[b.amountColumn]), 64)
+	currency := currencyCode(record[b.currencyColumn])
+	day = withRetiredRates(day, currency, b.to)
+	switch {
+	case err != nil:
+		row[errorColumn] = "amount must be a number"
+	case !convert.Available(day, currency, b.to):
+		row[errorColumn] = "unknown currency " + currency
+	default:
+		rate := midRate(day, currency, b.to)
+		row[rateColumn] = strconv.FormatFloat(rate, 'f', -1, 64)
+		row[convertedColumn] = strconv.FormatFloat(convert.RoundTo2Decimals(amount*rate), 'f', 2, 64)
+	}
+	return row
+}
+
+// writes the header and every converted row to out as soon as it is read
+// the status has already been sent when the rest of the file turns out to be unreadable,
+// so the problem is reported in the error column of a last row
+func (b *BulkConversion) writeTo(out io.Writer) error {
+	cw := csv.NewWriter(out)
+	cw.Write(append(b.header, "to", "rate", "converted", "error"))
+	for i := 1; ; i++ {
+		record, err := b.reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Println(err)
+			cw.Write(append(make([]string, len(b.header)+3), "the file could not be read further: "+err.Error()))
+			break
+		}
+		cw.Write(b.convert(record))
+		if i%1000 == 0 {
+			cw.Flush()
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// converts the csv sent as request body into the currency given by the to parameter and streams the augmented csv
+func apiBulkHandler(w http.ResponseWriter, r *http.Request) {
+	to := currencyCode(r.URL.Query().Get("to"))
+	b, err := newBulkConversion(r.Context(), r.Body, getCurrentData(r.Context()), to)
+	if bodyTooLarge(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	setCSVHeaders(w, "converted-"+to+".csv")
+	err = b.writeTo(w)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// renders the upload form and converts the uploaded csv file
+func bulkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderTemplate(w, r, "bulk", &BulkPage{CSRF: csrfToken(r)})
+		return
+	}
+
+	file, _, err := r.FormFile("file")
+	if bodyTooLarge(w, r, err) {
+		return
+	}
+	if err != nil {
+		renderTemplate(w, r, "bulk", &BulkPage{"Please choose a csv file of at most 10 MB.", csrfToken(r)})
+		return
+	}
+	defer file.Close()
+
+	to := currencyCode(r.FormValue("to"))
+	b, err := newBulkConversion(r.Context(), file, getCurrentData(r.Context()), to)
+	if err != nil {
+		renderTemplate(w, r, "bulk", &BulkPage{"The file could not be converted: " + err.Error(), csrfToken(r)})
+		return
+	}
+	setCSVHeaders(w, "converted-"+to+".csv")
+	err = b.writeTo(w)
+	if err != nil {
+		log.Println(err)
+	}
+}
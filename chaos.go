@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"currconv/pkg/rates"
+)
+
+// returns p wrapped so its requests are delayed by up to chaos_latency (e.g. 2s) and fail at
+// the rate set by chaos_failure_rate (0 to 1), p itself if neither is set
+// meant for checking the fallback and the serving of stale rates before a real outage
+func withChaos(p rates.Provider) rates.Provider {
+	latency, err := time.ParseDuration(getEnv("chaos_latency", "0s"))
+	if err != nil {
+		log.Println("chaos_latency:", err)
+		latency = 0
+	}
+	failureRate, err := strconv.ParseFloat(getEnv("chaos_failure_rate", "0"), 64)
+	if err != nil || failureRate < 0 || failureRate > 1 {
+		log.Println("chaos_failure_rate must be between 0 and 1")
+		failureRate = 0
+	}
+	if latency <= 0 && failureRate == 0 {
+		return p
+	}
+
+	log.Println("Chaos mode: provider requests are delayed by up to", latency, "and fail at a rate of", failureRate)
+	return &rates.Chaos{Provider: p, Latency: latency, FailureRate: failureRate}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// size of the charts drawn as svg
+const chartWidth, chartHeight = 600, 240
+
+// ChartPage stores variables for /chart/
+type ChartPage struct {
+	From string
+	To   string
+	Days int
+	// svg polyline of the rates, empty if there is nothing to draw
+	Points string
+	Width  int
+	Height int
+	Min    float64
+	Max    float64
+	First  TimeseriesPoint
+	Last   TimeseriesPoint
+	// moving averages drawn over the rates, chosen with the average parameter
+	Averages []ChartLine
+	Options  []AverageOption
+}
+
+// ChartLine is a series drawn over the rates of a chart
+type ChartLine struct {
+	Name string
+	// css class giving the line and its legend their color
+	Class  string
+	Points string
+}
+
+// AverageOption is a moving average the chart page offers to draw
+type AverageOption struct {
+	Average
+	Selected bool
+}
+
+// css classes coloring the moving averages on a chart in the order they are chosen, defined in style.css
+// since the content security policy doesn't allow inline styles
+var averageClasses = []string{"average1", "average2", "average3", "average4", "average5", "average6"}
+
+// returns the lowest and highest of values
+func valueRange(values []float64) (float64, float64) {
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	return min, max
+}
+
+// returns the points of an svg polyline drawing values as the last of days evenly spaced over the width of the chart,
+// min at its bottom and max at its top
+func chartPoints(values []float64, days int, min float64, max float64) string {
+	points := make([]string, len(values))
+	offset := days - len(values)
+	for i, v := range values {
+		x := 0.0
+		if days > 1 {
+			x = float64(offset+i) / float64(days-1) * chartWidth
+		}
+		y := chartHeight / 2.0
+		if max > min {
+			y = chartHeight - (v-min)/(max-min)*chartHeight
+		}
+		points[i] = strconv.FormatFloat(x, 'f', 1, 64) + "," + strconv.FormatFloat(y, 'f', 1, 64)
+	}
+	return strings.Join(points, " ")
+}
+
+// returns the days parameter of r, 90 by default and at most 10 years
+func chartDays(r *http.Request) int {
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || days < 2 {
+		return 90
+	}
+	if days > 3650 {
+		return 3650
+	}
+	return days
+}
+
+// returns the first day of a range of days ending today, YYYY-MM-DD
+func rangeStart(days int) string {
+	return time.Now().UTC().AddDate(0, 0, -days+1).Format("2006-01-02")
+}
+
+// returns the moving averages given by the average parameters of r, e.g. average=sma30&average=ema7
+func chartAverages(r *http.Request) []Average {
+	var averages []Average
+	for _, param := range r.URL.Query()["average"] {
+		a, err := parseAverage(param)
+		if err == nil && len(averages) < len(averageClasses) {
+			averages = append(averages, a)
+		}
+	}
+	return averages
+}
+
+// returns the values of points
+func pointRates(points []TimeseriesPoint) []float64 {
+	values := make([]float64, len(points))
+	for i, p := range points {
+		values[i] = p.Rate
+	}
+	return values
+}
+
+// draws the stored daily rates of the pair in the path over the last days given by the days parameter
+// and the moving averages given by the average parameters
+func chartHandler(w http.ResponseWriter, r *http.Request) {
+	from := currencyCode(pathParam(r, "from"))
+	to := currencyCode(pathParam(r, "to"))
+	if err := checkCurrencies(getCurrentData(r.Context()), from, to); err != nil {
+		failRequest(w, r, err)
+		return
+	}
+
+	p := ChartPage{From: from, To: to, Days: chartDays(r), Width: chartWidth, Height: chartHeight}
+	start := rangeStart(p.Days)
+	series := timeseries(r.Context(), from, to, start, "")
+	averages := chartAverages(r)
+	for _, t := range []string{"sma", "ema"} {
+		for _, window := range averageWindows {
+			option := AverageOption{Average: Average{t, window}}
+			for _, a := range averages {
+				option.Selected = option.Selected || a == option.Average
+			}
+			p.Options = append(p.Options, option)
+		}
+	}
+	if len(series) == 0 {
+		renderTemplate(w, r, "chart", &p)
+		return
+	}
+
+	values := pointRates(series)
+	p.Min, p.Max = valueRange(values)
+	p.First, p.Last = series[0], series[len(series)-1]
+	lines := make([][]float64, len(averages))
+	scale := append([]float64{}, values...)
+	for i, a := range averages {
+		lines[i] = pointRates(movingAverage(r.Context(), a, from, to, start, ""))
+		scale = append(scale, lines[i]...)
+	}
+	// the averages lag behind the rates and may leave their range
+	min, max := valueRange(scale)
+	p.Points = chartPoints(values, len(values), min, max)
+	for i, a := range averages {
+		if len(lines[i]) > 0 {
+			p.Averages = append(p.Averages, ChartLine{a.Name(), averageClasses[i], chartPoints(lines[i], len(values), min, max)})
+		}
+	}
+	renderTemplate(w, r, "chart", &p)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"currconv/pkg/client"
+	"currconv/pkg/convert"
+	"currconv/pkg/rates"
+)
+
+// runs the command line subcommand name with the given arguments instead of starting the web server
+// commands that don't ask a running server fetch the rates from p
+func runCommand(p rates.Provider, name string, args []string) {
+	switch name {
+	case "backfill":
+		backfillCommand(p, args)
+	case "convert":
+		convertCommand(p, args)
+	case "rates":
+		ratesCommand(p, args)
+	case "service":
+		serviceCommand(p, args)
+	case "watch":
+		watchCommand(p, args)
+	default:
+		fmt.Fprintln(os.Stderr, "unknown command "+name)
+		fmt.Fprintln(os.Stderr, "usage: currencyconverter [--provider=NAME] [backfill|convert|rates|service|watch]")
+		os.Exit(2)
+	}
+}
+
+// fetches the rates of every day between --start and --end from the historical endpoint of p
+// and stores them in the history, waiting --delay between requests to respect the API's rate limit
+// a running server keeps its history in memory and would overwrite the backfilled days with its next refresh,
+// so the days are fetched by the server given by --server then, through /admin/backfill with admin_token
+func backfillCommand(p rates.Provider, args []string) {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	startFlag := flags.String("start", "", "first date to fetch (YYYY-MM-DD)")
+	endFlag := flags.String("end", time.Now().Format("2006-01-02"), "last date to fetch (YYYY-MM-DD)")
+	delay := flags.Duration("delay", time.Second, "time to wait between API requests")
+	force := flags.Bool("force", false, "fetch dates that are already stored again")
+	server := flags.String("server", "", "base url of a running server to backfill, e.g. http://localhost:8080")
+	flags.Parse(args)
+
+	start, err := time.Parse("2006-01-02", *startFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "--start must be given as YYYY-MM-DD")
+		os.Exit(2)
+	}
+	end, err := time.Parse("2006-01-02", *endFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "--end must be given as YYYY-MM-DD")
+		os.Exit(2)
+	}
+
+	if *server != "" {
+		if err := backfillServer(*server, *startFlag, *endFlag, *delay, *force); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		log.Println("The server is backfilling its history, see its log for the progress")
+		return
+	}
+
+	release, ok := acquireBackfillLease()
+	if !ok {
+		fmt.Fprintln(os.Stderr, "another replica is backfilling")
+		os.Exit(1)
+	}
+	defer release()
+
+	history := loadHistory(getEnv("history_file", "history.json"))
+	if history.unreadable {
+		fmt.Fprintln(os.Stderr, "the history file can't be read, repair or remove it first")
+		os.Exit(1)
+	}
+	backfill(context.Background(), p, history, start, end, *delay, *force)
+}
+
+// asks the server at baseURL to backfill its history between start and end with the admin token
+func backfillServer(baseURL string, start string, end string, delay time.Duration, force bool) error {
+	form := url.Values{"start": {start}, "end": {end}, "delay": {delay.String()}, "force": {strconv.FormatBool(force)}}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(baseURL, "/")+"/admin/backfill", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("admin_token"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("the server answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// takes the lease replicas sharing a Redis server backfill under, so they don't fetch the same days twice
+// release gives it up, ok is false if another replica holds it
+func acquireBackfillLease() (release func(), ok bool) {
+	if sharedRedis == nil {
+		return func() {}, true
+	}
+	lease := newLease("backfill", time.Minute)
+	if !lease.acquire(context.Background()) {
+		return nil, false
+	}
+	done := make(chan struct{})
+	go lease.keep(done)
+	return func() {
+		close(done)
+		lease.release()
+	}, true
+}
+
+// fetches the rates of every day between start and end from p into history, waiting delay between requests
+// days already stored are skipped unless force is set
+// after a RateLimitError the day is fetched again once the provider accepts requests, the backfill stops when ctx ends
+func backfill(ctx context.Context, p rates.Provider, history *History, start time.Time, end time.Time, delay time.Duration, force bool) {
+	fetched := 0
+	for day := start; !day.After(end) && ctx.Err() == nil; day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		if _, ok := history.get(date); ok && !force {
+			continue
+		}
+
+		if fetched > 0 {
+			time.Sleep(delay)
+		}
+		fetched++
+
+		d, err := p.Historical(ctx, date)
+		var limited *rates.RateLimitError
+		for errors.As(err, &limited) {
+			log.Println("Rate limited, continuing at", limited.RetryAt.Format(time.RFC3339))
+			select {
+			case <-time.After(time.Until(limited.RetryAt)):
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			d, err = p.Historical(ctx, date)
+		}
+		if err != nil {
+			log.Println("Could not fetch", date+":", err)
+			continue
+		}
+
+		history.add(d)
+		log.Println("Fetched", date)
+
+		// save regularly so an interrupted backfill doesn't lose its progress
+		if fetched%30 == 0 {
+			history.save()
+		}
+	}
+
+	history.save()
+	log.Println("Backfill done,", fetched, "days fetched")
+}
+
+// parses flags that may be given before or after the positional arguments
+// returns the positional arguments
+func parseFlags(flags *flag.FlagSet, args []string, positional int) []string {
+	flags.Parse(args)
+	rest := flags.Args()
+	if len(rest) > positional {
+		flags.Parse(rest[positional:])
+		rest = rest[:positional]
+	}
+	return rest
+}
+
+// asks the API of the server at baseURL to convert amount of from into to
+func convertWithServer(baseURL string, from string, to string, amount string) (Conversion, error) {
+	value, err := parseAmount(amount)
+	if err != nil {
+		return Conversion{}, err
+	}
+
+	c, err := client.New(baseURL, os.Getenv("api_key")).Convert(context.Background(), from, to, value)
+	if err != nil {
+		return Conversion{}, err
+	}
+	result := Conversion{
+		From:       c.From,
+		To:         c.To,
+		Amount:     c.Amount,
+		Rate:       c.Rate,
+		Side:       c.Side,
+		Result:     c.Result,
+		BaseResult: c.BaseResult,
+		Timestamp:  c.Timestamp,
+		Time:       c.Time,
+		Date:       c.Date,
+		Quote:      c.Quote,
+	}
+	if c.Markup != nil {
+		result.Markup = &AppliedMarkup{c.Markup.Percent, c.Markup.Fee, c.Markup.Amount, c.Markup.Round}
+	}
+	return result, nil
+}
+
+// converts amount of from into to with rates fetched directly from p
+func convertWithProvider(p rates.Provider, from string, to string, amount string) (Conversion, error) {
+	d, err := p.Latest(context.Background())
+	if err != nil {
+		return Conversion{}, err
+	}
+	value, err := parseConversion(d, from, to, amount)
+	if err != nil {
+		return Conversion{}, err
+	}
+	return newConversion(d, from, to, value, convert.Mid, getMarkup(), defaultPreferences), nil
+}
+
+// converts an amount and prints the result, e.g. "convert 100 USD EUR"
+// uses the API of a running server if --server is given and fetches rates from p otherwise
+func convertCommand(p rates.Provider, args []string) {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	server := flags.String("server", "", "base url of a running server to use, e.g. http://localhost:8080")
+	asJSON := flags.Bool("json", false, "print the result as json")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: currencyconverter convert [flags] AMOUNT FROM TO")
+		flags.PrintDefaults()
+	}
+
+	rest := parseFlags(flags, args, 3)
+	if len(rest) != 3 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	amount, from, to := rest[0], currencyCode(rest[1]), currencyCode(rest[2])
+
+	var c Conversion
+	var err error
+	if *server != "" {
+		c, err = convertWithServer(*server, from, to, amount)
+	} else {
+		c, err = convertWithProvider(p, from, to, amount)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(c)
+		return
+	}
+	fmt.Printf("%g %s = %.2f %s\n", c.Amount, c.From, c.Result, c.To)
+}
+
+// returns the current rates from the API of the server at baseURL, or directly from p if baseURL is empty
+func loadRates(p rates.Provider, baseURL string) (rates.Data, error) {
+	if baseURL == "" {
+		return p.Latest(context.Background())
+	}
+
+	t, err := client.New(baseURL, os.Getenv("api_key")).Rates(context.Background())
+	if err != nil {
+		return rates.Data{}, err
+	}
+	return rates.Data{Success: true, Timestamp: t.Timestamp, Base: t.Base, Date: t.Date, Rates: t.Rates}, nil
+}
+
+// prints the current rate table, one currency per line
+func ratesCommand(p rates.Provider, args []string) {
+	flags := flag.NewFlagSet("rates", flag.ExitOnError)
+	server := flags.String("server", "", "base url of a running server to use, e.g. http://localhost:8080")
+	flags.Parse(args)
+
+	d, err := loadRates(p, *server)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Println("Rates in", d.Base, "of", time.Unix(d.Timestamp, 0).Format("2006-01-02 15:04:05"))
+	for _, currency := range sortedCurrencies(d) {
+		fmt.Printf("%s  %12.6f\n", currency, d.Rates[currency])
+	}
+}
+
+// prints the rate of a pair every --interval, e.g. "watch EUR/USD --interval 1m"
+// without --server p is only asked once the rates are older than --max-age, as the server does,
+// so watching doesn't use up the quota of the provider
+func watchCommand(p rates.Provider, args []string) {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	server := flags.String("server", "", "base url of a running server to use, e.g. http://localhost:8080")
+	interval := flags.Duration("interval", time.Minute, "time between refreshes")
+	maxAge := flags.Duration("max-age", time.Hour, "age after which the rates are fetched again without --server")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: currencyconverter watch [flags] FROM/TO")
+		flags.PrintDefaults()
+	}
+
+	rest := parseFlags(flags, args, 1)
+	if len(rest) != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cache := rates.NewCache(p, *maxAge)
+	last := 0.0
+	for {
+		var d rates.Data
+		var err error
+		if *server == "" {
+			d, err = cache.Get(context.Background())
+		} else {
+			d, err = loadRates(p, *server)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		} else if from, to, ok := parsePair(d, rest[0]); !ok {
+			fmt.Fprintln(os.Stderr, "pair must be given as FROM/TO, e.g. EUR/USD")
+			os.Exit(2)
+		} else {
+			rate := convert.Convert(d, from, to, 1)
+			change := ""
+			if last != 0 {
+				change = fmt.Sprintf("  (%+.6f)", rate-last)
+			}
+			fmt.Printf("%s  %s/%s  %.6f%s\n", time.Now().Format("15:04:05"), from, to, rate, change)
+			last = rate
+		}
+		time.Sleep(*interval)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+)
+
+// ComparePage stores variables for /compare/
+type ComparePage struct {
+	// the submitted input, shown again in the form
+	From    string
+	Amount  string
+	To      string
+	Group   string
+	Groups  []CurrencyGroup
+	Result  *MultiConversion
+	Message string
+}
+
+// converts the amount parameter of from into every currency of the to parameter (comma separated) or of the group parameter
+// and renders them as a table, backed by the same conversion as /api/convert/multi
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	p := ComparePage{
+		From:   currencyCode(getQueryDefault(query.Get("from"), "USD")),
+		Amount: getQueryDefault(query.Get("amount"), "100"),
+		To:     query.Get("to"),
+		Group:  query.Get("group"),
+		Groups: currencyGroups,
+	}
+	if p.To == "" && p.Group == "" {
+		renderTemplate(w, r, "compare", &p)
+		return
+	}
+
+	d, err := dataFor(r.Context(), "")
+	if err != nil {
+		failRequest(w, r, err)
+		return
+	}
+	amount, err := parseAmount(p.Amount)
+	targets, ok := multiTargets(r)
+	d = withRetiredRates(d, append([]string{p.From}, targets...)...)
+	switch {
+	case err != nil:
+		p.Message = ErrInvalidAmount.Error()
+	case checkCurrencies(d, p.From) != nil:
+		p.Message = unknownCurrency(p.From).Error()
+	case !ok:
+		p.Message = "unknown group " + p.Group
+	}
+	if p.Message != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, "compare", &p)
+		return
+	}
+
+	m := newMultiConversion(d, p.From, amount, targets, markupFor(r), preferencesFor(r))
+	p.Result = &m
+	setCacheHeaders(w, r, d)
+	renderTemplate(w, r, "compare", &p)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"sync"
+
+	"currconv/pkg/convert"
+	"currconv/pkg/rates"
+)
+
+// pairwise rates of the most used currencies, computed on every refresh
+var crossRates = struct {
+	sync.Mutex
+	table *convert.CrossRates
+}{}
+
+// computes the cross rates of the currencies in cross_currencies from d
+func updateCrossRates(d rates.Data) {
+	table := convert.NewCrossRates(d, splitCurrencies(getEnv("cross_currencies", "USD,EUR,JPY,GBP,CNY,CHF,AUD,CAD,HKD,SGD")))
+
+	crossRates.Lock()
+	defer crossRates.Unlock()
+	crossRates.table = table
+}
+
+// returns the mid-market rate of from in to, precomputed if d is the current data and the pair is among cross_currencies
+func midRate(d rates.Data, from string, to string) float64 {
+	crossRates.Lock()
+	table := crossRates.table
+	crossRates.Unlock()
+
+	return table.Rate(d, from, to)
+}
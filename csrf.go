@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+)
+
+// name of the form field repeating the token of the session
+const csrfField = "csrf_token"
+
+// key of the request's token in its context
+type csrfKey struct{}
+
+// returns a new random token
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Println(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// returns the token forms rendered for r must include as csrf_token field, empty if r isn't protected
+func csrfToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
+
+// wraps h so unsafe requests are only accepted if their csrf_token field or X-CSRF-Token header
+// matches the token of the session, which is created on the first visit
+func csrfProtect(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := getSession(r)
+		token := session.CSRF
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			sent := r.Header.Get("X-CSRF-Token")
+			if sent == "" {
+				if err := parseForm(r); bodyTooLarge(w, r, err) {
+					return
+				}
+				sent = r.FormValue(csrfField)
+			}
+			if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				w.WriteHeader(http.StatusForbidden)
+				renderTemplate(w, r, "error", &ErrorPage{"Form expired", "Please go back, reload the page and submit the form again."})
+				return
+			}
+		}
+
+		if token == "" {
+			token = newCSRFToken()
+			session.CSRF = token
+			saveSession(w, r)
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), csrfKey{}, token)))
+	}
+}
@@ -1,123 +1,135 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"html/template"
-	"io/ioutil"
 	"log"
-	"math"
 	"net/http"
+	"net/url"
 	"os"
-	"strconv"
-	"time"
+	"strings"
+
+	"currconv/pkg/convert"
+	"currconv/pkg/rates"
 )
 
 var apiKey string = os.Getenv("fixer_api_key")
-var data Data
-
-// cache templates for later use
-var templates = template.Must(template.ParseFiles("index.html", "convert.html", "contact.html", "about.html"))
-
-// Data stores data from api request for re-use
-type Data struct {
-	Success bool
-	// timestamp of api request
-	Timestamp int64
-	// base currency for calculations
-	Base string
-	Date string
-	// maps currency identifiers to their value in base currency
-	Rates map[string]float64
-}
 
-// calculates how much "amount" of curr1 is worth in curr2
-func (data Data) convert(curr1 string, curr2 string, amount float64) float64 {
-	euroAmount := amount / data.Rates[curr1]
-	return euroAmount * data.Rates[curr2]
+// the directory of the executable when it runs as a Windows service, entered before the templates, locales and
+// rates file are read relative to the working directory, "" otherwise
+var serviceDir = enterServiceDir()
+
+// registers the fixer and file providers, fixer's responses are recorded to the directory record if it is set
+// or replayed from the directory replay, without a fixer key it uses the rates file unless its responses are replayed
+func registerProviders(record string, replay string) {
+	rates.RegisterProvider("fixer", func() (rates.Provider, error) {
+		if ratesFile != nil && apiKey == "" && replay == "" {
+			return ratesFile, nil
+		}
+		return newFixer(record, replay), nil
+	})
+	rates.RegisterProvider("file", func() (rates.Provider, error) {
+		if ratesFile == nil {
+			return nil, errors.New("rates_file must be set for the file provider")
+		}
+		return ratesFile, nil
+	})
 }
 
-// returns data if less than 1 hour has passed since data.Timestamp
-// returns newly fetched API data otherwise
-func (data Data) update() Data {
-	timestamp := time.Unix(data.Timestamp, 0)
-	timePassed := time.Since(timestamp)
-	if timePassed.Hours() > 1 {
-		// only update if data is older than 1 hour to limit API requests made
-		log.Println("Data is older than 1 hour, refreshing")
-		b := getData()
-		log.Println(string(b))
-		d := decodeJSON(b)
-		return d
+// returns the registered provider named name, exits if there is none
+// besides fixer, file and mock that can be providers compiled in by importing a package that registers them
+func newProvider(name string) rates.Provider {
+	p, err := rates.NewProvider(name)
+	if errors.Is(err, rates.ErrUnknownProvider) {
+		fmt.Fprintln(os.Stderr, "unknown provider "+name+", use one of "+strings.Join(rates.Providers(), ", "))
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-	return data
+	return p
 }
 
-// Page stores variables for /convert/
-type Page struct {
-	From   string
-	To     string
-	Value  float64
-	Result float64
-	Time   string
+// returns the fixer provider, requesting the base currency set by fixer_base if the plan supports it
+// its responses are recorded to the directory record if it is set, or replayed from the directory replay
+// instead of being requested
+func newFixer(record string, replay string) *rates.Fixer {
+	f := rates.NewFixer(apiKey)
+	f.Base = strings.ToUpper(os.Getenv("fixer_base"))
+	if replay != "" {
+		f.Client.Transport = &rates.Replayer{Dir: replay}
+	} else if record != "" {
+		f.Client.Transport = &rates.Recorder{Dir: record}
+	}
+	return f
 }
 
-// sends an API request to fixer to get currency conversion data
-// returns string containing json
-func getData() []byte {
-	resp, err := http.Get("http://data.fixer.io/api/latest?access_key=" + apiKey)
-	if err != nil {
-		log.Println(err)
+// precomputes the cross rates, remembers new data for trending, stores it in the history and as snapshot and notifies webhook and MQTT subscribers
+// called by the cache of the server of ctx after every refresh
+func onRefresh(ctx context.Context, d rates.Data) {
+	log.Println("Rates refreshed, timestamp", d.Timestamp)
+	updateCrossRates(d)
+	refreshes.record(d)
+	// the rates of the file aren't the closing rates of its date
+	if !d.Static {
+		serverFrom(ctx).history.record(d)
 	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
+	saveSnapshot(d)
+	go notifyWebhooks(d)
+	go publishMQTT(d)
+	go notifyAlerts(ctx, d)
+}
 
+// returns the current data of the server of ctx, refreshing it first if it is outdated
+// the refresh is canceled with ctx, e.g. when the client of the request disconnects
+func getCurrentData(ctx context.Context) rates.Data {
+	d, err := serverFrom(ctx).cache.Get(ctx)
 	if err != nil {
-		log.Println(err)
+		logError(err)
 	}
-
-	return body
+	return withDerivedRates(ctx, d)
 }
 
-// takes json as returned by getData() and creates Data struct with corresponding values
-func decodeJSON(b []byte) Data {
-	// can't decode directly from JSON to Data struct because of map[string]float64 field
-	var i interface{}
-
-	err := json.Unmarshal(b, &i)
+// returns d with the rates derived from it added, those of the virtual currencies of the server of ctx
+// retired currencies are added by withRetiredRates for the conversions that ask for them
+func withDerivedRates(ctx context.Context, d rates.Data) rates.Data {
+	return withVirtualRates(ctx, d)
+}
 
+// replaces the current data of the server of ctx with newly fetched API data regardless of its age
+func refreshCurrentData(ctx context.Context) {
+	_, err := serverFrom(ctx).cache.Refresh(ctx)
 	if err != nil {
-		log.Fatalln(err)
+		logError(err)
 	}
-
-	m := i.(map[string]interface{})
-
-	success := m["success"].(bool)
-	timestamp := int64(m["timestamp"].(float64))
-	base := m["base"].(string)
-	date := m["date"].(string)
-	ratesInterface := m["rates"].(map[string]interface{})
-
-	rates := make(map[string]float64)
-
-	for key, value := range ratesInterface {
-		rates[key] = value.(float64)
-	}
-
-	data := Data{success, timestamp, base, date, rates}
-
-	return data
 }
 
-// rounds float to 2 places after decimal point
-func roundTo2Decimals(x float64) float64 {
-	return (math.Round(x*100) / 100)
+// Page stores variables for /convert/
+type Page struct {
+	From   string
+	To     string
+	Value  float64
+	Result float64
+	Time   string
+	// result at the mid-market rate and markup charged, Markup is nil if no markup is configured
+	BaseResult float64
+	Markup     *AppliedMarkup
+	// day whose rates were used, empty for the current rates
+	Date string
+	CSRF string
+	// whether the rates were read from the rates file instead of being fetched
+	Static bool
+	// digits after the decimal point of the results
+	Decimals int
 }
 
-// executes template tmpl.html using ResponseWriter w
-func renderTemplate(w http.ResponseWriter, tmpl string, p *Page) {
-	err := templates.ExecuteTemplate(w, tmpl+".html", p)
+// executes template tmpl.html in the locale requested by r using ResponseWriter w
+func renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, p interface{}) {
+	persistLocale(w, r)
+	err := serverFrom(r.Context()).templatesFor(getLocale(r)).ExecuteTemplate(w, tmpl+".html", p)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
@@ -126,71 +138,135 @@ func renderTemplate(w http.ResponseWriter, tmpl string, p *Page) {
 // generates a generic handler function that renders a template
 func makeGenericHandler(tmpl string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		renderTemplate(w, tmpl, nil)
+		renderTemplate(w, r, tmpl, nil)
 	}
 }
 
-// extracts variables from url query and uses them for currency conversion calculation
-// renders convert template
+// extracts variables from the path (/convert/{from}/{to}/{value}) or url query and uses them for currency conversion calculation
+// renders convert template, or writes the conversion as json if the Accept header asks for it
 func convertHandler(w http.ResponseWriter, r *http.Request) {
-	data = data.update()
-
-	from := r.URL.Query()["from"][0]
-	to := r.URL.Query()["to"][0]
-	value, err := strconv.ParseFloat(r.URL.Query()["value"][0], 8)
-
-	// check if conversion rates are available for both currencies
-	_, okFrom := data.Rates[from]
-	_, okTo := data.Rates[to]
-	if err != nil || !okFrom || !okTo {
-		// redirect if float entered was invalid or the chosen currencies are unavailable (only happens if URL is modified manually)
-		http.Redirect(w, r, "/", 302)
+	w.Header().Add("Vary", "Accept")
+
+	// use the rates of a past day if a date is given
+	date := r.URL.Query().Get("date")
+	data, err := dataFor(r.Context(), date)
+	if err != nil {
+		failRequest(w, r, err)
 		return
 	}
 
-	time := fmt.Sprint(time.Unix(data.Timestamp, 0))
+	from := currencyCode(getParam(r, "from"))
+	to := currencyCode(getParam(r, "to"))
+	// the currencies are only unavailable if the url was modified manually
+	data = withRetiredRates(data, from, to)
+	value, err := parseConversion(data, from, to, getParam(r, "value"))
+	if err != nil {
+		failRequest(w, r, err)
+		return
+	}
+
+	prefs := preferencesFor(r)
+	c := newConversion(data, from, to, value, convert.Mid, markupFor(r), prefs)
+	if wantsJSON(r) {
+		writeJSON(w, c)
+		return
+	}
 
-	result := data.convert(from, to, value)
-	result = roundTo2Decimals(result)
+	time := formatTimestamp(w, r, data.Timestamp)
 
-	p := Page{from, to, value, result, time}
+	p := Page{from, to, value, c.Result, time, c.BaseResult, c.Markup, date, csrfToken(r), data.Static, prefs.Decimals}
 
-	renderTemplate(w, "convert", &p)
+	renderTemplate(w, r, "convert", &p)
 }
 
-// evaluates form data and redirects to /convert/ page with corresponding url parameters
+// evaluates form data and redirects to the /convert/{from}/{to}/{value} page of the conversion
+// invalid input is shown again in the form of the index page with the errors of its fields
 func redirectHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
-	from := r.Form["from"][0]
-	to := r.Form["to"][0]
-	value := r.Form["value"][0]
+	from := currencyCode(r.Form.Get("from"))
+	to := currencyCode(r.Form.Get("to"))
+	value := strings.TrimSpace(r.Form.Get("value"))
+	date := r.Form.Get("date")
+
+	if errs := checkConversionForm(r.Context(), getLocale(r).Language, from, to, value, date); errs.any() {
+		renderIndex(w, r, IndexPage{From: from, To: to, Value: value, Date: date, Errors: errs})
+		return
+	}
 
-	url := "/convert/?from=" + from + "&to=" + to + "&value=" + value
+	target := "/convert/" + url.PathEscape(from) + "/" + url.PathEscape(to) + "/" + url.PathEscape(value)
+	if date != "" {
+		target += "?date=" + url.QueryEscape(date)
+	}
 
-	http.Redirect(w, r, url, 302)
+	http.Redirect(w, r, target, 302)
 }
 
 // returns PORT environment variable or 8080 by default
 func getPort() string {
-	port := os.Getenv("PORT")
-	if port == "" {
-		return "8080"
+	return getEnv("PORT", "8080")
+}
+
+// returns the environment variable key or fallback if it is not set
+func getEnv(key string, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
 	}
-	return port
+	return value
 }
 
 func main() {
-	b := getData()
-	data = decodeJSON(b)
-
-	http.HandleFunc("/", makeGenericHandler("index"))
-	http.HandleFunc("/convert/", convertHandler)
-	http.HandleFunc("/redirect/", redirectHandler)
-	http.HandleFunc("/about/", makeGenericHandler("about"))
-	http.HandleFunc("/contact/", makeGenericHandler("contact"))
+	setupLogging()
+
+	// flags given before the command apply to the server and every command
+	flags := flag.NewFlagSet("currencyconverter", flag.ExitOnError)
+	providerName := flags.String("provider", getEnv("rates_provider", "fixer"), "source of the rates: fixer, file, mock or another registered provider")
+	record := flags.String("record", getEnv("record_dir", ""), "directory to record the responses of fixer to")
+	replay := flags.String("replay", getEnv("replay_dir", ""), "directory to replay recorded responses of fixer from instead of requesting them")
+	flags.Parse(os.Args[1:])
+	registerProviders(*record, *replay)
+	p := withAnomalyCheck(withPegs(withSharedRefresh(withChaos(instrument(*providerName, newProvider(*providerName))))))
+
+	if flags.NArg() > 0 {
+		runCommand(p, flags.Arg(0), flags.Args()[1:])
+		return
+	}
 
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static"))))
+	if err := runServer(p); err != nil {
+		log.Fatal(err)
+	}
+}
 
+// serves the site with the rates of p on the port set by PORT until the server fails or is asked to stop
+// by SIGTERM or an interrupt, in which case it is shut down gracefully and nil is returned
+// returns an error if the configuration can't be read
+func runServer(p rates.Provider) error {
+	cfg, err := configFromEnv()
+	if err != nil {
+		return err
+	}
+	warnSessionKey()
+	warnWebhookSecret()
+	handler, err := NewServer(cfg, p, loadStore())
+	if err != nil {
+		return err
+	}
 	port := getPort()
-	log.Fatal(http.ListenAndServe(":" + port, nil))
-}
\ No newline at end of file
+
+	stop := shutdownSignals()
+	failed := make(chan error, 1)
+	go func() {
+		if tlsEnabled() {
+			failed <- listenAndServeTLS(port, handler)
+			return
+		}
+		failed <- listenAndServe(port, handler)
+	}()
+	select {
+	case err := <-failed:
+		return err
+	case <-stop:
+		shutdown()
+		return nil
+	}
+}
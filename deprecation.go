@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Deprecation marks an endpoint, or a top-level field of its json responses, that will be removed in a future API version
+type Deprecation struct {
+	// pattern of the route, e.g. /api/rate/{from}/{to}
+	Route string
+	// field of the json response, empty if the whole endpoint is deprecated
+	Field string
+	// when it was deprecated and when it will be removed, Sunset is zero while that isn't decided
+	Since  time.Time
+	Sunset time.Time
+	// what to use instead
+	Message string
+	// documentation of the replacement, sent in the Link header of deprecated endpoints
+	Link string
+}
+
+// the endpoints and fields clients are warned about
+var deprecations = []Deprecation{
+	{
+		Route:   "/api/status",
+		Field:   "static",
+		Since:   time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC),
+		Message: "use overrides, which lists rates_file while the rates are read from the rates file",
+	},
+}
+
+// APIWarning is an entry of the warnings added to json responses using deprecated endpoints or fields
+type APIWarning struct {
+	Type string `json:"type"`
+	// empty if the whole endpoint is deprecated
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+	Sunset  string `json:"sunset,omitempty"`
+}
+
+// returns the warning about d
+func (d Deprecation) warning() APIWarning {
+	w := APIWarning{Type: "deprecation", Field: d.Field, Message: d.Message}
+	if !d.Sunset.IsZero() {
+		w.Sunset = d.Sunset.UTC().Format(time.RFC3339)
+	}
+	return w
+}
+
+// DeprecationWriter adds the deprecations of the route a request was dispatched to to its response
+// the route is only known once the handler starts writing, json bodies are buffered to add the warnings to them
+type DeprecationWriter struct {
+	http.ResponseWriter
+	route   *string
+	started bool
+	// deprecations of the route, nil if there are none
+	matched []Deprecation
+	// whether the body is buffered
+	buffered bool
+	status   int
+	body     bytes.Buffer
+}
+
+// looks up the deprecations of the route and sets the headers of a deprecated endpoint
+// Deprecation (RFC 9745), Sunset (RFC 8594) and a Link to the documentation of its replacement
+func (dw *DeprecationWriter) start() {
+	dw.started = true
+	if dw.route == nil {
+		return
+	}
+	for _, d := range deprecations {
+		if d.Route != *dw.route {
+			continue
+		}
+		dw.matched = append(dw.matched, d)
+		if d.Field != "" {
+			continue
+		}
+		h := dw.Header()
+		h.Set("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10))
+		if !d.Sunset.IsZero() {
+			h.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if d.Link != "" {
+			h.Add("Link", "<"+d.Link+`>; rel="deprecation"`)
+		}
+	}
+	if len(dw.matched) > 0 && strings.HasPrefix(dw.Header().Get("Content-Type"), "application/json") {
+		dw.buffered = true
+		dw.Header().Del("Content-Length")
+	}
+}
+
+func (dw *DeprecationWriter) WriteHeader(status int) {
+	if !dw.started {
+		dw.start()
+	}
+	if dw.buffered {
+		if dw.status == 0 {
+			dw.status = status
+		}
+		return
+	}
+	dw.ResponseWriter.WriteHeader(status)
+}
+
+func (dw *DeprecationWriter) Write(b []byte) (int, error) {
+	if !dw.started {
+		dw.start()
+	}
+	if dw.buffered {
+		return dw.body.Write(b)
+	}
+	return dw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController flush streamed responses
+func (dw *DeprecationWriter) Unwrap() http.ResponseWriter {
+	return dw.ResponseWriter
+}
+
+// writes the buffered body with the warnings about the deprecated endpoint and the deprecated fields it contains
+// bodies that aren't a json object are written as they are
+func (dw *DeprecationWriter) finish() {
+	if !dw.buffered {
+		return
+	}
+	if dw.status == 0 {
+		dw.status = http.StatusOK
+	}
+	body := dw.body.Bytes()
+	var fields map[string]json.RawMessage
+	var warnings []APIWarning
+	if json.Unmarshal(body, &fields) == nil {
+		for _, d := range dw.matched {
+			if _, ok := fields[d.Field]; d.Field == "" || ok {
+				warnings = append(warnings, d.warning())
+			}
+		}
+	}
+	if b, err := json.Marshal(warnings); len(warnings) > 0 && err == nil {
+		// added before the closing brace so the order of the other fields is kept
+		trimmed := bytes.TrimRight(body, " \r\n")
+		var out bytes.Buffer
+		out.Write(trimmed[:len(trimmed)-1])
+		if len(fields) > 0 {
+			out.WriteString(",")
+		}
+		out.WriteString(`"warnings":`)
+		out.Write(b)
+		out.WriteString("}\n")
+		body = out.Bytes()
+	}
+	dw.ResponseWriter.WriteHeader(dw.status)
+	dw.ResponseWriter.Write(body)
+}
+
+// wraps h so responses of deprecated endpoints carry the Deprecation and Sunset headers and json responses
+// list the deprecated endpoint and fields they use in warnings, so clients notice before they are removed
+// the route is the one noted by the router for measureRequests
+func warnDeprecations(h http.Handler) http.Handler {
+	if len(deprecations) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, _ := r.Context().Value(routeKey{}).(*string)
+		dw := &DeprecationWriter{ResponseWriter: w, route: route}
+		h.ServeHTTP(dw, r)
+		dw.finish()
+	})
+}
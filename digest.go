@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"currconv/pkg/convert"
+	"currconv/pkg/rates"
+)
+
+// characters used to draw sparklines, from lowest to highest value
+var sparkChars = []rune("▁▂▃▄▅▆▇█")
+
+// Subscriber stores the email address and favorite pairs of a digest subscriber
+type Subscriber struct {
+	Email string
+	// pairs in the form FROM/TO, e.g. EUR/USD
+	Pairs []string
+}
+
+// Digests stores all digest subscribers
+type Digests struct {
+	mutex sync.Mutex
+	// file the subscribers are persisted to
+	path        string
+	Subscribers []Subscriber
+	// subscribers by email address shared with the other replicas instead of path, nil if no Redis server is set
+	shared *RedisHash
+}
+
+// DigestPage stores variables for /digest/
+type DigestPage struct {
+	Message string
+	CSRF    string
+	// token of the subscription or unsubscription the page asks to confirm, empty if it shows the form
+	Confirm string
+}
+
+// time a confirmation link sent from the form is valid, the unsubscribe links of digests are valid as long as
+// digestUnsubscribeMaxAge so those of older digests keep working
+const (
+	digestConfirmationMaxAge = 48 * time.Hour
+	digestUnsubscribeMaxAge  = 30 * 24 * time.Hour
+)
+
+// DigestConfirmation is a subscription or unsubscription waiting to be confirmed, it is sealed into the link sent to
+// its address so only the owner of the address can change it and unconfirmed subscriptions aren't stored
+type DigestConfirmation struct {
+	Subscriber
+	// whether the link unsubscribes the address instead of subscribing it
+	Unsubscribe bool
+	// unix time after which the link is no longer accepted
+	Expires int64
+}
+
+// reads the subscribers stored at path
+// returns no subscribers if the file does not exist yet
+func loadDigests(path string) *Digests {
+	d := &Digests{path: path}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d
+	}
+	if err != nil {
+		log.Println(err)
+		return d
+	}
+
+	err = json.Unmarshal(b, &d.Subscribers)
+	if err != nil {
+		log.Println(err)
+	}
+	return d
+}
+
+// writes the subscribers to disk, mutex has to be held by the caller
+func (d *Digests) save() {
+	b, err := json.Marshal(d.Subscribers)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = ioutil.WriteFile(d.path, b, 0644)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// adds s or replaces the subscriber with the same email address
+func (d *Digests) subscribe(s Subscriber) {
+	if d.shared != nil {
+		if err := d.shared.set(context.Background(), s.Email, s); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for i := range d.Subscribers {
+		if d.Subscribers[i].Email == s.Email {
+			d.Subscribers[i] = s
+			d.save()
+			return
+		}
+	}
+	d.Subscribers = append(d.Subscribers, s)
+	d.save()
+}
+
+// removes the subscriber with the given email address
+func (d *Digests) unsubscribe(email string) {
+	if d.shared != nil {
+		if _, err := d.shared.del(context.Background(), email); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for i := range d.Subscribers {
+		if d.Subscribers[i].Email == email {
+			d.Subscribers = append(d.Subscribers[:i], d.Subscribers[i+1:]...)
+			d.save()
+			return
+		}
+	}
+}
+
+// returns a copy of all subscribers
+func (d *Digests) list() []Subscriber {
+	if d.shared != nil {
+		values, err := d.shared.all(context.Background())
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		var subscribers []Subscriber
+		for _, v := range values {
+			var s Subscriber
+			if err := json.Unmarshal([]byte(v), &s); err != nil {
+				log.Println(err)
+				continue
+			}
+			subscribers = append(subscribers, s)
+		}
+		return subscribers
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return append([]Subscriber(nil), d.Subscribers...)
+}
+
+// draws values as a line of block characters scaled between their minimum and maximum
+// values that aren't finite are left out
+func sparkline(values []float64) string {
+	var finite []float64
+	for _, v := range values {
+		if !math.IsInf(v, 0) && !math.IsNaN(v) {
+			finite = append(finite, v)
+		}
+	}
+	if len(finite) == 0 {
+		return ""
+	}
+
+	min, max := finite[0], finite[0]
+	for _, v := range finite {
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+
+	var sb strings.Builder
+	for _, v := range finite {
+		i := len(sparkChars) / 2
+		if max > min {
+			i = int((v - min) / (max - min) * float64(len(sparkChars)-1))
+		}
+		sb.WriteRune(sparkChars[i])
+	}
+	return sb.String()
+}
+
+// splits a pair in the form FROM/TO into its currencies
+func splitPair(pair string) (string, string, bool) {
+	currencies := strings.Split(pair, "/")
+	if len(currencies) != 2 {
+		return "", "", false
+	}
+	return currencyCode(currencies[0]), currencyCode(currencies[1]), true
+}
+
+// splits pair and checks that rates are available for both of its currencies in d
+func parsePair(d rates.Data, pair string) (string, string, bool) {
+	from, to, ok := splitPair(pair)
+	return from, to, ok && convert.Available(d, from, to)
+}
+
+// builds the digest text for the given pairs from the current data and the last 7 days of the history of the server of ctx
+// it ends with unsubscribe, the link unsubscribing its recipient
+func digestText(ctx context.Context, d rates.Data, pairs []string, unsubscribe string) string {
+	history := serverFrom(ctx).history
+	now := time.Unix(d.Timestamp, 0)
+	week := history.lastDays(now, 7)
+	yesterday, hasYesterday := history.get(now.AddDate(0, 0, -1).Format("2006-01-02"))
+
+	var sb strings.Builder
+	sb.WriteString("Your daily currency digest for " + d.Date + "\n\n")
+
+	for _, pair := range pairs {
+		from, to, _ := splitPair(pair)
+		if !convert.Available(d, from, to) {
+			fmt.Fprintf(&sb, "%s/%s  n/a\n", from, to)
+			continue
+		}
+		rate := convert.Convert(d, from, to, 1)
+
+		change := "n/a"
+		if hasYesterday && convert.Available(yesterday, from, to) {
+			old := convert.Convert(yesterday, from, to, 1)
+			change = fmt.Sprintf("%+.2f%%", (rate-old)/old*100)
+		}
+
+		// days on which a currency of the pair wasn't quoted yet are skipped
+		var values []float64
+		for _, day := range week {
+			if convert.Available(day, from, to) {
+				values = append(values, convert.Convert(day, from, to, 1))
+			}
+		}
+
+		fmt.Fprintf(&sb, "%s/%s  %.4f  %s  %s\n", from, to, rate, change, sparkline(values))
+	}
+
+	sb.WriteString("\nTo stop receiving this digest, unsubscribe at " + unsubscribe + "\n")
+	return sb.String()
+}
+
+// sends an email with the given subject and body to the recipient using the configured smtp server
+func sendMail(to string, subject string, body string) error {
+	host := os.Getenv("smtp_host")
+	port := getEnv("smtp_port", "587")
+	from := getEnv("smtp_from", "digest@localhost")
+
+	var auth smtp.Auth
+	user := os.Getenv("smtp_user")
+	if user != "" {
+		auth = smtp.PlainAuth("", user, os.Getenv("smtp_password"), host)
+	}
+
+	msg := "From: " + from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + strings.Replace(body, "\n", "\r\n", -1)
+
+	return smtp.SendMail(host+":"+port, auth, from, []string{to}, []byte(msg))
+}
+
+// returns why digests can't be sent, empty if they can
+// they need an smtp server and public_url (e.g. https://currconv.example), the links in their emails start with it
+// since the Host header of a request may be forged
+func digestsUnavailable() string {
+	switch {
+	case os.Getenv("smtp_host") == "":
+		return "smtp_host is not set"
+	case getEnv("public_url", "") == "":
+		return "public_url is not set"
+	}
+	return ""
+}
+
+// sends the digest email to every subscriber of the server of ctx
+// does nothing if digests can't be sent
+func sendDigests(ctx context.Context) {
+	if reason := digestsUnavailable(); reason != "" {
+		log.Println(reason + ", skipping daily digest")
+		return
+	}
+
+	d := getCurrentData(ctx)
+	for _, s := range serverFrom(ctx).digests.list() {
+		unsubscribe, err := digestConfirmationLink(DigestConfirmation{s, true, time.Now().Add(digestUnsubscribeMaxAge).Unix()})
+		if err == nil {
+			err = sendMail(s.Email, "Daily currency digest", digestText(ctx, d, s.Pairs, unsubscribe))
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// returns the link starting with public_url that asks to confirm c, an error if it can't be sealed
+func digestConfirmationLink(c DigestConfirmation) (string, error) {
+	token, err := seal(c, "digest")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(getEnv("public_url", ""), "/") + "/digest/?confirm=" + url.QueryEscape(token), nil
+}
+
+// returns the confirmation sealed into a link, an error if the token is invalid or expired
+func decodeDigestConfirmation(token string) (DigestConfirmation, error) {
+	var c DigestConfirmation
+	if err := unseal(token, "digest", &c); err != nil {
+		return DigestConfirmation{}, err
+	}
+	if time.Now().Unix() > c.Expires {
+		return DigestConfirmation{}, errors.New("confirmation expired")
+	}
+	return c, nil
+}
+
+// returns the subscriber with the given email address
+func (d *Digests) find(email string) (Subscriber, bool) {
+	for _, s := range d.list() {
+		if s.Email == email {
+			return s, true
+		}
+	}
+	return Subscriber{}, false
+}
+
+// renders the digest form and subscribes or unsubscribes the submitted email address once the change is confirmed
+// with the link sent to it
+// the confirmation link opens a page asking to confirm, so mail scanners opening links don't change anything
+func digestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		p := DigestPage{CSRF: csrfToken(r)}
+		if token := r.URL.Query().Get("confirm"); token != "" {
+			c, err := decodeDigestConfirmation(token)
+			switch {
+			case err != nil:
+				p.Message = "This confirmation link is invalid or has expired, please try again."
+			case c.Unsubscribe:
+				p.Message = "Confirm that " + c.Email + " should no longer receive the digest."
+				p.Confirm = token
+			default:
+				p.Message = "Confirm that " + c.Email + " should receive a digest of " + strings.Join(c.Pairs, ", ") + " every morning."
+				p.Confirm = token
+			}
+		}
+		renderTemplate(w, r, "digest", &p)
+		return
+	}
+
+	r.ParseForm()
+	if r.Form.Get("action") == "confirm" {
+		c, err := decodeDigestConfirmation(r.Form.Get("confirm"))
+		switch {
+		case err != nil:
+			renderTemplate(w, r, "digest", &DigestPage{Message: "This confirmation link is invalid or has expired, please try again.", CSRF: csrfToken(r)})
+		case c.Unsubscribe:
+			serverFrom(r.Context()).digests.unsubscribe(c.Email)
+			renderTemplate(w, r, "digest", &DigestPage{Message: c.Email + " has been unsubscribed.", CSRF: csrfToken(r)})
+		default:
+			serverFrom(r.Context()).digests.subscribe(c.Subscriber)
+			renderTemplate(w, r, "digest", &DigestPage{Message: c.Email + " will receive a digest every morning.", CSRF: csrfToken(r)})
+		}
+		return
+	}
+
+	email := strings.TrimSpace(r.Form.Get("email"))
+	if email == "" {
+		renderTemplate(w, r, "digest", &DigestPage{Message: "Please enter an email address.", CSRF: csrfToken(r)})
+		return
+	}
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		renderTemplate(w, r, "digest", &DigestPage{Message: "Please enter a valid email address.", CSRF: csrfToken(r)})
+		return
+	}
+	if reason := digestsUnavailable(); reason != "" {
+		log.Println("warning:", reason+", digest subscriptions can't be confirmed")
+		renderTemplate(w, r, "digest", &DigestPage{Message: "Digests can't be sent at the moment.", CSRF: csrfToken(r)})
+		return
+	}
+
+	if r.Form.Get("action") == "unsubscribe" {
+		// the page doesn't tell whether the address is subscribed
+		if s, ok := serverFrom(r.Context()).digests.find(email); ok {
+			link, err := digestConfirmationLink(DigestConfirmation{s, true, time.Now().Add(digestConfirmationMaxAge).Unix()})
+			if err == nil {
+				err = sendMail(email, "Unsubscribe from your currency digest", "Open this link to stop receiving the daily digest:\n\n"+
+					link+"\n\nThe link is valid for 48 hours. If you didn't ask to unsubscribe, ignore this email.\n")
+			}
+			if err != nil {
+				log.Println(err)
+				renderTemplate(w, r, "digest", &DigestPage{Message: "The confirmation email could not be sent, please try again later.", CSRF: csrfToken(r)})
+				return
+			}
+		}
+		renderTemplate(w, r, "digest", &DigestPage{Message: "If " + email + " is subscribed, we sent it a link to unsubscribe.", CSRF: csrfToken(r)})
+		return
+	}
+
+	d := getCurrentData(r.Context())
+	var pairs []string
+	for _, pair := range strings.Split(r.Form.Get("pairs"), ",") {
+		from, to, ok := parsePair(d, pair)
+		if !ok {
+			renderTemplate(w, r, "digest", &DigestPage{Message: "Invalid pair: " + pair, CSRF: csrfToken(r)})
+			return
+		}
+		pairs = append(pairs, from+"/"+to)
+	}
+
+	link, err := digestConfirmationLink(DigestConfirmation{Subscriber{email, pairs}, false, time.Now().Add(digestConfirmationMaxAge).Unix()})
+	if err == nil {
+		err = sendMail(email, "Confirm your currency digest", "Open this link to receive a daily digest of "+strings.Join(pairs, ", ")+":\n\n"+
+			link+"\n\nThe link is valid for 48 hours. If you didn't subscribe, ignore this email.\n")
+	}
+	if err != nil {
+		log.Println(err)
+		renderTemplate(w, r, "digest", &DigestPage{Message: "The confirmation email could not be sent, please try again later.", CSRF: csrfToken(r)})
+		return
+	}
+	renderTemplate(w, r, "digest", &DigestPage{Message: "We sent a confirmation link to " + email + ", the digest starts once you open it.", CSRF: csrfToken(r)})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"currconv/pkg/convert"
+)
+
+// EmbedPage stores variables for /embed
+type EmbedPage struct {
+	From       string
+	To         string
+	Value      float64
+	Result     float64
+	Time       string
+	Currencies []string
+}
+
+// renders a compact converter without navigation that can be embedded in an iframe
+// the pages allowed to embed it are set by the embed_frame_ancestors environment variable, see securityHeaders
+func embedHandler(w http.ResponseWriter, r *http.Request) {
+	d := getCurrentData(r.Context())
+
+	query := r.URL.Query()
+	from := currencyCode(query.Get("from"))
+	to := currencyCode(query.Get("to"))
+	d = withRetiredRates(d, from, to)
+	if _, ok := d.Rates[from]; !ok {
+		from = "EUR"
+	}
+	if _, ok := d.Rates[to]; !ok {
+		to = "USD"
+	}
+	value, err := strconv.ParseFloat(query.Get("value"), 64)
+	if err != nil {
+		value = 1
+	}
+
+	p := EmbedPage{
+		From:       from,
+		To:         to,
+		Value:      value,
+		Result:     convert.RoundTo2Decimals(convert.Convert(d, from, to, value)),
+		Time:       time.Unix(d.Timestamp, 0).UTC().Format("2006-01-02 15:04 MST"),
+		Currencies: sortedCurrencies(d),
+	}
+
+	renderTemplate(w, r, "embed", &p)
+}
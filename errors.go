@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"currconv/pkg/rates"
+)
+
+// errors caused by the input of a request, package rates defines the errors of fetching the rates
+var (
+	ErrInvalidAmount = errors.New("amount must be a positive number")
+	ErrInvalidDate   = errors.New("invalid date")
+)
+
+// returns an UnknownCurrencyError for currency, explaining what replaced it if it was retired
+func unknownCurrency(currency string) error {
+	err := retiredError(currency)
+	if err == nil {
+		err = fmt.Errorf("%w %s", rates.ErrUnknownCurrency, currency)
+	}
+	return &UnknownCurrencyError{currency, err}
+}
+
+// checks that d has a rate for every currency
+func checkCurrencies(d rates.Data, currencies ...string) error {
+	for _, c := range currencies {
+		if _, ok := d.Rates[c]; !ok {
+			return unknownCurrency(c)
+		}
+	}
+	return nil
+}
+
+// parses an amount to convert, ErrInvalidAmount unless it is a finite number greater than 0
+// strconv accepts NaN and Inf, which can't be encoded as json
+func parseAmount(amount string) (float64, error) {
+	value, err := strconv.ParseFloat(amount, 64)
+	if err != nil || !(value > 0) || math.IsInf(value, 0) {
+		return 0, ErrInvalidAmount
+	}
+	return value, nil
+}
+
+// parses amount and checks that amount can be converted from from into to with d
+func parseConversion(d rates.Data, from string, to string, amount string) (float64, error) {
+	value, err := parseAmount(amount)
+	if err != nil {
+		return 0, err
+	}
+	return value, checkCurrencies(d, from, to)
+}
+
+// returns the status and page title of the response to a request that failed with err
+func errorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, rates.ErrUnknownCurrency), errors.Is(err, ErrInvalidAmount), errors.Is(err, ErrInvalidDate):
+		return http.StatusBadRequest, "Invalid conversion"
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "Request timed out"
+	case errors.Is(err, rates.ErrProviderUnavailable), errors.Is(err, rates.ErrStaleData):
+		return http.StatusBadGateway, "Rates unavailable"
+	}
+	return http.StatusInternalServerError, "Something went wrong"
+}
+
+// logs err with the severity of its cause, invalid input and requests canceled by the client aren't logged
+// invalid responses of the provider are reported to the error tracker as well, they don't go away by retrying
+func logError(err error) {
+	if errors.Is(err, rates.ErrInvalidResponse) {
+		reportError(err, nil)
+	}
+	status, _ := errorStatus(err)
+	switch {
+	case status < http.StatusInternalServerError, errors.Is(err, context.Canceled):
+	case errors.Is(err, rates.ErrStaleData), errors.Is(err, rates.ErrRateLimited):
+		log.Println("warning:", err)
+	default:
+		log.Println("error:", err)
+	}
+}
+
+// writes the error response for err with the status of its cause and logs it
+// invalid input is explained with the message of err, other errors aren't shown to the client
+// close matches of an unknown currency are suggested, in the suggestions of the json error
+func failRequest(w http.ResponseWriter, r *http.Request, err error) {
+	logError(err)
+	noteRequestError(r, err)
+	status, title := errorStatus(err)
+	message := err.Error()
+	switch {
+	case status == http.StatusBadGateway || status == http.StatusGatewayTimeout:
+		message = "The exchange rates can't be fetched right now, please try again later."
+	case status >= http.StatusInternalServerError:
+		message = "The request could not be completed, please try again later."
+	}
+	suggestions := suggestionsFor(r, err)
+	if len(suggestions) > 0 {
+		message += ", " + didYouMean(suggestions)
+		if isAPIRequest(r) || wantsJSON(r) {
+			writeAPIError(w, status, APIError{message, suggestions})
+			return
+		}
+	}
+	requestError(w, r, status, title, message)
+}
+
+// ErrorPage stores variables for error.html
+type ErrorPage struct {
+	Title   string
+	Message string
+}
+
+// checks whether r is a request to the JSON API
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// writes a 404 response, as json for API requests and as page otherwise
+func notFound(w http.ResponseWriter, r *http.Request) {
+	if isAPIRequest(r) {
+		writeError(w, http.StatusNotFound, "no such endpoint "+r.URL.Path)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	renderTemplate(w, r, "error", &ErrorPage{"Page not found", "There is nothing at " + r.URL.Path + "."})
+}
+
+// writes an error response with status, as json for API requests or if r asks for json and as page with title otherwise
+func requestError(w http.ResponseWriter, r *http.Request, status int, title string, message string) {
+	if isAPIRequest(r) || wantsJSON(r) {
+		writeError(w, status, message)
+		return
+	}
+	w.WriteHeader(status)
+	renderTemplate(w, r, "error", &ErrorPage{title, message})
+}
+
+// methods accepted by handlers that only read
+var readMethods = []string{http.MethodGet, http.MethodHead}
+
+// writes a 405 response with an Allow header listing methods if r uses none of them
+// returns whether the method is allowed
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+
+	allowed := strings.Join(methods, ", ")
+	w.Header().Set("Allow", allowed)
+	if isAPIRequest(r) {
+		writeError(w, http.StatusMethodNotAllowed, r.Method+" is not allowed, use "+allowed)
+		return false
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	renderTemplate(w, r, "error", &ErrorPage{"Method not allowed", r.Method + " is not allowed here, use " + allowed + "."})
+	return false
+}
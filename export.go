@@ -0,0 +1,280 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"currconv/pkg/rates"
+)
+
+// returns the currencies of d sorted alphabetically
+func sortedCurrencies(d rates.Data) []string {
+	currencies := make([]string, 0, len(d.Rates))
+	for currency := range d.Rates {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+// checks that date is empty or in the form YYYY-MM-DD
+func validDate(date string) bool {
+	if date == "" {
+		return true
+	}
+	_, err := time.Parse("2006-01-02", date)
+	return err == nil
+}
+
+// Rows calls write with every row of a table in order and returns the first error write returns
+// tables are passed as Rows so exports are written while their source is read instead of being built in memory first
+type Rows func(write func(row []interface{}) error) error
+
+// returns the Rows of the table rows
+func rowsOf(rows [][]interface{}) Rows {
+	return func(write func(row []interface{}) error) error {
+		for _, row := range rows {
+			if err := write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// sets the headers for a csv download with the given file name
+func setCSVHeaders(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+}
+
+// writes the current rate table as csv
+// the base parameter selects the base currency
+func exportRatesHandler(w http.ResponseWriter, r *http.Request) {
+	d := getCurrentData(r.Context())
+	d, err := d.Rebase(requestedBase(r, d))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	setCacheHeaders(w, r, d)
+	setCSVHeaders(w, "rates-"+d.Date+".csv")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "base", "currency", "rate"})
+	for _, currency := range sortedCurrencies(d) {
+		cw.Write([]string{d.Date, d.Base, currency, strconv.FormatFloat(d.Rates[currency], 'f', -1, 64)})
+	}
+	cw.Flush()
+}
+
+// returns the pair and the range limited by the start and end parameters (YYYY-MM-DD) of a history export
+// writes an error and returns false if they are invalid
+func historyExportQuery(w http.ResponseWriter, r *http.Request) (from string, to string, start string, end string, ok bool) {
+	query := r.URL.Query()
+	start = query.Get("start")
+	end = query.Get("end")
+
+	from, to, ok = parsePair(getCurrentData(r.Context()), query.Get("pair"))
+	if !ok {
+		http.Error(w, "pair must be given as FROM/TO, e.g. EUR/USD", http.StatusBadRequest)
+		return "", "", "", "", false
+	}
+	if !validDate(start) || !validDate(end) {
+		http.Error(w, "start and end must be given as YYYY-MM-DD", http.StatusBadRequest)
+		return "", "", "", "", false
+	}
+	return from, to, start, end, true
+}
+
+// writes the stored daily rates of the pair given in the url query as csv
+// the range can be limited with the start and end parameters (YYYY-MM-DD)
+func exportHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, start, end, ok := historyExportQuery(w, r)
+	if !ok {
+		return
+	}
+
+	setCSVHeaders(w, "history-"+from+"-"+to+".csv")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "from", "to", "rate"})
+	err := eachTimeseriesPoint(r.Context(), from, to, start, end, func(p TimeseriesPoint) error {
+		return cw.Write([]string{p.Date, from, to, strconv.FormatFloat(p.Rate, 'f', -1, 64)})
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	cw.Flush()
+}
+
+// returns the rows of date, from, to and rate of the pair from/to between start and end stored in the history of the
+// server of r, read while they are written
+func historyRows(r *http.Request, from string, to string, start string, end string) Rows {
+	return func(write func(row []interface{}) error) error {
+		return eachTimeseriesPoint(r.Context(), from, to, start, end, func(p TimeseriesPoint) error {
+			date, _ := time.Parse("2006-01-02", p.Date)
+			return write([]interface{}{date, from, to, p.Rate})
+		})
+	}
+}
+
+// the columns of the parquet files of history exports
+var historyParquetColumns = []ParquetColumn{{"date", ParquetDate}, {"from", ParquetString}, {"to", ParquetString}, {"rate", ParquetDouble}}
+
+// writes the stored daily rates of the pair given in the url query as parquet file
+// the range can be limited with the start and end parameters (YYYY-MM-DD)
+func exportHistoryParquetHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, start, end, ok := historyExportQuery(w, r)
+	if !ok {
+		return
+	}
+
+	rows := historyRows(r, from, to, start, end)
+	w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+	w.Header().Set("Content-Disposition", `attachment; filename="history-`+from+"-"+to+`.parquet"`)
+	if err := writeParquet(w, historyParquetColumns, rows); err != nil {
+		log.Println(err)
+	}
+}
+
+// writes an xlsx workbook with the current rate table in the requested base currency on the first sheet
+// and the stored daily rates of every pair parameter in the url query on one sheet each
+func exportXLSXHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	start := query.Get("start")
+	end := query.Get("end")
+	if !validDate(start) || !validDate(end) {
+		http.Error(w, "start and end must be given as YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	d := getCurrentData(r.Context())
+	d, err := d.Rebase(requestedBase(r, d))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	date, _ := time.Parse("2006-01-02", d.Date)
+
+	var latest [][]interface{}
+	for _, currency := range sortedCurrencies(d) {
+		latest = append(latest, []interface{}{date, d.Base, currency, d.Rates[currency]})
+	}
+	sheets := []Sheet{{Name: "Rates " + d.Date, Header: []interface{}{"date", "base", "currency", "rate"}, Rows: rowsOf(latest)}}
+
+	// Excel refuses workbooks with two sheets of the same name, a pair requested twice gets one sheet
+	seen := make(map[string]bool)
+	for _, pair := range query["pair"] {
+		from, to, ok := parsePair(d, pair)
+		if !ok {
+			http.Error(w, "pair must be given as FROM/TO, e.g. EUR/USD", http.StatusBadRequest)
+			return
+		}
+
+		// sheet names must not contain slashes
+		name := from + "-" + to
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		sheets = append(sheets, Sheet{Name: name, Header: []interface{}{"date", "from", "to", "rate"}, Rows: historyRows(r, from, to, start, end)})
+	}
+
+	setCacheHeaders(w, r, d)
+	w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	w.Header().Set("Content-Disposition", `attachment; filename="rates-`+d.Date+`.xlsx"`)
+	err = writeXLSX(w, sheets)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// writes the csv or json file of the rate table d into the archive zw
+func writeArchiveDay(zw *zip.Writer, d rates.Data, format string) error {
+	modified, _ := time.Parse("2006-01-02", d.Date)
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: "rates-" + d.Date + "." + format, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "json":
+		return json.NewEncoder(fw).Encode(RateTable{d.Base, d.Date, d.Timestamp, rfc3339(d.Timestamp), d.Rates})
+	case "parquet":
+		columns := []ParquetColumn{{"date", ParquetDate}, {"base", ParquetString}, {"currency", ParquetString}, {"rate", ParquetDouble}}
+		var rows [][]interface{}
+		for _, currency := range sortedCurrencies(d) {
+			rows = append(rows, []interface{}{modified, d.Base, currency, d.Rates[currency]})
+		}
+		return writeParquet(fw, columns, rowsOf(rows))
+	}
+	cw := csv.NewWriter(fw)
+	cw.Write([]string{"date", "base", "currency", "rate"})
+	for _, currency := range sortedCurrencies(d) {
+		cw.Write([]string{d.Date, d.Base, currency, strconv.FormatFloat(d.Rates[currency], 'f', -1, 64)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// streams a zip archive with the rate table of every stored day as one file each, generated while it is downloaded
+// the range can be limited with the start and end parameters (YYYY-MM-DD), the format parameter selects csv (default),
+// json or parquet files and the base parameter the base currency, days without rates of the base currency are left out
+func exportArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	start := query.Get("start")
+	end := query.Get("end")
+	if !validDate(start) || !validDate(end) {
+		http.Error(w, "start and end must be given as YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if start != "" && end != "" && start > end {
+		http.Error(w, "start must not be after end", http.StatusBadRequest)
+		return
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" && format != "parquet" {
+		http.Error(w, "format must be csv, json or parquet", http.StatusBadRequest)
+		return
+	}
+	current := getCurrentData(r.Context())
+	if _, err := current.Rebase(requestedBase(r, current)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filename := "rates"
+	if start != "" {
+		filename += "-" + start
+	}
+	if end != "" {
+		filename += "-" + end
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+	zw := zip.NewWriter(w)
+	err := serverFrom(r.Context()).history.each(start, end, func(day rates.Data) error {
+		d, err := day.Rebase(requestedBase(r, day))
+		if err != nil {
+			return nil
+		}
+		return writeArchiveDay(zw, d, format)
+	})
+	if err != nil {
+		// the client is gone or the archive is broken, the status has already been sent
+		log.Println(err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"hash/fnv"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Features stores the share of clients (0 to 100 percent) every feature flag is enabled for
+type Features struct {
+	sync.Mutex
+	percent map[string]int
+}
+
+// flags that can be switched off or rolled out gradually, all enabled unless the features variable says otherwise
+// api: the JSON API, bulk: csv conversions, digest: the daily digest sign-up, markup: markups on conversions
+var featureNames = []string{"api", "bulk", "digest", "markup"}
+
+// feature flags of the server, changed at runtime with /admin/features
+var features = loadFeatures(getEnv("features", ""))
+
+// reads the rollout of the features from config, e.g. "bulk=0,markup=25" disables bulk conversions
+// and applies markups for a quarter of the clients, a name without percentage enables the feature
+func loadFeatures(config string) *Features {
+	f := &Features{percent: make(map[string]int)}
+	for _, name := range featureNames {
+		f.percent[name] = 100
+	}
+
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value := entry, "100"
+		if i := strings.Index(entry, "="); i >= 0 {
+			name, value = entry[:i], entry[i+1:]
+		}
+		percent, err := strconv.Atoi(value)
+		if err != nil || !f.set(name, percent) {
+			log.Println("Ignoring feature flag", entry)
+		}
+	}
+	return f
+}
+
+// sets the rollout of the flag name, returns false if there is no such flag or percent isn't between 0 and 100
+func (f *Features) set(name string, percent int) bool {
+	f.Lock()
+	defer f.Unlock()
+
+	if _, ok := f.percent[name]; !ok || percent < 0 || percent > 100 {
+		return false
+	}
+	f.percent[name] = percent
+	return true
+}
+
+// returns the rollout of the flag name
+func (f *Features) get(name string) int {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.percent[name]
+}
+
+// returns a copy of the rollout of every flag
+func (f *Features) all() map[string]int {
+	f.Lock()
+	defer f.Unlock()
+
+	all := make(map[string]int, len(f.percent))
+	for name, percent := range f.percent {
+		all[name] = percent
+	}
+	return all
+}
+
+// checks whether the flag name is enabled for the client of r
+// clients are identified like for rate limiting, so each one consistently gets or doesn't get a partially rolled out feature
+func featureEnabled(r *http.Request, name string) bool {
+	percent := features.get(name)
+	if percent >= 100 || percent <= 0 {
+		return percent >= 100
+	}
+	client, _ := rateLimitFor(r)
+	h := fnv.New32a()
+	h.Write([]byte(name + " " + client))
+	return int(h.Sum32()%100) < percent
+}
+
+// wraps h so its route doesn't exist for clients the flag name is disabled for
+func feature(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !featureEnabled(r, name) {
+			notFound(w, r)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// writes the rollout of the feature flags as json
+// POST requests first set the flag given by the name form value to percent (0 to 100) until the server restarts
+func adminFeaturesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if err := parseForm(r); bodyTooLarge(w, r, err) {
+			return
+		}
+		percent, err := strconv.Atoi(r.FormValue("percent"))
+		if err != nil || !features.set(r.FormValue("name"), percent) {
+			writeError(w, http.StatusBadRequest, "name must be one of "+strings.Join(featureNames, ", ")+" and percent between 0 and 100")
+			return
+		}
+		log.Println("Feature", r.FormValue("name"), "set to", percent, "percent")
+	}
+	writeJSON(w, features.all())
+}
@@ -0,0 +1,34 @@
+package main
+
+// primary country (ISO 3166-1 alpha-2) of every currency whose flag represents it, EU for the euro
+// currencies shared by several countries without a common flag, like the CFA francs, and metals have none
+var currencyCountries = map[string]string{
+	"AED": "AE", "ARS": "AR", "AUD": "AU", "BGN": "BG", "BRL": "BR", "CAD": "CA", "CHF": "CH", "CLP": "CL",
+	"CNY": "CN", "COP": "CO", "CZK": "CZ", "DKK": "DK", "EGP": "EG", "EUR": "EU", "GBP": "GB", "HKD": "HK",
+	"HUF": "HU", "IDR": "ID", "ILS": "IL", "INR": "IN", "ISK": "IS", "JPY": "JP", "KES": "KE", "KRW": "KR",
+	"KWD": "KW", "MAD": "MA", "MXN": "MX", "MYR": "MY", "NGN": "NG", "NOK": "NO", "NZD": "NZ", "PEN": "PE",
+	"PHP": "PH", "PKR": "PK", "PLN": "PL", "QAR": "QA", "RON": "RO", "RSD": "RS", "RUB": "RU", "SAR": "SA",
+	"SEK": "SE", "SGD": "SG", "THB": "TH", "TRY": "TR", "TWD": "TW", "UAH": "UA", "USD": "US", "VND": "VN",
+	"ZAR": "ZA",
+}
+
+// returns the emoji flag of country, the pair of regional indicator symbols of its letters
+func flagEmoji(country string) string {
+	if len(country) != 2 {
+		return ""
+	}
+	flag := make([]rune, 0, 2)
+	for _, letter := range country {
+		if letter < 'A' || letter > 'Z' {
+			return ""
+		}
+		flag = append(flag, '🇦'+letter-'A')
+	}
+	return string(flag)
+}
+
+// returns the primary country of the currency with code and its emoji flag, empty if it has none
+func currencyFlag(code string) (string, string) {
+	country := currencyCountries[code]
+	return country, flagEmoji(country)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shown with every forecast
+const forecastDisclaimer = "This is a naive extrapolation of past rates for illustration only, not financial advice. Exchange rates can move in any direction."
+
+// stored days the forecast is fitted to
+const forecastDays = 30
+
+// longest horizon of a forecast in days
+const maxForecastHorizon = 30
+
+// change in percent below which a forecast counts as flat
+const flatTrend = 0.1
+
+// Forecast stores a projection of the rate of a pair for the days after the last stored one
+type Forecast struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Method string `json:"method"`
+	// first and last day the projection is fitted to
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// rate of the last stored day
+	Rate    float64           `json:"rate"`
+	Horizon int               `json:"horizon"`
+	Rates   []TimeseriesPoint `json:"rates"`
+	// projected change over the horizon in percent and its direction, up, down or flat
+	Change     float64 `json:"change"`
+	Trend      string  `json:"trend"`
+	Disclaimer string  `json:"disclaimer"`
+}
+
+// parses a horizon given in days like 7d or 7
+func parseHorizon(s string) (int, bool) {
+	days, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(s), "d"))
+	return days, err == nil && days >= 1 && days <= maxForecastHorizon
+}
+
+// returns the days between first and date, both YYYY-MM-DD
+func daysSince(first string, date string) float64 {
+	a, _ := time.Parse("2006-01-02", first)
+	b, _ := time.Parse("2006-01-02", date)
+	return b.Sub(a).Hours() / 24
+}
+
+// fits a line through points by least squares, x counted in days since the first point
+// returns its intercept and slope per day
+func linearRegression(points []TimeseriesPoint) (float64, float64) {
+	n := float64(len(points))
+	var sumX, sumY, sumXX, sumXY float64
+	for _, p := range points {
+		x := daysSince(points[0].Date, p.Date)
+		sumX += x
+		sumY += p.Rate
+		sumXX += x * x
+		sumXY += x * p.Rate
+	}
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return sumY / n, 0
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+	return (sumY - slope*sumX) / n, slope
+}
+
+// projects the pair from/to horizon days past the last stored day along the regression line of the last forecastDays stored days
+// returns false if fewer than 2 days are stored
+func newForecast(ctx context.Context, from string, to string, horizon int) (Forecast, bool) {
+	points := timeseries(ctx, from, to, "", "")
+	if len(points) > forecastDays {
+		points = points[len(points)-forecastDays:]
+	}
+	if len(points) < 2 {
+		return Forecast{}, false
+	}
+
+	first, last := points[0], points[len(points)-1]
+	f := Forecast{From: from, To: to, Method: "linear regression", Start: first.Date, End: last.Date, Rate: last.Rate, Horizon: horizon, Disclaimer: forecastDisclaimer}
+	intercept, slope := linearRegression(points)
+	day, _ := time.Parse("2006-01-02", last.Date)
+	for i := 1; i <= horizon; i++ {
+		date := day.AddDate(0, 0, i).Format("2006-01-02")
+		rate := math.Max(intercept+slope*daysSince(first.Date, date), 0)
+		f.Rates = append(f.Rates, TimeseriesPoint{date, rate})
+	}
+
+	f.Change = (f.Rates[horizon-1].Rate/last.Rate - 1) * 100
+	switch {
+	case f.Change >= flatTrend:
+		f.Trend = "up"
+	case f.Change <= -flatTrend:
+		f.Trend = "down"
+	default:
+		f.Trend = "flat"
+	}
+	return f, true
+}
+
+// writes a naive projection of the pair given in the url query for the days given by the horizon parameter, 7d by default
+func apiForecastHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to, ok := parsePair(getCurrentData(r.Context()), query.Get("pair"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "pair must be given as FROM/TO, e.g. EUR/USD")
+		return
+	}
+	horizon, ok := parseHorizon(getQueryDefault(query.Get("horizon"), "7d"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "horizon must be given in days from 1d to "+strconv.Itoa(maxForecastHorizon)+"d")
+		return
+	}
+
+	f, ok := newForecast(r.Context(), from, to, horizon)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not enough rates of "+from+"/"+to+" are stored for a forecast yet")
+		return
+	}
+	writeJSON(w, f)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// FormErrors are the messages shown next to the fields of the converter form, empty for valid fields
+type FormErrors struct {
+	Value string
+	From  string
+	To    string
+	Date  string
+}
+
+// reports whether any field is invalid
+func (e FormErrors) any() bool {
+	return e != FormErrors{}
+}
+
+// checks the fields of a submitted conversion in the language lang
+// the currencies are checked against the rates of date, or the current ones if date is invalid or can't be fetched
+func checkConversionForm(ctx context.Context, lang string, from string, to string, value string, date string) FormErrors {
+	var errs FormErrors
+	amount, err := strconv.ParseFloat(value, 64)
+	// NaN isn't greater than 0 either
+	if err != nil || !(amount > 0) || math.IsInf(amount, 0) {
+		errs.Value = translate(lang, "form.amount")
+	}
+	if date != "" && checkDate(date) != nil {
+		errs.Date = translate(lang, "form.date")
+		date = ""
+	}
+
+	d, err := dataFor(ctx, date)
+	if err != nil {
+		// the conversion page explains that the rates are unavailable
+		return errs
+	}
+	d = withRetiredRates(d, from, to)
+	unknown := func(currency string) string {
+		if _, ok := d.Rates[currency]; ok {
+			return ""
+		}
+		message := fmt.Sprintf(translate(lang, "form.currency"), currency)
+		if suggestions := suggestCurrencies(ctx, d, currency); len(suggestions) > 0 {
+			message += " " + fmt.Sprintf(translate(lang, "form.suggestions"), strings.Join(suggestions, ", "))
+		}
+		return message
+	}
+	errs.From, errs.To = unknown(from), unknown(to)
+	return errs
+}
+
+// renders the converter form of the index page with the input of p and the errors of its fields
+func renderIndex(w http.ResponseWriter, r *http.Request, p IndexPage) {
+	p.CSRF = csrfToken(r)
+	if t, err := trendingFor(r); err == nil {
+		p.Movers = t.Movers
+	}
+	if p.Errors.any() {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	renderTemplate(w, r, "index", &p)
+}
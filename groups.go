@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"currconv/pkg/convert"
+	"currconv/pkg/rates"
+)
+
+// CurrencyGroup is a set of currencies of a region or an economic group
+type CurrencyGroup struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Currencies []string `json:"currencies"`
+}
+
+// groups an amount can be converted into at once, by their id
+var currencyGroups = []CurrencyGroup{
+	{"eu", "European Union", []string{"EUR", "BGN", "CZK", "DKK", "HUF", "PLN", "RON", "SEK"}},
+	{"g10", "G10", []string{"USD", "EUR", "JPY", "GBP", "CHF", "CAD", "AUD", "NZD", "SEK", "NOK"}},
+	{"asean", "ASEAN", []string{"BND", "IDR", "KHR", "LAK", "MMK", "MYR", "PHP", "SGD", "THB", "VND"}},
+	{"latam", "Latin America", []string{"ARS", "BOB", "BRL", "CLP", "COP", "CRC", "DOP", "GTQ", "MXN", "PEN", "PYG", "UYU"}},
+	{"gcc", "Gulf Cooperation Council", []string{"AED", "BHD", "KWD", "OMR", "QAR", "SAR"}},
+	{"brics", "BRICS", []string{"BRL", "RUB", "INR", "CNY", "ZAR"}},
+	{"nordics", "Nordic countries", []string{"DKK", "EUR", "ISK", "NOK", "SEK"}},
+}
+
+// returns the group with id and whether it exists
+func currencyGroup(id string) (CurrencyGroup, bool) {
+	for _, g := range currencyGroups {
+		if g.ID == strings.ToLower(id) {
+			return g, true
+		}
+	}
+	return CurrencyGroup{}, false
+}
+
+// MultiConversion is an amount converted into several currencies with the same rates
+type MultiConversion struct {
+	From        string       `json:"from"`
+	Amount      float64      `json:"amount"`
+	Conversions []Conversion `json:"conversions"`
+	// requested currencies the rates don't have
+	Unavailable []string `json:"unavailable,omitempty"`
+	Timestamp   int64    `json:"timestamp"`
+	Time        string   `json:"time"`
+	Date        string   `json:"date"`
+}
+
+// converts amount of from into every currency of targets using d and applies markup m, rounded as p asks for
+// from itself and duplicates are left out, currencies d doesn't have are listed as unavailable
+func newMultiConversion(d rates.Data, from string, amount float64, targets []string, m convert.Markup, p Preferences) MultiConversion {
+	multi := MultiConversion{From: from, Amount: amount, Conversions: []Conversion{}, Timestamp: d.Timestamp, Time: rfc3339(d.Timestamp), Date: d.Date}
+	seen := map[string]bool{from: true}
+	for _, to := range targets {
+		if seen[to] {
+			continue
+		}
+		seen[to] = true
+		if !convert.Available(d, to) {
+			multi.Unavailable = append(multi.Unavailable, to)
+			continue
+		}
+		multi.Conversions = append(multi.Conversions, newConversion(d, from, to, amount, convert.Mid, m, p))
+	}
+	return multi
+}
+
+// writes the currency groups
+func apiGroupsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, currencyGroups)
+}
+
+// returns the currencies named by the to parameter of r (comma separated) and the currencies of its group parameter
+func multiTargets(r *http.Request) ([]string, bool) {
+	query := r.URL.Query()
+	targets := splitCurrencies(query.Get("to"))
+	if id := query.Get("group"); id != "" {
+		g, ok := currencyGroup(id)
+		if !ok {
+			return nil, false
+		}
+		targets = append(targets, g.Currencies...)
+	}
+	return targets, true
+}
+
+// converts the amount parameter of from into every currency given by the to and group parameters, the date parameter
+// converts with the rates of a past day
+func apiMultiConvertHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	d, err := dataFor(r.Context(), query.Get("date"))
+	if err != nil {
+		failRequest(w, r, err)
+		return
+	}
+	from := currencyCode(query.Get("from"))
+	amount, err := parseAmount(query.Get("amount"))
+	if err != nil {
+		failRequest(w, r, err)
+		return
+	}
+	targets, ok := multiTargets(r)
+	d = withRetiredRates(d, append([]string{from}, targets...)...)
+	if err := checkCurrencies(d, from); err != nil {
+		failRequest(w, r, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusBadRequest, "unknown group "+query.Get("group"))
+		return
+	}
+	if len(targets) == 0 {
+		writeError(w, http.StatusBadRequest, "the currencies to convert into must be given by to or group")
+		return
+	}
+
+	m := newMultiConversion(d, from, amount, targets, markupFor(r), preferencesFor(r))
+	setRateWarnings(w, r, d)
+	writeJSON(w, m)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Health is the json body of /healthz and /readyz
+type Health struct {
+	Status string `json:"status"`
+	// why the instance isn't ready, omitted if it is
+	Reason string `json:"reason,omitempty"`
+	// age of the cached rates in seconds, omitted if none have been fetched yet
+	AgeSeconds float64 `json:"age_seconds,omitempty"`
+}
+
+// writes h with status and without caching, orchestrators must always see the current state
+func writeHealth(w http.ResponseWriter, status int, h Health) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	writeJSON(w, h)
+}
+
+// reports that the process is alive and handling requests, regardless of the state of its rates
+// a restart wouldn't make outdated rates fresh, so the liveness probe stays green while the provider is down
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, Health{Status: "ok"})
+}
+
+// returns the age after which /readyz fails set by readyz_max_age (e.g. 48h), 0 if the age isn't checked
+func readinessMaxAge() time.Duration {
+	maxAge, err := time.ParseDuration(getEnv("readyz_max_age", "0s"))
+	if err != nil || maxAge < 0 {
+		log.Println("readyz_max_age must be a duration, e.g. 48h")
+		return 0
+	}
+	return maxAge
+}
+
+// reports whether traffic should be sent to this instance: it fails with 503 while the server shuts down,
+// if no rates have ever been fetched or the cached rates are older than readyz_max_age,
+// so instances serving dangerously stale rates are taken out of rotation
+// set readyz_require_rates to false to report ready before the first successful fetch
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if isDraining() {
+		writeHealth(w, http.StatusServiceUnavailable, Health{Status: "unavailable", Reason: "shutting down"})
+		return
+	}
+	age, _ := ratesAge(r.Context())
+	if age < 0 {
+		if getEnv("readyz_require_rates", "true") == "false" {
+			writeHealth(w, http.StatusOK, Health{Status: "ok"})
+			return
+		}
+		writeHealth(w, http.StatusServiceUnavailable, Health{Status: "unavailable", Reason: "no rates have been fetched yet"})
+		return
+	}
+
+	h := Health{Status: "ok", AgeSeconds: age}
+	if maxAge := readinessMaxAge(); maxAge > 0 && age > maxAge.Seconds() {
+		h.Status = "unavailable"
+		h.Reason = fmt.Sprintf("the rates are %s old, more than the allowed %s", time.Duration(age)*time.Second, maxAge)
+		writeHealth(w, http.StatusServiceUnavailable, h)
+		return
+	}
+	writeHealth(w, http.StatusOK, h)
+}
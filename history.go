@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"currconv/pkg/rates"
+)
+
+// HistoricalFetch is a fetch of the rates of a past day shared by the requests waiting for it
+type HistoricalFetch struct {
+	// closed once d and err are set
+	done chan struct{}
+	d    rates.Data
+	err  error
+	// until when err is returned instead of fetching the day again
+	retryAfter time.Time
+}
+
+// History stores one rate table per day so past rates can be looked up later
+type History struct {
+	mutex sync.Mutex
+	// file the history is persisted to
+	path string
+	// maps dates (YYYY-MM-DD) to the last data fetched on that day
+	Days map[string]rates.Data
+	// incremented on every change so results computed from the history can be cached per version
+	version int
+	// whether the file at path couldn't be read, it is kept instead of being overwritten so it can be repaired
+	unreadable bool
+}
+
+// reads the history stored at path
+// returns an empty history if the file does not exist yet
+// a file that can't be read or parsed is never overwritten, the history is then only kept in memory
+func loadHistory(path string) *History {
+	h := &History{path: path, Days: make(map[string]rates.Data)}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &h.Days)
+	}
+	if err != nil {
+		log.Println("warning: the history in", path, "can't be read and won't be saved until it is repaired:", err)
+		h.Days = make(map[string]rates.Data)
+		h.unreadable = true
+	}
+	return h
+}
+
+// writes b to path through a temporary file in the same directory that is renamed to path,
+// so the file is never left partly written
+func writeFileAtomic(path string, b []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// stores d as the rate table of its day and writes the history to disk
+func (h *History) record(d rates.Data) {
+	h.add(d)
+	h.save()
+}
+
+// stores d as the rate table of its day without writing the history to disk
+func (h *History) add(d rates.Data) {
+	if !d.Success || d.Date == "" {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.Days[d.Date] = d
+	h.version++
+}
+
+// returns the number of changes made to the history
+func (h *History) getVersion() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.version
+}
+
+// writes the history to disk, unless its file couldn't be read
+func (h *History) save() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.unreadable {
+		return
+	}
+	b, err := json.Marshal(h.Days)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = writeFileAtomic(h.path, b, 0644)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// returns the data stored for date
+func (h *History) get(date string) (rates.Data, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	d, ok := h.Days[date]
+	return d, ok
+}
+
+// returns the data of the given number of days up to and including end, oldest first
+// days without stored data are skipped
+func (h *History) lastDays(end time.Time, days int) []rates.Data {
+	var result []rates.Data
+	for i := days - 1; i >= 0; i-- {
+		date := end.AddDate(0, 0, -i).Format("2006-01-02")
+		if d, ok := h.get(date); ok {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// returns the stored dates between start and end (inclusive, YYYY-MM-DD) in order
+// an empty start or end leaves that side of the range open
+func (h *History) dates(start string, end string) []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var dates []string
+	for date := range h.Days {
+		if (start == "" || date >= start) && (end == "" || date <= end) {
+			dates = append(dates, date)
+		}
+	}
+	sort.Strings(dates)
+	return dates
+}
+
+// calls fn with the data of every stored day between start and end (inclusive, YYYY-MM-DD), oldest first
+// the history is only locked to look up each day, so it isn't blocked while fn writes to a slow client
+// stops at the first error fn returns and returns it
+func (h *History) each(start string, end string, fn func(d rates.Data) error) error {
+	for _, date := range h.dates(start, end) {
+		d, ok := h.get(date)
+		if !ok {
+			continue
+		}
+		if err := fn(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the first day the provider has rates of, set by earliest_date (1999-01-04, the first day of fixer)
+func getEarliestDate() string {
+	date := getEnv("earliest_date", "1999-01-04")
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		log.Println("earliest_date must be given as YYYY-MM-DD")
+		return "1999-01-04"
+	}
+	return date
+}
+
+// returns how long a failed fetch of a past day is answered with its error, set by historical_failure_ttl (10m)
+func getHistoricalFailureTTL() time.Duration {
+	ttl, err := time.ParseDuration(getEnv("historical_failure_ttl", "10m"))
+	if err != nil || ttl < 0 {
+		log.Println("historical_failure_ttl must be a duration, e.g. 10m")
+		return 10 * time.Minute
+	}
+	return ttl
+}
+
+// checks that date is empty or a past date in the form YYYY-MM-DD the provider has rates of
+func checkDate(date string) error {
+	if date == "" {
+		return nil
+	}
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return fmt.Errorf("%w: must be given as YYYY-MM-DD", ErrInvalidDate)
+	}
+	if day.After(time.Now()) {
+		return fmt.Errorf("%w: must not be in the future", ErrInvalidDate)
+	}
+	if earliest := getEarliestDate(); date < earliest {
+		return fmt.Errorf("%w: there are no rates before %s", ErrInvalidDate, earliest)
+	}
+	return nil
+}
+
+// returns the rates of the past date fetched from the provider of the server of ctx and stores them in its history
+// the fetch is shared with concurrent requests for date and isn't cancelled if ctx is, so they still get its result
+func fetchHistorical(ctx context.Context, date string) (rates.Data, error) {
+	s := serverFrom(ctx)
+	historicalFetches := &s.historicalFetches
+	historicalFetches.Lock()
+	f, ok := historicalFetches.m[date]
+	if ok && isClosed(f.done) && !time.Now().Before(f.retryAfter) {
+		ok = false
+	}
+	if !ok {
+		f = &HistoricalFetch{done: make(chan struct{})}
+		historicalFetches.m[date] = f
+		go func() {
+			fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+			defer cancel()
+			d, err := s.provider.Historical(fetchCtx, date)
+			if err == nil {
+				s.history.record(d)
+			}
+
+			historicalFetches.Lock()
+			f.d, f.err = d, err
+			if err == nil {
+				// the history answers requests for the day from now on
+				delete(historicalFetches.m, date)
+			} else {
+				f.retryAfter = time.Now().Add(getHistoricalFailureTTL())
+			}
+			historicalFetches.Unlock()
+			close(f.done)
+		}()
+	}
+	historicalFetches.Unlock()
+
+	select {
+	case <-f.done:
+		return f.d, f.err
+	case <-ctx.Done():
+		return rates.Data{}, ctx.Err()
+	}
+}
+
+// checks whether c is closed without blocking
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// returns the rates of date (YYYY-MM-DD) from the history of the server of ctx, fetching and storing them if they are missing
+// returns the current rates if date is empty, even if they are outdated because they can't be refreshed
+func dataFor(ctx context.Context, date string) (rates.Data, error) {
+	if date == "" {
+		d, err := serverFrom(ctx).cache.Get(ctx)
+		if errors.Is(err, rates.ErrStaleData) {
+			logError(err)
+			return withDerivedRates(ctx, d), nil
+		}
+		return withDerivedRates(ctx, d), err
+	}
+	if err := checkDate(date); err != nil {
+		return rates.Data{}, err
+	}
+
+	if d, ok := serverFrom(ctx).history.get(date); ok {
+		return withDerivedRates(ctx, d), nil
+	}
+
+	d, err := fetchHistorical(ctx, date)
+	if err != nil {
+		return d, err
+	}
+	return withDerivedRates(ctx, d), nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// language used for missing translations and if no supported language is requested
+const defaultLanguage = "en"
+
+// Catalog maps message keys to their text in one language
+type Catalog map[string]string
+
+// message catalogs by language, read from locales/<language>.json
+var catalogs = loadCatalogs("locales")
+
+// reads all message catalogs in dir
+func loadCatalogs(dir string) map[string]Catalog {
+	result := make(map[string]Catalog)
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		log.Println(err)
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		var c Catalog
+		err = json.Unmarshal(b, &c)
+		if err != nil {
+			log.Println(file, err)
+			continue
+		}
+		result[strings.TrimSuffix(filepath.Base(file), ".json")] = c
+	}
+	return result
+}
+
+// returns the text of key in lang, falling back to the default language and the key itself
+func translate(lang string, key string) string {
+	if text, ok := catalogs[lang][key]; ok {
+		return text
+	}
+	if text, ok := catalogs[defaultLanguage][key]; ok {
+		return text
+	}
+	return key
+}
+
+// Locale is a supported language and optionally a region, e.g. de-CH
+type Locale struct {
+	Language string
+	// empty if the region is unknown
+	Region string
+}
+
+func (l Locale) String() string {
+	if l.Region == "" {
+		return l.Language
+	}
+	return l.Language + "-" + l.Region
+}
+
+// decimal and thousands separators by language or locale
+var numberFormats = map[string][2]string{
+	"en":    {".", ","},
+	"de":    {",", "."},
+	"fr":    {",", "\u202f"},
+	"de-CH": {".", "\u2019"},
+	"fr-CH": {".", "\u202f"},
+}
+
+// local currency of each region
+var regionCurrencies = map[string]string{
+	"US": "USD", "GB": "GBP", "JP": "JPY", "AU": "AUD", "CH": "CHF", "LI": "CHF", "CN": "CNY", "HK": "HKD",
+	"NZ": "NZD", "SE": "SEK", "KR": "KRW", "SG": "SGD", "NO": "NOK", "MX": "MXN", "IN": "INR", "RU": "RUB",
+	"ZA": "ZAR", "TR": "TRY", "BR": "BRL",
+	"AT": "EUR", "BE": "EUR", "DE": "EUR", "ES": "EUR", "FI": "EUR", "FR": "EUR", "GR": "EUR", "IE": "EUR",
+	"IT": "EUR", "LU": "EUR", "NL": "EUR", "PT": "EUR",
+}
+
+// returns the templates of s with the functions of locale l
+func (s *Server) templatesFor(l Locale) *template.Template {
+	s.localizedTemplates.Lock()
+	defer s.localizedTemplates.Unlock()
+
+	t, ok := s.localizedTemplates.m[l]
+	if !ok {
+		clone, err := s.templates.Clone()
+		if err != nil {
+			log.Println(err)
+			return s.templates
+		}
+		t = clone.Funcs(templateFuncs(s.with(context.Background()), l))
+		s.localizedTemplates.m[l] = t
+	}
+	return t
+}
+
+// returns the functions available in templates for locale l, the currencies are those of the server of ctx
+func templateFuncs(ctx context.Context, l Locale) template.FuncMap {
+	return template.FuncMap{
+		"T":    func(key string) string { return translate(l.Language, key) },
+		"lang": func() string { return l.String() },
+		"num":  func(v float64) string { return formatNumber(l, v, 2) },
+		// num with the given number of decimals
+		"numTo": func(v float64, decimals int) string { return formatNumber(l, v, decimals) },
+		// static is the same for all locales
+		"static": staticURL,
+		// the currencies of the dropdowns with their names in l, the top ones first
+		"topCurrencies":   func() []CurrencyInfo { return topCurrencyList(ctx, l.Language) },
+		"otherCurrencies": func() []CurrencyInfo { return otherCurrencyList(ctx, l.Language) },
+	}
+}
+
+// formats v with the given number of decimals and the separators of locale l
+func formatNumber(l Locale, v float64, decimals int) string {
+	separators, ok := numberFormats[l.String()]
+	if !ok {
+		separators, ok = numberFormats[l.Language]
+	}
+	if !ok {
+		separators = numberFormats[defaultLanguage]
+	}
+
+	s := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+	integer, fraction := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		integer, fraction = s[:i], s[i+1:]
+	}
+	var grouped []string
+	for len(integer) > 3 {
+		grouped = append([]string{integer[len(integer)-3:]}, grouped...)
+		integer = integer[:len(integer)-3]
+	}
+	grouped = append([]string{integer}, grouped...)
+
+	s = strings.Join(grouped, separators[1])
+	if fraction != "" {
+		s += separators[0] + fraction
+	}
+	if v < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// returns the currencies the converter starts with for locale l, the local currency and EUR or USD
+func defaultCurrencies(l Locale) (string, string) {
+	local, ok := regionCurrencies[l.Region]
+	if !ok {
+		local = "EUR"
+	}
+	if local == "EUR" {
+		return local, "USD"
+	}
+	return local, "EUR"
+}
+
+// returns the supported locale of a language tag such as "de-CH"
+func parseLocale(tag string) (Locale, bool) {
+	parts := strings.Split(strings.TrimSpace(tag), "-")
+	l := Locale{Language: strings.ToLower(parts[0])}
+	if _, ok := catalogs[l.Language]; !ok {
+		return l, false
+	}
+	if len(parts) > 1 {
+		if region := strings.ToUpper(parts[len(parts)-1]); regionCurrencies[region] != "" {
+			l.Region = region
+		}
+	}
+	return l, true
+}
+
+// returns the language tags of an Accept-Language header or the media types of an Accept header, most preferred first
+func parseAccept(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if fields[0] == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{fields[0], q})
+		}
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
+
+// returns the locale requested by the lang parameter, the preferences of the session, the lang cookie or the Accept-Language header
+func getLocale(r *http.Request) Locale {
+	if l, ok := parseLocale(r.URL.Query().Get("lang")); ok {
+		return l
+	}
+	if l, ok := parseLocale(sessionValue(r, "locale")); ok {
+		return l
+	}
+	if c, err := r.Cookie("lang"); err == nil {
+		if l, ok := parseLocale(c.Value); ok {
+			return l
+		}
+	}
+	for _, tag := range parseAccept(r.Header.Get("Accept-Language")) {
+		if l, ok := parseLocale(tag); ok {
+			return l
+		}
+	}
+	return Locale{Language: defaultLanguage}
+}
+
+// remembers the locale given by the lang parameter in the lang cookie for a year
+func persistLocale(w http.ResponseWriter, r *http.Request) {
+	l, ok := parseLocale(r.URL.Query().Get("lang"))
+	if !ok {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: "lang", Value: l.String(), Path: "/", MaxAge: 365 * 24 * 3600})
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IdempotentRequest is the first request sent with an Idempotency-Key and the response it got
+type IdempotentRequest struct {
+	// hash of the method, url and body, a key may only be reused for the same request
+	Fingerprint string
+	// nil while the first request is still being handled
+	Response *CachedResponse
+	Expires  time.Time
+}
+
+// most Idempotency-Keys stored at once, so anonymous clients can't fill the memory
+const maxIdempotentRequests = 10000
+
+// requests sent with an Idempotency-Key by api key or client address, path and Idempotency-Key
+var idempotentRequests = struct {
+	sync.Mutex
+	m map[string]*IdempotentRequest
+	// starts the sweep of expired keys with the first one
+	sweeper sync.Once
+}{m: make(map[string]*IdempotentRequest)}
+
+// removes the expired requests every minute
+func sweepIdempotentRequests() {
+	for range time.Tick(time.Minute) {
+		now := time.Now()
+		idempotentRequests.Lock()
+		for k, req := range idempotentRequests.m {
+			if req.Response != nil && now.After(req.Expires) {
+				delete(idempotentRequests.m, k)
+			}
+		}
+		idempotentRequests.Unlock()
+	}
+}
+
+// returns how long Idempotency-Keys and their responses are kept, set by idempotency_retention (24 hours by default)
+func getIdempotencyRetention() time.Duration {
+	retention, err := time.ParseDuration(getEnv("idempotency_retention", "24h"))
+	if err != nil || retention <= 0 {
+		log.Println("idempotency_retention must be a positive duration, e.g. 24h")
+		return 24 * time.Hour
+	}
+	return retention
+}
+
+// checks whether key is 1 to 255 printable ascii characters
+func validIdempotencyKey(key string) bool {
+	if key == "" || len(key) > 255 {
+		return false
+	}
+	for _, c := range key {
+		if c < ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// stores a new request under key
+// returns the request already stored under key instead if there is one that hasn't expired,
+// an error if no more keys can be stored
+func claimIdempotencyKey(key string, fingerprint string) (*IdempotentRequest, bool, error) {
+	idempotentRequests.sweeper.Do(func() { go sweepIdempotentRequests() })
+	idempotentRequests.Lock()
+	defer idempotentRequests.Unlock()
+
+	if existing, ok := idempotentRequests.m[key]; ok && (existing.Response == nil || time.Now().Before(existing.Expires)) {
+		return existing, false, nil
+	}
+	if len(idempotentRequests.m) >= maxIdempotentRequests {
+		return nil, false, errors.New("no more Idempotency-Keys can be stored at the moment")
+	}
+	idempotentRequests.m[key] = &IdempotentRequest{Fingerprint: fingerprint}
+	return nil, true, nil
+}
+
+// keeps the response of the request stored under key for the retention, or forgets the key if response is nil
+func finishIdempotentRequest(key string, response *CachedResponse) {
+	idempotentRequests.Lock()
+	defer idempotentRequests.Unlock()
+
+	if response == nil {
+		delete(idempotentRequests.m, key)
+		return
+	}
+	req := idempotentRequests.m[key]
+	req.Response = response
+	req.Expires = time.Now().Add(getIdempotencyRetention())
+}
+
+// wraps h so a request retried with the same Idempotency-Key header gets the response of the first one
+// instead of creating a quote, alert or bulk conversion again
+// reusing a key for a different request fails with 422, retrying while the first request is handled with 409
+// errors of the server and rate limits aren't kept, so retrying after them runs h again, as do responses too large
+// to be cached
+func idempotent(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey := r.Header.Get("Idempotency-Key")
+		if idempotencyKey == "" {
+			h(w, r)
+			return
+		}
+		if !validIdempotencyKey(idempotencyKey) {
+			writeError(w, http.StatusBadRequest, "Idempotency-Key must be 1 to 255 printable ascii characters")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if bodyTooLarge(w, r, err) {
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "the body could not be read")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// anonymous clients don't share their keys, so no one is replayed the response of another
+		client := "ip:" + clientIP(r)
+		if apiKey := getAPIKey(r); apiKey != "" {
+			client = "key:" + apiKey
+		}
+		key := strings.Join([]string{client, r.URL.Path, idempotencyKey}, " ")
+		fingerprint := sha256Hex([]byte(r.Method + " " + r.URL.RequestURI() + "\n" + string(body)))
+		existing, ok, err := claimIdempotencyKey(key, fingerprint)
+		switch {
+		case err != nil:
+			writeError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		case !ok && existing.Fingerprint != fingerprint:
+			writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+			return
+		case !ok && existing.Response == nil:
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusConflict, "a request with this Idempotency-Key is still being handled")
+			return
+		case !ok:
+			for k, values := range existing.Response.Header {
+				w.Header()[k] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(existing.Response.Status)
+			w.Write(existing.Response.Body)
+			return
+		}
+
+		rec := &ResponseRecorder{ResponseWriter: w}
+		// the key is given up if h panics, so the retry isn't refused until the key expires
+		completed := false
+		defer func() {
+			status := rec.response.Status
+			if !completed || status == 0 || status >= 500 || status == http.StatusTooManyRequests || rec.tooLarge {
+				finishIdempotentRequest(key, nil)
+				return
+			}
+			rec.response.Header = w.Header().Clone()
+			for _, k := range []string{"X-Ratelimit-Limit", "X-Ratelimit-Remaining", "X-Ratelimit-Reset"} {
+				rec.response.Header.Del(k)
+			}
+			finishIdempotentRequest(key, &rec.response)
+		}()
+		h(rec, r)
+		completed = true
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// parses a comma separated list of networks such as "10.0.0.0/8, 192.168.1.5"
+// single addresses match only themselves, invalid entries are logged and skipped
+func parseCIDRs(list string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// checks whether ip is in one of networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checks whether r is sent to the admin or debug routes
+func isAdminRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/admin/") || strings.HasPrefix(r.URL.Path, "/debug/")
+}
+
+// wraps h so clients in deny_cidrs and, if allow_cidrs is set, clients outside of it are rejected with 403
+// admin_allow_cidrs restricts only the admin and debug routes in the same way
+func ipFilter(h http.Handler) http.Handler {
+	allow := parseCIDRs(os.Getenv("allow_cidrs"))
+	deny := parseCIDRs(os.Getenv("deny_cidrs"))
+	adminAllow := parseCIDRs(os.Getenv("admin_allow_cidrs"))
+	if len(allow) == 0 && len(deny) == 0 && len(adminAllow) == 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(clientIP(r))
+		blocked := ip == nil || containsIP(deny, ip) ||
+			(len(allow) > 0 && !containsIP(allow, ip)) ||
+			(len(adminAllow) > 0 && isAdminRequest(r) && !containsIP(adminAllow, ip))
+		if !blocked {
+			h.ServeHTTP(w, r)
+			return
+		}
+		requestError(w, r, http.StatusForbidden, "Access denied", "This page can't be accessed from your network.")
+	})
+}
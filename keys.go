@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"currconv/pkg/convert"
+)
+
+// APIKey stores the settings of a client's api key
+type APIKey struct {
+	Key string
+	// describes who the key belongs to
+	Name string
+	// replaces the global markup for conversions made with this key if set
+	Markup *convert.Markup
+	// requests per minute, api_key_rate_limit is used if 0
+	RateLimit int `json:"rate_limit"`
+}
+
+// reads the api keys from the api_keys environment variable (comma separated)
+// and the json file set by api_key_file, e.g. [{"key": "abc", "name": "shop", "markup": {"percent": 1.5, "fee": 0.2}, "rate_limit": 1000}]
+func loadAPIKeys() map[string]APIKey {
+	keys := make(map[string]APIKey)
+	for _, k := range strings.Split(os.Getenv("api_keys"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys[k] = APIKey{Key: k}
+		}
+	}
+
+	path := os.Getenv("api_key_file")
+	if path == "" {
+		return keys
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println(err)
+		return keys
+	}
+	var list []APIKey
+	err = json.Unmarshal(b, &list)
+	if err != nil {
+		log.Println(err)
+		return keys
+	}
+	for _, k := range list {
+		keys[k.Key] = k
+	}
+	return keys
+}
+
+// returns the api key sent in the X-API-Key header or the api_key url parameter
+func getAPIKey(r *http.Request) string {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = r.URL.Query().Get("api_key")
+	}
+	return key
+}
+
+// returns the markup of the request's api key, or the global markup if the key has none
+// no markup is charged if the markup feature is disabled for the client
+func markupFor(r *http.Request) convert.Markup {
+	if !featureEnabled(r, "markup") {
+		return convert.Markup{}
+	}
+	if k, ok := serverFrom(r.Context()).apiKeys[getAPIKey(r)]; ok && k.Markup != nil {
+		return *k.Markup
+	}
+	return getMarkup()
+}
+
+// wraps an API handler so requests with an unknown api key or over their rate limit are rejected
+// requests without a key are allowed, the API doesn't exist for clients the api feature is disabled for
+func apiHandler(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !featureEnabled(r, "api") {
+			notFound(w, r)
+			return
+		}
+		if key := getAPIKey(r); key != "" {
+			if _, ok := serverFrom(r.Context()).apiKeys[key]; !ok {
+				writeError(w, http.StatusUnauthorized, "invalid api key")
+				return
+			}
+		}
+		if !allowRequest(w, r) {
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// extends the expiry of KEYS[1] to ARGV[2] milliseconds only if this replica holds it
+const redisRenewScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+
+// Lease is held by one replica at a time through a key in Redis that expires unless its holder renews it
+// a replica that crashes loses its lease after TTL, so another one takes over
+type Lease struct {
+	Redis *Redis
+	Key   string
+	// identifies this replica, stored as value of Key
+	ID  string
+	TTL time.Duration
+
+	mutex sync.Mutex
+	// until when the lease is held, zero if it isn't
+	until time.Time
+}
+
+// returns a lease on key in the shared Redis, identified by the host name and a random suffix
+func newLease(key string, ttl time.Duration) *Lease {
+	host, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return &Lease{Redis: sharedRedis, Key: getEnv("redis_prefix", "currconv:") + key, ID: host + "-" + hex.EncodeToString(suffix), TTL: ttl}
+}
+
+// takes the lease if it is free or renews it if this replica holds it, returns whether it does
+func (l *Lease) acquire(ctx context.Context) bool {
+	start := time.Now()
+	ttl := strconv.FormatInt(l.TTL.Milliseconds(), 10)
+	reply, err := l.Redis.do(ctx, "SET", l.Key, l.ID, "NX", "PX", ttl)
+	if err == nil && reply == nil {
+		reply, err = l.Redis.do(ctx, "EVAL", redisRenewScript, "1", l.Key, l.ID, ttl)
+		if reply == int64(0) {
+			reply = nil
+		}
+	}
+	if err != nil {
+		log.Println("warning: lease", l.Key+":", err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	// while Redis can't be reached the lease is kept until it would have expired
+	if err == nil {
+		held := reply != nil
+		if held && l.until.IsZero() {
+			log.Println("Took the lease", l.Key, "as", l.ID)
+		} else if !held && !l.until.IsZero() {
+			log.Println("Lost the lease", l.Key)
+		}
+		l.until = time.Time{}
+		if held {
+			l.until = start.Add(l.TTL)
+		}
+	}
+	return time.Now().Before(l.until)
+}
+
+// checks whether this replica holds the lease
+func (l *Lease) held() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return time.Now().Before(l.until)
+}
+
+// tries to take or renew the lease every third of its TTL until done is closed
+func (l *Lease) keep(done <-chan struct{}) {
+	ticker := time.NewTicker(l.TTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), l.TTL/3)
+		l.acquire(ctx)
+		cancel()
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// gives the lease up if this replica holds it, so another one can take over right away
+func (l *Lease) release() {
+	l.mutex.Lock()
+	held := time.Now().Before(l.until)
+	l.until = time.Time{}
+	l.mutex.Unlock()
+	if !held {
+		return
+	}
+	if _, err := l.Redis.do(context.Background(), "EVAL", redisReleaseScript, "1", l.Key, l.ID); err != nil {
+		log.Println("redis:", err)
+	}
+}
+
+// the lease of the replica running the scheduled jobs and alerts, nil if no Redis server is shared
+var leadership struct {
+	sync.Mutex
+	lease *Lease
+	done  chan struct{}
+}
+
+// starts competing for the leadership of the replicas sharing redis_url, the leader runs the digest, snapshot upload,
+// backup pruning and alert jobs so they don't fire on every replica
+// without a shared Redis server every instance is its own leader
+func startLeaderElection() {
+	if sharedRedis == nil {
+		return
+	}
+	leadership.Lock()
+	defer leadership.Unlock()
+	if leadership.lease != nil {
+		return
+	}
+	leadership.lease = newLease("leader", 30*time.Second)
+	leadership.done = make(chan struct{})
+	// decided before the first refresh, whose alerts only the leader sends
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	leadership.lease.acquire(ctx)
+	cancel()
+	go leadership.lease.keep(leadership.done)
+}
+
+// checks whether this instance runs the jobs that must run on exactly one replica
+func isLeader() bool {
+	if sharedRedis == nil {
+		return true
+	}
+	leadership.Lock()
+	defer leadership.Unlock()
+	return leadership.lease != nil && leadership.lease.held()
+}
+
+// hands the leadership to another replica, called when the server shuts down
+func resignLeadership() {
+	leadership.Lock()
+	defer leadership.Unlock()
+	if leadership.lease == nil {
+		return
+	}
+	close(leadership.done)
+	leadership.lease.release()
+	leadership.lease = nil
+}
+
+// returns run wrapped so it is skipped on the replicas that aren't the leader
+func leaderOnly(run func()) func() {
+	return func() {
+		if !isLeader() {
+			log.Println("Skipped, another replica is the leader")
+			return
+		}
+		run()
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maximum size of request bodies, except for csv uploads which may have maxBulkSize
+const maxBodySize = 1 << 20
+
+// maximum length of path and query and maximum number of query parameters
+const maxURLLength = 4096
+const maxQueryParams = 64
+
+// returns the maximum body size of requests to path
+func bodyLimit(path string) int64 {
+	if path == "/bulk/" || path == "/api/bulk" {
+		return maxBulkSize
+	}
+	return maxBodySize
+}
+
+// wraps h so requests with a too long url or too many query parameters are rejected with 414
+// and bodies over their limit with 413, handlers reading them get an *http.MaxBytesError
+func limitRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.RequestURI()) > maxURLLength || strings.Count(r.URL.RawQuery, "&") >= maxQueryParams {
+			requestError(w, r, http.StatusRequestURITooLong, "URL too long",
+				"URLs may be at most "+strconv.Itoa(maxURLLength)+" characters with "+strconv.Itoa(maxQueryParams)+" parameters.")
+			return
+		}
+
+		limit := bodyLimit(r.URL.Path)
+		if r.ContentLength > limit {
+			requestError(w, r, http.StatusRequestEntityTooLarge, "Request too large", "The request may be at most "+formatSize(limit)+".")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// formats a size given in bytes in MB or KB
+func formatSize(n int64) string {
+	if n >= 1<<20 {
+		return strconv.FormatInt(n>>20, 10) + " MB"
+	}
+	return strconv.FormatInt(n>>10, 10) + " KB"
+}
+
+// parses the url encoded or multipart form sent in the body of r
+func parseForm(r *http.Request) error {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return r.ParseMultipartForm(32 << 20)
+	}
+	return r.ParseForm()
+}
+
+// writes a 413 response if err was caused by reading a body over its limit
+// returns whether it did
+func bodyTooLarge(w http.ResponseWriter, r *http.Request, err error) bool {
+	var maxErr *http.MaxBytesError
+	if !errors.As(err, &maxErr) {
+		return false
+	}
+	requestError(w, r, http.StatusRequestEntityTooLarge, "Request too large", "The request may be at most "+formatSize(maxErr.Limit)+".")
+	return true
+}
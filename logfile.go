@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// layout of the time appended to the name of a rotated log file, sorts in the order the files were rotated
+const logBackupLayout = "2006-01-02T15-04-05.000"
+
+// RotatingFile is a log file that is moved aside once it grows beyond MaxSize or is older than Interval
+// rotated files are named after Path with the time of their rotation appended, e.g. currconv.log.2024-01-31T00-00-00.000
+type RotatingFile struct {
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+	// when the current file was opened
+	opened time.Time
+
+	Path string
+	// size in bytes after which the file is rotated, 0 for no limit
+	MaxSize int64
+	// age after which the file is rotated, 0 to only rotate by size
+	Interval time.Duration
+	// number of rotated files kept, 0 keeps all of them
+	MaxBackups int
+	// age after which rotated files are deleted, 0 keeps them forever
+	Retention time.Duration
+}
+
+// writes p to the file, rotating it first if p doesn't fit or the file is too old
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	full := f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize
+	old := f.Interval > 0 && time.Since(f.opened) >= f.Interval
+	if full || old {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// opens the file at Path for appending, creating it and its directory if they don't exist
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// moves the current file aside, opens a new one and deletes the rotated files that aren't retained
+func (f *RotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+	if err := os.Rename(f.Path, f.Path+"."+time.Now().UTC().Format(logBackupLayout)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	f.prune()
+	return f.open()
+}
+
+// deletes the rotated files beyond MaxBackups and those rotated before Retention
+func (f *RotatingFile) prune() {
+	backups, err := filepath.Glob(f.Path + ".*")
+	if err != nil {
+		return
+	}
+	// newest first
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	for i, name := range backups {
+		rotated, err := time.Parse(logBackupLayout, name[len(f.Path)+1:])
+		if err != nil {
+			continue
+		}
+		if (f.MaxBackups > 0 && i >= f.MaxBackups) || (f.Retention > 0 && time.Since(rotated) > f.Retention) {
+			os.Remove(name)
+		}
+	}
+}
+
+// Close closes the current file, the next write opens it again
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// returns the file set by log_file that the log is written to in addition to its sink, for hosts without a log shipper
+// nil if none is set or it can't be opened
+// the file is rotated once it exceeds log_max_size_mb (100) or after log_rotate_interval (e.g. 24h, off by default),
+// log_max_backups (7, 0 keeps all) rotated files are kept and deleted after log_retention_days (0 keeps them forever)
+func openLogFile() io.Writer {
+	path := getEnv("log_file", "")
+	if path == "" {
+		return nil
+	}
+	maxSize, err := strconv.Atoi(getEnv("log_max_size_mb", "100"))
+	if err != nil || maxSize < 0 {
+		log.Println("log_max_size_mb must be a number of megabytes")
+		maxSize = 100
+	}
+	interval, err := time.ParseDuration(getEnv("log_rotate_interval", "0s"))
+	if err != nil || interval < 0 {
+		log.Println("log_rotate_interval must be a duration, e.g. 24h")
+		interval = 0
+	}
+	backups, err := strconv.Atoi(getEnv("log_max_backups", "7"))
+	if err != nil || backups < 0 {
+		log.Println("log_max_backups must be a number of files")
+		backups = 7
+	}
+
+	f := &RotatingFile{
+		Path:       path,
+		MaxSize:    int64(maxSize) << 20,
+		Interval:   interval,
+		MaxBackups: backups,
+		Retention:  time.Duration(getRetentionDays("log_retention_days")) * 24 * time.Hour,
+	}
+	if err := f.open(); err != nil {
+		log.Println("log_file:", err)
+		return nil
+	}
+	return f
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslog severities, journald uses the same values as PRIORITY
+const (
+	severityCritical = 2
+	severityError    = 3
+	severityWarning  = 4
+	severityInfo     = 6
+)
+
+// facilities that can be set by syslog_facility
+var syslogFacilities = map[string]int{
+	"user": 1, "daemon": 3,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// returns the severity of a line written by the log package and its message without the date and time
+// that the sinks record themselves, lines logged by logError start with their severity
+func logSeverity(line []byte) (int, string) {
+	msg := strings.TrimRight(string(line), "\n")
+	if log.Flags()&log.LstdFlags == log.LstdFlags && len(msg) >= len("2006/01/02 15:04:05 ") {
+		msg = msg[len("2006/01/02 15:04:05 "):]
+	}
+	switch {
+	case strings.HasPrefix(msg, "http: panic"):
+		return severityCritical, msg
+	case strings.HasPrefix(msg, "error:"):
+		return severityError, msg
+	case strings.HasPrefix(msg, "warning:"):
+		return severityWarning, msg
+	}
+	return severityInfo, msg
+}
+
+// Syslog sends every line of the log as RFC 5424 message to a syslog daemon
+type Syslog struct {
+	mutex sync.Mutex
+	conn  net.Conn
+	// e.g. unixgram and /dev/log, or udp and logs.example.com:514
+	Network  string
+	Addr     string
+	Facility int
+	AppName  string
+	Hostname string
+}
+
+// sends p with the severity of its line, reconnecting once if the daemon went away
+func (s *Syslog) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	severity, msg := logSeverity(p)
+	line := "<" + strconv.Itoa(s.Facility*8+severity) + ">1 " + time.Now().Format(time.RFC3339Nano) + " " +
+		s.Hostname + " " + s.AppName + " " + strconv.Itoa(os.Getpid()) + " - - " + msg
+	// streams need framing, datagrams carry one message each
+	if s.Network == "tcp" || s.Network == "unix" {
+		line = strconv.Itoa(len(line)) + " " + line
+	}
+	for attempt := 0; ; attempt++ {
+		if s.conn == nil {
+			conn, err := net.Dial(s.Network, s.Addr)
+			if err != nil {
+				return 0, err
+			}
+			s.conn = conn
+		}
+		_, err := s.conn.Write([]byte(line))
+		if err == nil {
+			return len(p), nil
+		}
+		s.conn.Close()
+		s.conn = nil
+		if attempt > 0 {
+			return 0, err
+		}
+	}
+}
+
+// the socket of journald's native protocol
+const journalSocket = "/run/systemd/journal/socket"
+
+// Journal sends every line of the log to systemd-journald with its priority
+type Journal struct {
+	mutex sync.Mutex
+	conn  net.Conn
+	// SYSLOG_IDENTIFIER of the entries
+	Identifier string
+}
+
+// appends a field of the native journal protocol to b, values with a newline are sent with their length
+func appendJournalField(b *bytes.Buffer, name string, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(name + "=" + value + "\n")
+		return
+	}
+	b.WriteString(name + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// sends p as an entry with the priority of its line
+func (j *Journal) Write(p []byte) (int, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.conn == nil {
+		conn, err := net.Dial("unixgram", journalSocket)
+		if err != nil {
+			return 0, err
+		}
+		j.conn = conn
+	}
+	severity, msg := logSeverity(p)
+	var b bytes.Buffer
+	appendJournalField(&b, "PRIORITY", strconv.Itoa(severity))
+	appendJournalField(&b, "SYSLOG_IDENTIFIER", j.Identifier)
+	appendJournalField(&b, "MESSAGE", msg)
+	if _, err := j.conn.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// returns the sink set by log_sink: stderr (default), syslog or journald
+// syslog sends to syslog_addr (e.g. udp://logs.example.com:514, the local daemon at /dev/log by default)
+// with the facility syslog_facility (daemon), both tag their messages with syslog_app_name (currconv)
+func openLogSink() (io.Writer, error) {
+	name := getEnv("syslog_app_name", "currconv")
+	switch sink := getEnv("log_sink", "stderr"); sink {
+	case "stderr":
+		return os.Stderr, nil
+	case "journald":
+		if _, err := os.Stat(journalSocket); err != nil {
+			return nil, errors.New("journald is not running: " + err.Error())
+		}
+		return &Journal{Identifier: name}, nil
+	case "syslog":
+		facility, ok := syslogFacilities[getEnv("syslog_facility", "daemon")]
+		if !ok {
+			return nil, errors.New("syslog_facility must be user, daemon or local0 to local7")
+		}
+		network, addr := "unixgram", getEnv("syslog_addr", "/dev/log")
+		if i := strings.Index(addr, "://"); i >= 0 {
+			network, addr = addr[:i], addr[i+3:]
+		}
+		hostname, err := os.Hostname()
+		if err != nil || hostname == "" {
+			hostname = "-"
+		}
+		s := &Syslog{Network: network, Addr: addr, Facility: facility, AppName: name, Hostname: hostname}
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		s.conn = conn
+		return s, nil
+	default:
+		return nil, errors.New("unknown log_sink " + sink + ", use stderr, syslog or journald")
+	}
+}
+
+// sends the log to the sink set by log_sink and to the file set by log_file
+// the log stays on stderr if the sink can't be used
+func setupLogging() {
+	sink, err := openLogSink()
+	if err != nil {
+		log.Println("log_sink:", err)
+		sink = os.Stderr
+	}
+	if f := openLogFile(); f != nil {
+		sink = io.MultiWriter(sink, f)
+	}
+	log.SetOutput(sink)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+)
+
+// LRU caches at most size values and drops the least recently used one when it is full
+type LRU struct {
+	mutex sync.Mutex
+	size  int
+	// most recently used entry first
+	order *list.List
+	items map[string]*list.Element
+}
+
+// lruEntry is a key and its value in the order of an LRU
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+// caches computed time series and statistics, sized by query_cache_size (256 entries by default)
+var queryCache = newLRU(getQueryCacheSize())
+
+// returns an empty LRU holding at most size values
+func newLRU(size int) *LRU {
+	return &LRU{size: size, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// returns the size set by query_cache_size
+func getQueryCacheSize() int {
+	size, err := strconv.Atoi(getEnv("query_cache_size", "256"))
+	if err != nil || size < 1 {
+		return 256
+	}
+	return size
+}
+
+// returns the value cached for key and marks it as recently used
+func (c *LRU) get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+// caches value for key, dropping the least recently used value if the cache is full
+func (c *LRU) add(key string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		e.Value.(*lruEntry).value = value
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key, value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"currconv/pkg/rates"
+)
+
+// metrics of the instrumented providers, in the order they were created
+var providerMetrics = struct {
+	sync.Mutex
+	list []*rates.ProviderMetrics
+}{}
+
+// requests served by route pattern, method and status and their durations by route pattern
+var requestMetrics = struct {
+	sync.Mutex
+	counts    map[requestLabels]uint64
+	durations map[string]*rates.Histogram
+}{counts: make(map[requestLabels]uint64), durations: make(map[string]*rates.Histogram)}
+
+// requestLabels identify the requests counted together
+type requestLabels struct {
+	Route  string
+	Method string
+	Status int
+}
+
+// key of the route pattern a request was dispatched to in its context
+type routeKey struct{}
+
+// StatusRecorder remembers the status a handler responded with
+type StatusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *StatusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *StatusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController flush streamed responses
+func (rec *StatusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// notes the pattern of the route r is dispatched to, for the metrics of measureRequests
+func setRoutePattern(r *http.Request, pattern string) {
+	if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+		*route = pattern
+	}
+}
+
+// wraps h so every request is counted and timed by the pattern of its route,
+// requests no route matches are counted as "unmatched" so random paths don't add labels
+func measureRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := "unmatched"
+		rec := &StatusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), routeKey{}, &route)))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		recordRequest(requestLabels{route, r.Method, rec.status}, time.Since(start))
+	})
+}
+
+// counts a request with labels that took duration
+func recordRequest(labels requestLabels, duration time.Duration) {
+	requestMetrics.Lock()
+	requestMetrics.counts[labels]++
+	h, ok := requestMetrics.durations[labels.Route]
+	if !ok {
+		h = &rates.Histogram{}
+		requestMetrics.durations[labels.Route] = h
+	}
+	h.Observe(duration.Seconds())
+	requestMetrics.Unlock()
+
+	statsd.timing("http.request.duration", duration, "route:"+labels.Route, "method:"+labels.Method, "status:"+strconv.Itoa(labels.Status))
+}
+
+// returns p wrapped so its requests are recorded in the metrics of the provider called name
+func instrument(name string, p rates.Provider) rates.Provider {
+	m := rates.NewProviderMetrics(name)
+	m.OnRequest = func(method string, duration time.Duration, err error) {
+		statsd.timing("provider.request.duration", duration, "provider:"+name, "method:"+method)
+		if err != nil {
+			statsd.count("provider.errors", 1, "provider:"+name, "class:"+rates.ErrorClass(err))
+		}
+	}
+	providerMetrics.Lock()
+	providerMetrics.list = append(providerMetrics.list, m)
+	providerMetrics.Unlock()
+	return rates.NewInstrumented(p, m)
+}
+
+// returns the stats of every instrumented provider
+func providerStats() []rates.ProviderStats {
+	providerMetrics.Lock()
+	defer providerMetrics.Unlock()
+
+	stats := make([]rates.ProviderStats, len(providerMetrics.list))
+	for i, m := range providerMetrics.list {
+		stats[i] = m.Stats()
+	}
+	return stats
+}
+
+// writes the help and type lines of a metric
+func writeMetricHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formats a sample value like Prometheus does
+func metricValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writes the metrics of the providers in the Prometheus text format
+func writeProviderMetrics(w io.Writer, stats []rates.ProviderStats) {
+	writeMetricHeader(w, "currconv_provider_request_duration_seconds", "histogram", "Duration of the requests to the rates provider.")
+	for _, s := range stats {
+		// sorted so the output doesn't change between scrapes
+		methods := make([]string, 0, len(s.Durations))
+		for method := range s.Durations {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			h := s.Durations[method]
+			writeHistogram(w, "currconv_provider_request_duration_seconds", fmt.Sprintf("provider=%q,method=%q,", s.Name, method), h)
+		}
+	}
+
+	writeMetricHeader(w, "currconv_provider_errors_total", "counter", "Failed requests to the rates provider by class of error.")
+	for _, s := range stats {
+		classes := make([]string, 0, len(s.Errors))
+		for class := range s.Errors {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			fmt.Fprintf(w, "currconv_provider_errors_total{provider=%q,class=%q} %d\n", s.Name, class, s.Errors[class])
+		}
+	}
+
+	writeMetricHeader(w, "currconv_provider_consecutive_failures", "gauge", "Requests to the rates provider that failed since the last successful one.")
+	for _, s := range stats {
+		fmt.Fprintf(w, "currconv_provider_consecutive_failures{provider=%q} %d\n", s.Name, s.ConsecutiveFailures)
+	}
+
+	writeMetricHeader(w, "currconv_provider_quota_remaining", "gauge", "Requests the plan of the rates provider has left, if it reports them.")
+	for _, s := range stats {
+		if s.QuotaRemaining >= 0 {
+			fmt.Fprintf(w, "currconv_provider_quota_remaining{provider=%q} %d\n", s.Name, s.QuotaRemaining)
+		}
+	}
+
+	writeMetricHeader(w, "currconv_provider_last_success_timestamp_seconds", "gauge", "Time of the last successful request to the rates provider.")
+	writeMetricHeader(w, "currconv_provider_seconds_since_last_success", "gauge", "Seconds since the last successful request to the rates provider.")
+	for _, s := range stats {
+		if s.LastSuccess.IsZero() {
+			continue
+		}
+		fmt.Fprintf(w, "currconv_provider_last_success_timestamp_seconds{provider=%q} %d\n", s.Name, s.LastSuccess.Unix())
+		fmt.Fprintf(w, "currconv_provider_seconds_since_last_success{provider=%q} %s\n", s.Name, metricValue(time.Since(s.LastSuccess).Round(time.Millisecond).Seconds()))
+	}
+}
+
+// writes the histogram of durations called name with labels, which end with a comma if there are any
+func writeHistogram(w io.Writer, name string, labels string, h rates.Histogram) {
+	for i, bound := range rates.DurationBuckets {
+		fmt.Fprintf(w, "%s_bucket{%sle=%q} %d\n", name, labels, metricValue(bound), h.Buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.Count)
+	if labels != "" {
+		labels = "{" + strings.TrimSuffix(labels, ",") + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, metricValue(h.Sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.Count)
+}
+
+// writes the request metrics in the Prometheus text format
+func writeRequestMetrics(w io.Writer) {
+	requestMetrics.Lock()
+	defer requestMetrics.Unlock()
+
+	labels := make([]requestLabels, 0, len(requestMetrics.counts))
+	for l := range requestMetrics.counts {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+		if a.Method != b.Method {
+			return a.Method < b.Method
+		}
+		return a.Status < b.Status
+	})
+	writeMetricHeader(w, "currconv_http_requests_total", "counter", "Requests served by route, method and status.")
+	for _, l := range labels {
+		fmt.Fprintf(w, "currconv_http_requests_total{route=%q,method=%q,status=\"%d\"} %d\n", l.Route, l.Method, l.Status, requestMetrics.counts[l])
+	}
+
+	routes := make([]string, 0, len(requestMetrics.durations))
+	for route := range requestMetrics.durations {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	writeMetricHeader(w, "currconv_http_request_duration_seconds", "histogram", "Duration of the requests served by route.")
+	for _, route := range routes {
+		writeHistogram(w, "currconv_http_request_duration_seconds", fmt.Sprintf("route=%q,", route), *requestMetrics.durations[route])
+	}
+}
+
+// returns the age of the rates cached by the server of ctx in seconds, -1 if there are none, and whether they are stale
+func ratesAge(ctx context.Context) (float64, bool) {
+	s := serverFrom(ctx).cache.Status()
+	if s.Timestamp == 0 {
+		return -1, s.Stale
+	}
+	return time.Since(time.Unix(s.Timestamp, 0)).Round(time.Second).Seconds(), s.Stale
+}
+
+// writes the state of the rates cached by the server of ctx in the Prometheus text format
+func writeCacheMetrics(ctx context.Context, w io.Writer) {
+	age, stale := ratesAge(ctx)
+	if age >= 0 {
+		writeMetricHeader(w, "currconv_rates_age_seconds", "gauge", "Age of the cached rates.")
+		fmt.Fprintf(w, "currconv_rates_age_seconds %s\n", metricValue(age))
+	}
+	writeMetricHeader(w, "currconv_rates_stale", "gauge", "Whether the cached rates are outdated because they can't be refreshed.")
+	fmt.Fprintf(w, "currconv_rates_stale %d\n", boolMetric(stale))
+
+	s := serverFrom(ctx).cache.Stats()
+	writeMetricHeader(w, "currconv_rates_cache_lookups_total", "counter", "Lookups of the cached rates by result: hit, miss (refreshed) or stale (served outdated).")
+	for _, result := range []string{rates.CacheHit, rates.CacheMiss, rates.CacheStale} {
+		fmt.Fprintf(w, "currconv_rates_cache_lookups_total{result=%q} %d\n", result, s.Lookups[result])
+	}
+	writeMetricHeader(w, "currconv_rates_cache_refresh_failures_total", "counter", "Refreshes of the cached rates that failed.")
+	fmt.Fprintf(w, "currconv_rates_cache_refresh_failures_total %d\n", s.RefreshFailures)
+	if s.Refreshes.Count > 0 {
+		writeMetricHeader(w, "currconv_rates_cache_refresh_duration_seconds", "histogram", "Duration of the refreshes of the cached rates.")
+		writeHistogram(w, "currconv_rates_cache_refresh_duration_seconds", "", s.Refreshes)
+	}
+
+	lookups := responseCacheLookups()
+	writeMetricHeader(w, "currconv_response_cache_lookups_total", "counter", "Lookups of cached API responses by result: hit, miss or expired.")
+	for _, result := range []string{"hit", "miss", "expired"} {
+		fmt.Fprintf(w, "currconv_response_cache_lookups_total{result=%q} %d\n", result, lookups[result])
+	}
+}
+
+// forwards the lookups and refresh durations of cache to StatsD
+func instrumentCache(cache *rates.Cache) {
+	cache.OnLookup = func(result string) {
+		statsd.count("rates.cache.lookups", 1, "result:"+result)
+	}
+	cache.OnRefreshTimed = func(duration time.Duration, err error) {
+		statsd.timing("rates.cache.refresh.duration", duration, "success:"+strconv.FormatBool(err == nil))
+	}
+}
+
+// returns 1 for true and 0 for false
+func boolMetric(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writes the metrics in the Prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	writeRequestMetrics(w)
+	writeCacheMetrics(r.Context(), w)
+	writeProviderMetrics(w, providerStats())
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"currconv/pkg/rates"
+)
+
+// MQTT 3.1.1 control packet types (already shifted into the upper nibble of the fixed header)
+const (
+	mqttConnect    = 0x10
+	mqttConnack    = 0x20
+	mqttPublish    = 0x30
+	mqttDisconnect = 0xE0
+)
+
+// appends an MQTT length prefixed string to b
+func appendMQTTString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+// writes an MQTT packet with the given fixed header byte and body to w
+func writeMQTTPacket(w *bufio.Writer, header byte, body []byte) error {
+	w.WriteByte(header)
+
+	// remaining length is encoded in 7 bit groups, least significant first
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		w.WriteByte(b)
+		if length == 0 {
+			break
+		}
+	}
+
+	_, err := w.Write(body)
+	return err
+}
+
+// opens a connection to the MQTT broker and performs the CONNECT handshake
+func connectMQTT(broker string, clientID string, user string, password string) (net.Conn, *bufio.Writer, error) {
+	conn, err := net.DialTimeout("tcp", broker, 10*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	// clean session
+	var flags byte = 0x02
+	if user != "" {
+		flags |= 0x80
+	}
+	if password != "" {
+		flags |= 0x40
+	}
+
+	body := appendMQTTString(nil, "MQTT")
+	// protocol level 4 (3.1.1), connect flags, keep alive of 60 seconds
+	body = append(body, 4, flags, 0, 60)
+	body = appendMQTTString(body, clientID)
+	if user != "" {
+		body = appendMQTTString(body, user)
+	}
+	if password != "" {
+		body = appendMQTTString(body, password)
+	}
+
+	w := bufio.NewWriter(conn)
+	writeMQTTPacket(w, mqttConnect, body)
+	err = w.Flush()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	// CONNACK: header, remaining length 2, session present flag, return code
+	ack := make([]byte, 4)
+	_, err = io.ReadFull(conn, ack)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if ack[0] != mqttConnack || ack[3] != 0 {
+		conn.Close()
+		return nil, nil, errors.New("mqtt: connection refused with code " + strconv.Itoa(int(ack[3])))
+	}
+
+	return conn, w, nil
+}
+
+// publishes the rate of every currency to the MQTT broker set in the mqtt_broker environment variable
+// each rate is published as a retained message to <mqtt_topic_prefix>/<base>/<currency>
+// only the leader publishes, every replica sharing the refreshes is notified of the same rates
+func publishMQTT(d rates.Data) {
+	broker := os.Getenv("mqtt_broker")
+	if broker == "" || !d.Success || !isLeader() {
+		return
+	}
+
+	conn, w, err := connectMQTT(broker, getEnv("mqtt_client_id", "currencyconverter"), os.Getenv("mqtt_user"), os.Getenv("mqtt_password"))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer conn.Close()
+
+	prefix := getEnv("mqtt_topic_prefix", "rates")
+	for currency, rate := range d.Rates {
+		var body bytes.Buffer
+		body.Write(appendMQTTString(nil, fmt.Sprintf("%s/%s/%s", prefix, d.Base, currency)))
+		body.WriteString(strconv.FormatFloat(rate, 'f', -1, 64))
+
+		// QoS 0 with the retain flag set so new subscribers get the latest rate immediately
+		writeMQTTPacket(w, mqttPublish|0x01, body.Bytes())
+	}
+	writeMQTTPacket(w, mqttDisconnect, nil)
+
+	err = w.Flush()
+	if err != nil {
+		log.Println(err)
+	}
+}
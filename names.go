@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// currencies listed first in the dropdowns and offered as quick picks on the index page, in this order
+var topCurrencies = splitCurrencies(getEnv("top_currencies", "USD,EUR,GBP,JPY,CHF,CAD,AUD,CNY"))
+
+// CurrencyInfo describes a currency for /api/currencies and the dropdowns
+type CurrencyInfo struct {
+	Code string `json:"code"`
+	// in the requested language, empty if it isn't known
+	Name string `json:"name,omitempty"`
+	// whether it was defined by an admin, see VirtualCurrency
+	Virtual bool `json:"virtual,omitempty"`
+	// primary country and its emoji flag, empty for currencies without one
+	Country string `json:"country,omitempty"`
+	Flag    string `json:"flag,omitempty"`
+}
+
+// returns the name of the currency with code in lang from the currency.<code> messages of the catalogs
+// the virtual currencies of the server of ctx have the name they were defined with, unknown ones an empty name
+func currencyName(ctx context.Context, lang string, code string) string {
+	key := "currency." + code
+	if name := translate(lang, key); name != key {
+		return name
+	}
+	if c, ok := serverFrom(ctx).virtualCurrencies.get(code); ok {
+		return c.Name
+	}
+	return ""
+}
+
+// returns the names of the currency with code in every language, to match user input against
+func currencyNames(ctx context.Context, code string) []string {
+	var names []string
+	for language := range catalogs {
+		if name := currencyName(ctx, language, code); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// returns the description of the currency with code in lang
+func currencyInfo(ctx context.Context, lang string, code string) CurrencyInfo {
+	info := CurrencyInfo{Code: code, Name: currencyName(ctx, lang, code)}
+	info.Country, info.Flag = currencyFlag(code)
+	_, info.Virtual = serverFrom(ctx).virtualCurrencies.get(code)
+	return info
+}
+
+// returns the top currencies the current rates have, with their names in lang
+func topCurrencyList(ctx context.Context, lang string) []CurrencyInfo {
+	d := getCurrentData(ctx)
+	var list []CurrencyInfo
+	for _, code := range topCurrencies {
+		if _, ok := d.Rates[code]; ok {
+			list = append(list, currencyInfo(ctx, lang, code))
+		}
+	}
+	return list
+}
+
+// returns the other currencies of the current rates in alphabetical order, with their names in lang
+// retired currencies aren't offered
+func otherCurrencyList(ctx context.Context, lang string) []CurrencyInfo {
+	top := make(map[string]bool, len(topCurrencies))
+	for _, code := range topCurrencies {
+		top[code] = true
+	}
+	var list []CurrencyInfo
+	for _, code := range sortedCurrencies(getCurrentData(ctx)) {
+		if _, retired := retiredCurrencies[code]; !retired && !top[code] {
+			list = append(list, currencyInfo(ctx, lang, code))
+		}
+	}
+	return list
+}
+
+// writes the currencies of the current rates with their names in the language of the lang parameter,
+// the session, the lang cookie or the Accept-Language header, the q parameter filters them by code or name
+// retired currencies can still be converted but aren't listed
+func apiCurrenciesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept-Language")
+	ctx := r.Context()
+	lang := getLocale(r).Language
+	list := []CurrencyInfo{}
+	for _, code := range sortedCurrencies(getCurrentData(ctx)) {
+		if _, retired := retiredCurrencies[code]; !retired {
+			list = append(list, currencyInfo(ctx, lang, code))
+		}
+	}
+	if q := strings.ToUpper(r.URL.Query().Get("q")); q != "" {
+		filtered := []CurrencyInfo{}
+		for _, c := range list {
+			if strings.HasPrefix(c.Code, q) || strings.Contains(strings.ToUpper(c.Name), q) {
+				filtered = append(filtered, c)
+			}
+		}
+		list = filtered
+	}
+	writeJSON(w, list)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"currconv/pkg/rates"
+)
+
+// RetiredCurrency is a currency code that is no longer in use
+type RetiredCurrency struct {
+	Successor string
+	// units of the retired currency one unit of Successor replaced, 0 if there was no fixed conversion
+	Factor float64
+	// year the currency stopped being legal tender
+	Retired int
+}
+
+// retired currencies by their code, the euro currencies at their irrevocable conversion rates
+// and redenominations at the number of zeros they removed
+var retiredCurrencies = map[string]RetiredCurrency{
+	"ATS": {"EUR", 13.7603, 2002},
+	"BEF": {"EUR", 40.3399, 2002},
+	"CYP": {"EUR", 0.585274, 2008},
+	"DEM": {"EUR", 1.95583, 2002},
+	"EEK": {"EUR", 15.6466, 2011},
+	"ESP": {"EUR", 166.386, 2002},
+	"FIM": {"EUR", 5.94573, 2002},
+	"FRF": {"EUR", 6.55957, 2002},
+	"GRD": {"EUR", 340.75, 2002},
+	"HRK": {"EUR", 7.5345, 2023},
+	"IEP": {"EUR", 0.787564, 2002},
+	"ITL": {"EUR", 1936.27, 2002},
+	"LTL": {"EUR", 3.4528, 2015},
+	"LUF": {"EUR", 40.3399, 2002},
+	"LVL": {"EUR", 0.702804, 2014},
+	"MTL": {"EUR", 0.4293, 2008},
+	"NLG": {"EUR", 2.20371, 2002},
+	"PTE": {"EUR", 200.482, 2002},
+	"SIT": {"EUR", 239.64, 2007},
+	"SKK": {"EUR", 30.126, 2009},
+	"AZM": {"AZN", 5000, 2006},
+	"BYR": {"BYN", 10000, 2016},
+	"GHC": {"GHS", 10000, 2007},
+	"MRO": {"MRU", 10, 2018},
+	"MZM": {"MZN", 1000, 2006},
+	"ROL": {"RON", 10000, 2005},
+	"RUR": {"RUB", 1000, 1998},
+	"SDD": {"SDG", 100, 2007},
+	"STD": {"STN", 1000, 2018},
+	"TRL": {"TRY", 1000000, 2005},
+	"VEB": {"VEF", 1000, 2008},
+	"VEF": {"VES", 100000, 2018},
+	"ZMK": {"ZMW", 1000, 2013},
+	// abandoned during hyperinflation, the US dollar took over without an official rate
+	"ZWD": {"USD", 0, 2009},
+}
+
+// returns the rate of currency in d, following retired currencies to their successors
+func retiredRate(d rates.Data, currency string) (float64, bool) {
+	if rate, ok := d.Rates[currency]; ok {
+		return rate, true
+	}
+	if currency == d.Base {
+		return 1, true
+	}
+	r, ok := retiredCurrencies[currency]
+	if !ok || r.Factor == 0 {
+		return 0, false
+	}
+	rate, ok := retiredRate(d, r.Successor)
+	return rate * r.Factor, ok
+}
+
+// returns d with rates added for those of currencies that are retired and have a fixed conversion to a currency of d,
+// d itself is left unchanged
+// retired currencies are only resolved when a conversion asks for them, so they don't show up in every rate table
+// rates d quotes itself, e.g. on dates before a currency was retired, are kept
+func withRetiredRates(d rates.Data, currencies ...string) rates.Data {
+	var withRates map[string]float64
+	for _, currency := range currencies {
+		if _, quoted := d.Rates[currency]; quoted {
+			continue
+		}
+		if _, retired := retiredCurrencies[currency]; !retired {
+			continue
+		}
+		rate, ok := retiredRate(d, currency)
+		if !ok {
+			continue
+		}
+		if withRates == nil {
+			withRates = make(map[string]float64, len(d.Rates)+len(currencies))
+			for c, r := range d.Rates {
+				withRates[c] = r
+			}
+		}
+		withRates[currency] = rate
+	}
+	if withRates != nil {
+		d.Rates = withRates
+	}
+	return d
+}
+
+// returns an error explaining that currency was retired, nil if it is in use
+func retiredError(currency string) error {
+	r, ok := retiredCurrencies[currency]
+	if !ok {
+		return nil
+	}
+	if r.Factor == 0 {
+		return fmt.Errorf("%w %s, it was retired in %d and replaced by %s without a fixed rate", rates.ErrUnknownCurrency, currency, r.Retired, r.Successor)
+	}
+	return fmt.Errorf("%w %s, it was retired in %d and replaced by %s at %s %s per %s", rates.ErrUnknownCurrency, currency, r.Retired, r.Successor,
+		strconv.FormatFloat(r.Factor, 'f', -1, 64), currency, r.Successor)
+}
+
+// sets a Warning header for every retired currency among currencies, naming its successor and conversion
+func setRetiredWarnings(w http.ResponseWriter, currencies ...string) {
+	for _, c := range currencies {
+		if r, ok := retiredCurrencies[c]; ok && r.Factor > 0 {
+			w.Header().Add("Warning", fmt.Sprintf(`299 - "%s was replaced by %s in %d at %s %s per %s"`, c, r.Successor, r.Retired,
+				strconv.FormatFloat(r.Factor, 'f', -1, 64), c, r.Successor))
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// value both series of an overlay start at
+const overlayBase = 100
+
+// OverlayPoint is the rate of a pair on one day and the rate relative to the first day of the overlay
+type OverlayPoint struct {
+	Date  string  `json:"date"`
+	Rate  float64 `json:"rate"`
+	Value float64 `json:"value"`
+}
+
+// OverlaySeries stores one pair of an overlay
+type OverlaySeries struct {
+	From   string         `json:"from"`
+	To     string         `json:"to"`
+	Points []OverlayPoint `json:"points"`
+	// change from the first to the last day in percent
+	Change float64 `json:"change"`
+}
+
+// Overlay stores two pairs over the days between start and end on which both have a rate,
+// normalized so both start at overlayBase
+type Overlay struct {
+	Start  string          `json:"start"`
+	End    string          `json:"end"`
+	Base   float64         `json:"base"`
+	Series []OverlaySeries `json:"series"`
+}
+
+// builds the overlay of the pairs from/to between start and end (inclusive, YYYY-MM-DD) of the stored history
+func newOverlay(ctx context.Context, pairs [2][2]string, start string, end string) Overlay {
+	o := Overlay{Start: start, End: end, Base: overlayBase, Series: make([]OverlaySeries, len(pairs))}
+	for i, pair := range pairs {
+		o.Series[i] = OverlaySeries{From: pair[0], To: pair[1], Points: []OverlayPoint{}}
+	}
+	seconds := make(map[string]float64)
+	for _, p := range timeseries(ctx, pairs[1][0], pairs[1][1], start, end) {
+		seconds[p.Date] = p.Rate
+	}
+
+	// the days of the first pair are in order, only those the second pair shares are kept
+	var first [2]float64
+	for _, p := range timeseries(ctx, pairs[0][0], pairs[0][1], start, end) {
+		second, ok := seconds[p.Date]
+		if !ok || p.Rate == 0 || second == 0 {
+			continue
+		}
+		day := [2]float64{p.Rate, second}
+		if first[0] == 0 {
+			first = day
+		}
+		for i := range o.Series {
+			value := day[i] / first[i] * overlayBase
+			o.Series[i].Points = append(o.Series[i].Points, OverlayPoint{p.Date, day[i], value})
+			o.Series[i].Change = value - overlayBase
+		}
+	}
+	return o
+}
+
+// returns the two pair parameters of r, e.g. pair=EUR/USD&pair=GBP/USD
+func overlayPairs(r *http.Request) ([2][2]string, bool) {
+	var pairs [2][2]string
+	params := r.URL.Query()["pair"]
+	if len(params) != 2 {
+		return pairs, false
+	}
+	d := getCurrentData(r.Context())
+	for i, param := range params {
+		from, to, ok := parsePair(d, param)
+		if !ok {
+			return pairs, false
+		}
+		pairs[i] = [2]string{from, to}
+	}
+	return pairs, true
+}
+
+// writes two pairs given as pair parameters normalized to a common start as json
+// the range can be limited with the start and end parameters (YYYY-MM-DD)
+func apiOverlayHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	start := query.Get("start")
+	end := query.Get("end")
+
+	pairs, ok := overlayPairs(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "two pairs must be given as pair=FROM/TO, e.g. pair=EUR/USD&pair=GBP/USD")
+		return
+	}
+	if !validDate(start) || !validDate(end) {
+		writeError(w, http.StatusBadRequest, "start and end must be given as YYYY-MM-DD")
+		return
+	}
+	writeJSON(w, newOverlay(r.Context(), pairs, start, end))
+}
+
+// OverlayPage stores variables for /overlay/
+type OverlayPage struct {
+	// the submitted pairs, shown again in the form
+	Pairs   [2]string
+	Days    int
+	Overlay *Overlay
+	// first and last day shown
+	First string
+	Last  string
+	// svg polylines of the series, empty if there is nothing to draw
+	Lines   [2]string
+	Width   int
+	Height  int
+	Message string
+}
+
+// draws two pairs given as pair parameters over the last days given by the days parameter, both starting at 100
+func overlayHandler(w http.ResponseWriter, r *http.Request) {
+	p := OverlayPage{Pairs: [2]string{"EUR/USD", "GBP/USD"}, Days: chartDays(r), Width: chartWidth, Height: chartHeight}
+	if params := r.URL.Query()["pair"]; len(params) == 0 {
+		renderTemplate(w, r, "overlay", &p)
+		return
+	} else if len(params) == 2 {
+		p.Pairs = [2]string{params[0], params[1]}
+	}
+
+	pairs, ok := overlayPairs(r)
+	if !ok {
+		p.Message = "Please give two pairs as FROM/TO, e.g. EUR/USD."
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, "overlay", &p)
+		return
+	}
+	o := newOverlay(r.Context(), pairs, rangeStart(p.Days), "")
+	p.Overlay = &o
+	for i := range p.Pairs {
+		p.Pairs[i] = pairs[i][0] + "/" + pairs[i][1]
+	}
+
+	if points := o.Series[0].Points; len(points) > 0 {
+		p.First, p.Last = points[0].Date, points[len(points)-1].Date
+		// both lines share one scale so their distance shows the relative performance
+		var values []float64
+		for _, s := range o.Series {
+			for _, point := range s.Points {
+				values = append(values, point.Value)
+			}
+		}
+		min, max := valueRange(values)
+		for i, s := range o.Series {
+			line := make([]float64, len(s.Points))
+			for j, point := range s.Points {
+				line[j] = point.Value
+			}
+			p.Lines[i] = chartPoints(line, len(line), min, max)
+		}
+	}
+	renderTemplate(w, r, "overlay", &p)
+}
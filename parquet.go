@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// kinds of the columns of a parquet file
+const (
+	ParquetString = iota
+	// days since 1970-01-01, given as time.Time
+	ParquetDate
+	ParquetDouble
+)
+
+// ParquetColumn is a required column of a parquet file
+type ParquetColumn struct {
+	Name string
+	Kind int
+}
+
+// physical types, converted types, encodings and page types of the parquet format
+const (
+	parquetInt32        = 1
+	parquetDouble       = 5
+	parquetByteArray    = 6
+	parquetUTF8         = 0
+	parquetDateType     = 6
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetDataPage     = 0
+	parquetRequired     = 0
+	parquetUncompressed = 0
+)
+
+// types of the thrift compact protocol the parquet metadata is encoded with
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// ThriftWriter encodes structs in the thrift compact protocol
+type ThriftWriter struct {
+	bytes.Buffer
+	// id of the last field written in each open struct, field ids are encoded as difference to it
+	last []int16
+}
+
+func (t *ThriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	t.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+// writes v zigzag encoded, the encoding of i32 and i64 values
+func (t *ThriftWriter) zigzag(v int64) {
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *ThriftWriter) field(id int16, typ byte) {
+	delta := id - t.last[len(t.last)-1]
+	if delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.WriteByte(typ)
+		t.zigzag(int64(id))
+	}
+	t.last[len(t.last)-1] = id
+}
+
+func (t *ThriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *ThriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *ThriftWriter) str(s string) {
+	t.varint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+func (t *ThriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.str(s)
+}
+
+// writes the header of a list of n elements of type typ, followed by the elements
+func (t *ThriftWriter) list(id int16, typ byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | typ)
+	} else {
+		t.WriteByte(0xf0 | typ)
+		t.varint(uint64(n))
+	}
+}
+
+// starts a struct that is an element of a list or the message itself, end closes it
+func (t *ThriftWriter) begin() {
+	t.last = append(t.last, 0)
+}
+
+// starts a struct that is the field id of the current one
+func (t *ThriftWriter) beginField(id int16) {
+	t.field(id, thriftStruct)
+	t.begin()
+}
+
+func (t *ThriftWriter) end() {
+	t.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// returns the physical type of kind
+func parquetPhysicalType(kind int) int32 {
+	switch kind {
+	case ParquetDate:
+		return parquetInt32
+	case ParquetDouble:
+		return parquetDouble
+	}
+	return parquetByteArray
+}
+
+// appends the value v of column i PLAIN encoded to b
+func appendParquetValue(b *bytes.Buffer, v interface{}, i int, kind int) error {
+	var n [8]byte
+	switch v := v.(type) {
+	case string:
+		if kind != ParquetString {
+			return fmt.Errorf("parquet: string in column %d", i)
+		}
+		binary.LittleEndian.PutUint32(n[:4], uint32(len(v)))
+		b.Write(n[:4])
+		b.WriteString(v)
+	case time.Time:
+		if kind != ParquetDate {
+			return fmt.Errorf("parquet: date in column %d", i)
+		}
+		days := int32(math.Floor(float64(v.Unix()) / 86400))
+		binary.LittleEndian.PutUint32(n[:4], uint32(days))
+		b.Write(n[:4])
+	case float64:
+		if kind != ParquetDouble {
+			return fmt.Errorf("parquet: number in column %d", i)
+		}
+		binary.LittleEndian.PutUint64(n[:], math.Float64bits(v))
+		b.Write(n[:])
+	default:
+		return fmt.Errorf("parquet: unsupported value %T in column %d", v, i)
+	}
+	return nil
+}
+
+// writes rows as a parquet file with the given columns, so data tools can load them without a conversion
+// the values of each row are in the order of columns, strings, time.Time for dates and float64 values
+// the file has one row group with one uncompressed, PLAIN encoded page per column
+// the rows are read once and only their encoded values are kept, since each page must be complete before it is written
+func writeParquet(w io.Writer, columns []ParquetColumn, rows Rows) error {
+	pages := make([]bytes.Buffer, len(columns))
+	n := 0
+	err := rows(func(row []interface{}) error {
+		if len(row) != len(columns) {
+			return fmt.Errorf("parquet: row %d has %d values for %d columns", n, len(row), len(columns))
+		}
+		for i, c := range columns {
+			if err := appendParquetValue(&pages[i], row[i], i, c.Kind); err != nil {
+				return err
+			}
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, "PAR1"); err != nil {
+		return err
+	}
+	offset := int64(4)
+
+	// metadata of each column chunk, written in the footer
+	type chunk struct {
+		offset int64
+		size   int64
+	}
+	chunks := make([]chunk, len(columns))
+	for i := range columns {
+		values := pages[i].Bytes()
+
+		// required columns that aren't nested have neither repetition nor definition levels
+		header := &ThriftWriter{}
+		header.begin()
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(values)))
+		header.i32(3, int32(len(values)))
+		header.beginField(5)
+		header.i32(1, int32(n))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+
+		chunks[i] = chunk{offset, int64(header.Len() + len(values))}
+		if _, err := w.Write(header.Bytes()); err != nil {
+			return err
+		}
+		if _, err := w.Write(values); err != nil {
+			return err
+		}
+		offset += chunks[i].size
+	}
+
+	footer := &ThriftWriter{}
+	footer.begin()
+	footer.i32(1, 1)
+	footer.list(2, thriftStruct, len(columns)+1)
+	footer.begin()
+	footer.binary(4, "schema")
+	footer.i32(5, int32(len(columns)))
+	footer.end()
+	for _, c := range columns {
+		footer.begin()
+		footer.i32(1, parquetPhysicalType(c.Kind))
+		footer.i32(3, parquetRequired)
+		footer.binary(4, c.Name)
+		switch c.Kind {
+		case ParquetString:
+			footer.i32(6, parquetUTF8)
+			footer.beginField(10)
+			footer.beginField(1)
+			footer.end()
+			footer.end()
+		case ParquetDate:
+			footer.i32(6, parquetDateType)
+			footer.beginField(10)
+			footer.beginField(6)
+			footer.end()
+			footer.end()
+		}
+		footer.end()
+	}
+	footer.i64(3, int64(n))
+
+	var total int64
+	for _, c := range chunks {
+		total += c.size
+	}
+	footer.list(4, thriftStruct, 1)
+	footer.begin()
+	footer.list(1, thriftStruct, len(columns))
+	for i, c := range columns {
+		footer.begin()
+		footer.i64(2, chunks[i].offset)
+		footer.beginField(3)
+		footer.i32(1, parquetPhysicalType(c.Kind))
+		footer.list(2, thriftI32, 2)
+		footer.zigzag(parquetPlain)
+		footer.zigzag(parquetRLE)
+		footer.list(3, thriftBinary, 1)
+		footer.str(c.Name)
+		footer.i32(4, parquetUncompressed)
+		footer.i64(5, int64(n))
+		footer.i64(6, chunks[i].size)
+		footer.i64(7, chunks[i].size)
+		footer.i64(9, chunks[i].offset)
+		footer.end()
+		footer.end()
+	}
+	footer.i64(2, total)
+	footer.i64(3, int64(n))
+	footer.end()
+	footer.binary(6, "currconv")
+	footer.end()
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(footer.Len()))
+	footer.Write(length[:])
+	footer.WriteString("PAR1")
+	_, err = w.Write(footer.Bytes())
+	return err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"currconv/pkg/rates"
+)
+
+// the currencies withPegs derives from their anchor, sorted
+var peggedCurrencies []string
+
+// returns p wrapped so the currencies pegged by the pegs variable are derived from their anchor, p itself if there are none
+// pegs lists the currency, how many of its units one unit of the anchor is worth and the anchor,
+// e.g. "AED=3.6725 USD,XOF=655.957 EUR", invalid entries are logged and skipped
+func withPegs(p rates.Provider) rates.Provider {
+	pegs := make(map[string]rates.Peg)
+	for _, entry := range strings.Split(getEnv("pegs", ""), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.Index(entry, "=")
+		fields := strings.Fields(entry[i+1:])
+		if i < 0 || len(fields) != 2 {
+			log.Println("pegs:", entry, "must be given as currency=rate anchor, e.g. AED=3.6725 USD")
+			continue
+		}
+		rate, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil || rate <= 0 {
+			log.Println("pegs:", entry, "needs a positive rate")
+			continue
+		}
+		currency, anchor := strings.ToUpper(strings.TrimSpace(entry[:i])), strings.ToUpper(fields[1])
+		if currency == anchor {
+			log.Println("pegs:", currency, "can't be pegged to itself")
+			continue
+		}
+		pegs[currency] = rates.Peg{Anchor: anchor, Rate: rate}
+	}
+	// anchors must be quoted by p, chains of pegs would depend on the order they are applied in
+	for currency, peg := range pegs {
+		if _, ok := pegs[peg.Anchor]; ok {
+			log.Println("pegs:", currency, "is pegged to", peg.Anchor, "which is pegged itself")
+			delete(pegs, currency)
+		}
+	}
+	if len(pegs) == 0 {
+		return p
+	}
+
+	var pegged []string
+	peggedCurrencies = nil
+	for currency, peg := range pegs {
+		pegged = append(pegged, currency+" to "+peg.Anchor)
+		peggedCurrencies = append(peggedCurrencies, currency)
+	}
+	sort.Strings(pegged)
+	sort.Strings(peggedCurrencies)
+	log.Println("Pegged", strings.Join(pegged, ", "))
+	return &rates.Pegged{Provider: p, Pegs: pegs}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"currconv/pkg/convert"
+)
+
+// characters permalink ids are made of
+const permalinkChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// errPermalinksFull is returned when a conversion is shared while max_permalinks are stored
+var errPermalinksFull = errors.New("no more conversions can be shared at the moment")
+
+// Permalink is a shared conversion
+type Permalink struct {
+	Conversion
+	// unix time the conversion was shared at
+	Created int64 `json:"created"`
+}
+
+// Permalinks stores shared conversions by their id
+type Permalinks struct {
+	mutex sync.Mutex
+	// file the permalinks are persisted to
+	path        string
+	Conversions map[string]Permalink
+	// most permalinks stored at once, so anonymous clients can't grow the file without limit
+	max int
+	// time after which a permalink expires, 0 keeps them forever
+	retention time.Duration
+}
+
+// SharedPage stores variables for /c/
+type SharedPage struct {
+	Conversion
+	Time    string
+	URL     string
+	Flashes []string
+}
+
+// reads the permalinks stored at path
+// returns no permalinks if the file does not exist yet
+// at most max_permalinks (100000) are stored, they expire after permalink_retention_days (365, 0 keeps them forever)
+func loadPermalinks(path string) *Permalinks {
+	p := &Permalinks{path: path, Conversions: make(map[string]Permalink), max: 100000, retention: 365 * 24 * time.Hour}
+	if max, err := strconv.Atoi(getEnv("max_permalinks", "100000")); err != nil || max <= 0 {
+		log.Println("max_permalinks must be a positive number")
+	} else {
+		p.max = max
+	}
+	if days, err := strconv.Atoi(getEnv("permalink_retention_days", "365")); err != nil || days < 0 {
+		log.Println("permalink_retention_days must be a number of days")
+	} else {
+		p.retention = time.Duration(days) * 24 * time.Hour
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p
+	}
+	if err != nil {
+		log.Println(err)
+		return p
+	}
+
+	err = json.Unmarshal(b, &p.Conversions)
+	if err != nil {
+		log.Println(err)
+	}
+	// permalinks stored without their creation time expire after the retention from now on
+	now := time.Now().Unix()
+	for id, link := range p.Conversions {
+		if link.Created == 0 {
+			link.Created = now
+			p.Conversions[id] = link
+		}
+	}
+	return p
+}
+
+// returns a random id of 5 characters
+func newPermalinkID() (string, error) {
+	id := make([]byte, 5)
+	for i := range id {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(permalinkChars))))
+		if err != nil {
+			return "", err
+		}
+		id[i] = permalinkChars[n.Int64()]
+	}
+	return string(id), nil
+}
+
+// checks whether c is older than the retention
+func (p *Permalinks) expired(c Permalink, now time.Time) bool {
+	return p.retention > 0 && now.Sub(time.Unix(c.Created, 0)) > p.retention
+}
+
+// stores c under a new unique id and writes the permalinks to disk
+// returns the id, errPermalinksFull if max permalinks are stored after deleting the expired ones
+func (p *Permalinks) add(c Conversion) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	for id, link := range p.Conversions {
+		if p.expired(link, now) {
+			delete(p.Conversions, id)
+		}
+	}
+	if len(p.Conversions) >= p.max {
+		return "", errPermalinksFull
+	}
+
+	id, err := newPermalinkID()
+	for _, exists := p.Conversions[id]; err == nil && exists; _, exists = p.Conversions[id] {
+		id, err = newPermalinkID()
+	}
+	if err != nil {
+		return "", err
+	}
+	p.Conversions[id] = Permalink{c, now.Unix()}
+
+	b, err := json.Marshal(p.Conversions)
+	if err != nil {
+		log.Println(err)
+		return id, nil
+	}
+	err = ioutil.WriteFile(p.path, b, 0644)
+	if err != nil {
+		log.Println(err)
+	}
+	return id, nil
+}
+
+// returns the conversion stored under id unless it expired
+func (p *Permalinks) get(id string) (Conversion, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	c, ok := p.Conversions[id]
+	if !ok || p.expired(c, time.Now()) {
+		return Conversion{}, false
+	}
+	return c.Conversion, true
+}
+
+// stores the conversion in the submitted form at the current rate or the rate of its date and redirects to its permalink
+func shareHandler(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	d, err := dataFor(r.Context(), r.Form.Get("date"))
+	if err != nil {
+		failRequest(w, r, err)
+		return
+	}
+
+	from := currencyCode(r.Form.Get("from"))
+	to := currencyCode(r.Form.Get("to"))
+	d = withRetiredRates(d, from, to)
+	value, err := parseConversion(d, from, to, r.Form.Get("value"))
+	if err != nil {
+		failRequest(w, r, err)
+		return
+	}
+
+	id, err := serverFrom(r.Context()).permalinks.add(newConversion(d, from, to, value, convert.Mid, markupFor(r), preferencesFor(r)))
+	if errors.Is(err, errPermalinksFull) {
+		requestError(w, r, http.StatusServiceUnavailable, "Sharing unavailable", "No more conversions can be shared at the moment, please try again later.")
+		return
+	}
+	if err != nil {
+		failRequest(w, r, err)
+		return
+	}
+	addFlash(w, r, "Your conversion was saved, share it with the permalink below.")
+	http.Redirect(w, r, "/c/"+id, 302)
+}
+
+// renders the conversion stored under the id in the url path with the rate that was used when it was shared
+func permalinkHandler(w http.ResponseWriter, r *http.Request) {
+	id := pathParam(r, "id")
+	c, ok := serverFrom(r.Context()).permalinks.get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	p := SharedPage{c, formatTimestamp(w, r, c.Timestamp), "/c/" + id, popFlashes(w, r)}
+	renderTemplate(w, r, "shared", &p)
+}
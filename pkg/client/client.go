@@ -0,0 +1,319 @@
+// Package client wraps the JSON API of a currency converter server.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client sends requests to the API of a currency converter server
+type Client struct {
+	BaseURL string
+	// sent in the X-API-Key header if not empty
+	APIKey     string
+	HTTPClient *http.Client
+	// number of times a request is retried after network errors, 429 and 5xx responses
+	MaxRetries int
+	// wait before the first retry, doubled for every further retry
+	RetryWait time.Duration
+}
+
+// Conversion stores the result of a currency conversion
+type Conversion struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float64 `json:"amount"`
+	// rate of the requested side, the mid-market rate by default
+	Rate float64 `json:"rate"`
+	// buy, sell or mid
+	Side string `json:"side"`
+	// final amount including the markup
+	Result float64 `json:"result"`
+	// amount at the rate before the markup
+	BaseResult float64        `json:"base_result"`
+	Markup     *AppliedMarkup `json:"markup,omitempty"`
+	Timestamp  int64          `json:"timestamp"`
+	// Timestamp in RFC 3339
+	Time string `json:"time"`
+	Date string `json:"date"`
+	// id of the quote whose locked rate was used
+	Quote string `json:"quote,omitempty"`
+	VAT   *VAT   `json:"vat,omitempty"`
+}
+
+// VAT splits the converted amount into net, tax and gross amounts in the target currency
+type VAT struct {
+	Percent float64 `json:"percent"`
+	// the converted amount
+	Net   float64 `json:"net"`
+	Tax   float64 `json:"tax"`
+	Gross float64 `json:"gross"`
+}
+
+// AppliedMarkup stores the markup the server charged on a conversion
+type AppliedMarkup struct {
+	Percent float64 `json:"percent"`
+	Fee     float64 `json:"fee"`
+	// difference between the final amount and the amount before the markup
+	Amount float64 `json:"amount"`
+	// multiple the result was rounded to by the server's conversion rules
+	Round float64 `json:"round,omitempty"`
+}
+
+// Quote locks the rate of a pair until ExpiresAt (unix time)
+type Quote struct {
+	ID        string  `json:"id"`
+	From      string  `json:"from"`
+	To        string  `json:"to"`
+	Rate      float64 `json:"rate"`
+	Side      string  `json:"side"`
+	Timestamp int64   `json:"timestamp"`
+	Time      string  `json:"time"`
+	Date      string  `json:"date"`
+	ExpiresAt int64   `json:"expires_at"`
+	// ExpiresAt in RFC 3339
+	Expires string `json:"expires"`
+}
+
+// UnitRate stores the rate of one unit of a pair in both directions
+type UnitRate struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// value of one from in to
+	Rate float64 `json:"rate"`
+	// value of one to in from
+	Inverse   float64 `json:"inverse"`
+	Timestamp int64   `json:"timestamp"`
+	Time      string  `json:"time"`
+	Date      string  `json:"date"`
+}
+
+// RateTable stores the rates of all currencies in the base currency
+type RateTable struct {
+	Base      string             `json:"base"`
+	Date      string             `json:"date"`
+	Timestamp int64              `json:"timestamp"`
+	Time      string             `json:"time"`
+	Rates     map[string]float64 `json:"rates"`
+}
+
+// TimeseriesPoint stores the rate of a pair on one day
+type TimeseriesPoint struct {
+	Date string  `json:"date"`
+	Rate float64 `json:"rate"`
+}
+
+// Timeseries stores the daily rates of a pair between two dates
+type Timeseries struct {
+	From  string            `json:"from"`
+	To    string            `json:"to"`
+	Start string            `json:"start"`
+	End   string            `json:"end"`
+	Rates []TimeseriesPoint `json:"rates"`
+}
+
+// Error is returned for API responses with an error status code
+type Error struct {
+	StatusCode int
+	Message    string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	return "client: " + strconv.Itoa(e.StatusCode) + " " + e.Message
+}
+
+// New returns a client for the server at baseURL, e.g. http://localhost:8080
+// apiKey may be empty for servers that allow anonymous access
+func New(baseURL string, apiKey string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		MaxRetries: 3,
+		RetryWait:  500 * time.Millisecond,
+	}
+}
+
+// Convert converts amount of from into to
+func (c *Client) Convert(ctx context.Context, from string, to string, amount float64) (*Conversion, error) {
+	var result Conversion
+	query := url.Values{"from": {from}, "to": {to}, "amount": {strconv.FormatFloat(amount, 'f', -1, 64)}}
+	err := c.get(ctx, "/api/convert", query, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ConvertAt converts amount of from into to with the rates of a past date (YYYY-MM-DD)
+func (c *Client) ConvertAt(ctx context.Context, from string, to string, amount float64, date string) (*Conversion, error) {
+	var result Conversion
+	query := url.Values{"from": {from}, "to": {to}, "amount": {strconv.FormatFloat(amount, 'f', -1, 64)}, "date": {date}}
+	err := c.get(ctx, "/api/convert", query, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ConvertWithVAT converts amount of from into to and adds the tax of vat percent on top of the result
+func (c *Client) ConvertWithVAT(ctx context.Context, from string, to string, amount float64, vat float64) (*Conversion, error) {
+	var result Conversion
+	query := url.Values{"from": {from}, "to": {to}, "amount": {strconv.FormatFloat(amount, 'f', -1, 64)}, "vat": {strconv.FormatFloat(vat, 'f', -1, 64)}}
+	err := c.get(ctx, "/api/convert", query, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ConvertSide converts amount of from into to at the buy or sell rate of the spread
+func (c *Client) ConvertSide(ctx context.Context, from string, to string, amount float64, side string) (*Conversion, error) {
+	var result Conversion
+	query := url.Values{"from": {from}, "to": {to}, "amount": {strconv.FormatFloat(amount, 'f', -1, 64)}, "side": {side}}
+	err := c.get(ctx, "/api/convert", query, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// CreateQuote locks the current rate of from in to for the quote window configured on the server
+func (c *Client) CreateQuote(ctx context.Context, from string, to string) (*Quote, error) {
+	var result Quote
+	err := c.request(ctx, http.MethodPost, "/api/quote", url.Values{"from": {from}, "to": {to}}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ConvertQuote converts amount using the locked rate of the quote with the given id
+func (c *Client) ConvertQuote(ctx context.Context, quoteID string, amount float64) (*Conversion, error) {
+	var result Conversion
+	query := url.Values{"quote": {quoteID}, "amount": {strconv.FormatFloat(amount, 'f', -1, 64)}}
+	err := c.get(ctx, "/api/convert", query, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Rate returns the current mid-market rate of from in to and its inverse
+func (c *Client) Rate(ctx context.Context, from string, to string) (*UnitRate, error) {
+	var result UnitRate
+	err := c.get(ctx, "/api/rate", url.Values{"from": {from}, "to": {to}}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Rates returns the current rate table
+func (c *Client) Rates(ctx context.Context) (*RateTable, error) {
+	var result RateTable
+	err := c.get(ctx, "/api/rates", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Historical returns the rate table of a past date (YYYY-MM-DD)
+func (c *Client) Historical(ctx context.Context, date string) (*RateTable, error) {
+	var result RateTable
+	err := c.get(ctx, "/api/historical", url.Values{"date": {date}}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Timeseries returns the daily rates of from in to between start and end (YYYY-MM-DD)
+// an empty start or end leaves that side of the range open
+func (c *Client) Timeseries(ctx context.Context, from string, to string, start string, end string) (*Timeseries, error) {
+	var result Timeseries
+	query := url.Values{"pair": {from + "/" + to}, "start": {start}, "end": {end}}
+	err := c.get(ctx, "/api/timeseries", query, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// checks whether a request that failed with the given status code should be tried again
+// status is 0 for network errors
+func retryable(status int) bool {
+	return status == 0 || status == http.StatusTooManyRequests || status >= 500
+}
+
+// sends a GET request to path and decodes the json response into v
+func (c *Client) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	return c.request(ctx, http.MethodGet, path, query, v)
+}
+
+// sends a request to path and decodes the json response into v
+// the query is sent as form body for POST requests
+// GET requests are retried after network errors, 429 and 5xx responses until MaxRetries is reached or ctx is done
+func (c *Client) request(ctx context.Context, method string, path string, query url.Values, v interface{}) error {
+	wait := c.RetryWait
+	for attempt := 0; ; attempt++ {
+		status, err := c.do(ctx, method, c.BaseURL+path, query, v)
+		if err == nil || method != http.MethodGet || !retryable(status) || attempt >= c.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// sends a single request to u and decodes the json response into v
+// returns the status code of the response
+func (c *Client) do(ctx context.Context, method string, u string, query url.Values, v interface{}) (int, error) {
+	var body io.Reader
+	if method == http.MethodPost {
+		body = strings.NewReader(query.Encode())
+	} else if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(apiErr)
+		if apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return resp.StatusCode, apiErr
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,34 @@
+// Package convert converts amounts between currencies using a rates.Data table.
+package convert
+
+import (
+	"math"
+
+	"currconv/pkg/rates"
+)
+
+// Rate returns how much one unit of curr1 is worth in curr2
+func Rate(d rates.Data, curr1 string, curr2 string) float64 {
+	return Convert(d, curr1, curr2, 1)
+}
+
+// Convert calculates how much "amount" of curr1 is worth in curr2
+func Convert(d rates.Data, curr1 string, curr2 string, amount float64) float64 {
+	baseAmount := amount / d.Rates[curr1]
+	return baseAmount * d.Rates[curr2]
+}
+
+// Available checks whether d contains rates for all given currencies
+func Available(d rates.Data, currencies ...string) bool {
+	for _, currency := range currencies {
+		if _, ok := d.Rates[currency]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// RoundTo2Decimals rounds float to 2 places after decimal point
+func RoundTo2Decimals(x float64) float64 {
+	return (math.Round(x*100) / 100)
+}
@@ -0,0 +1,37 @@
+package convert
+
+import (
+	"currconv/pkg/rates"
+)
+
+// CrossRates stores the precomputed rates between every pair of some currencies of one rate table
+type CrossRates struct {
+	// identify the rate table the rates were computed from
+	Timestamp int64
+	Base      string
+	rates     map[[2]string]float64
+}
+
+// NewCrossRates computes the rate of every pair of currencies in d, currencies missing in d are skipped
+func NewCrossRates(d rates.Data, currencies []string) *CrossRates {
+	c := &CrossRates{Timestamp: d.Timestamp, Base: d.Base, rates: make(map[[2]string]float64)}
+	for _, from := range currencies {
+		for _, to := range currencies {
+			if Available(d, from, to) {
+				c.rates[[2]string{from, to}] = Rate(d, from, to)
+			}
+		}
+	}
+	return c
+}
+
+// Rate returns how much one unit of from is worth in to according to d
+// the precomputed rate is used if c was computed from d and contains the pair
+func (c *CrossRates) Rate(d rates.Data, from string, to string) float64 {
+	if c != nil && c.Timestamp == d.Timestamp && c.Base == d.Base {
+		if rate, ok := c.rates[[2]string{from, to}]; ok {
+			return rate
+		}
+	}
+	return Rate(d, from, to)
+}
@@ -0,0 +1,19 @@
+package convert
+
+// Markup is charged on top of the mid-market rate of a conversion
+type Markup struct {
+	// percentage added to the converted amount
+	Percent float64
+	// fixed fee in the target currency
+	Fee float64
+}
+
+// IsZero checks whether m doesn't change any amount
+func (m Markup) IsZero() bool {
+	return m.Percent == 0 && m.Fee == 0
+}
+
+// Apply returns amount (already converted at the mid-market rate) with the markup added
+func (m Markup) Apply(amount float64) float64 {
+	return amount*(1+m.Percent/100) + m.Fee
+}
@@ -0,0 +1,56 @@
+package convert
+
+import (
+	"math"
+	"strconv"
+)
+
+// Rounding is how a result is rounded to its number of decimals
+type Rounding string
+
+const (
+	// HalfUp rounds halves away from zero, 2.345 to 2.35
+	HalfUp Rounding = "half-up"
+	// HalfEven rounds halves to the even neighbour, 2.345 to 2.34 and 2.355 to 2.36
+	HalfEven Rounding = "half-even"
+	// Up rounds away from zero
+	Up Rounding = "up"
+	// Down rounds towards zero
+	Down Rounding = "down"
+)
+
+// Roundings lists the supported rounding modes
+var Roundings = []Rounding{HalfUp, HalfEven, Up, Down}
+
+// ParseRounding returns the rounding mode named s and whether it exists
+func ParseRounding(s string) (Rounding, bool) {
+	for _, r := range Roundings {
+		if string(r) == s {
+			return r, true
+		}
+	}
+	return "", false
+}
+
+// Round rounds x to decimals places after the decimal point with mode, unknown modes round half up
+func Round(x float64, decimals int, mode Rounding) float64 {
+	scale := math.Pow(10, float64(decimals))
+	if mode == HalfUp || mode == "" {
+		// as RoundTo2Decimals
+		return math.Round(x*scale) / scale
+	}
+	// the other modes are cleaned of representation errors, 1.1*100 must not be rounded up to 111
+	scaled, _ := strconv.ParseFloat(strconv.FormatFloat(x*scale, 'g', 15, 64), 64)
+	switch mode {
+	case HalfEven:
+		return math.RoundToEven(scaled) / scale
+	case Up:
+		if x < 0 {
+			return math.Floor(scaled) / scale
+		}
+		return math.Ceil(scaled) / scale
+	case Down:
+		return math.Trunc(scaled) / scale
+	}
+	return math.Round(scaled) / scale
+}
@@ -0,0 +1,421 @@
+package convert
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Rules are fee and rounding rules written one per line, checked in order for every conversion:
+//
+//	# 2% for small amounts, 0.5% for large ones
+//	when amount < 100 then percent 2
+//	when amount >= 10000 then percent 0.5
+//	when to == "JPY" then round 100
+//	when from in ("USD", "CAD") and not converted < 50 then fee 1.5, percent 0.2
+//	percent 0.1
+//
+// a condition compares amount (in from), converted (amount in to before fees), from and to with
+// <, <=, >, >=, == and != or checks membership with in, combined with and, or, not and parentheses
+// a rule without when always applies
+// the actions of all matching rules add up, percent and fee like Markup, round rounds the result to a multiple of its value
+type Rules []Rule
+
+// Rule is one line of Rules
+type Rule struct {
+	// nil for rules that always apply
+	condition node
+	Percent   float64
+	Fee       float64
+	// 0 if the rule doesn't round
+	Round float64
+}
+
+// Conditions describes a conversion rules are checked against
+type Conditions struct {
+	From      string
+	To        string
+	Amount    float64
+	Converted float64
+}
+
+// Adjustment is the combined effect of the rules matching a conversion
+type Adjustment struct {
+	Markup
+	// multiple the result is rounded to, 0 if it isn't, the last matching rule wins
+	Round float64
+}
+
+// ParseRules reads rules from r, errors name the offending line
+func ParseRules(r io.Reader) (Rules, error) {
+	var rules Rules
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("rules: line %d: %w", n, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// Apply returns the combined actions of the rules whose condition c fulfills
+func (rs Rules) Apply(c Conditions) Adjustment {
+	var a Adjustment
+	for _, rule := range rs {
+		if rule.condition != nil && !rule.condition.eval(c).truth() {
+			continue
+		}
+		a.Percent += rule.Percent
+		a.Fee += rule.Fee
+		if rule.Round > 0 {
+			a.Round = rule.Round
+		}
+	}
+	return a
+}
+
+// RoundToMultiple rounds x to the nearest multiple of m, m <= 0 leaves x unchanged
+func RoundToMultiple(x float64, m float64) float64 {
+	if m <= 0 {
+		return x
+	}
+	return math.Round(x/m) * m
+}
+
+// value is a number or, if isString is set, a string
+type value struct {
+	number   float64
+	str      string
+	isString bool
+}
+
+// truth of a value resulting from a comparison, 1 is true
+func (v value) truth() bool {
+	return !v.isString && v.number != 0
+}
+
+func boolValue(b bool) value {
+	if b {
+		return value{number: 1}
+	}
+	return value{}
+}
+
+// node is part of a parsed condition
+type node interface {
+	eval(c Conditions) value
+}
+
+type literal value
+
+func (l literal) eval(c Conditions) value {
+	return value(l)
+}
+
+type variable string
+
+func (v variable) eval(c Conditions) value {
+	switch v {
+	case "amount":
+		return value{number: c.Amount}
+	case "converted":
+		return value{number: c.Converted}
+	case "from":
+		return value{str: c.From, isString: true}
+	}
+	return value{str: c.To, isString: true}
+}
+
+type comparison struct {
+	op          string
+	left, right node
+}
+
+func (n comparison) eval(c Conditions) value {
+	l, r := n.left.eval(c), n.right.eval(c)
+	if l.isString != r.isString {
+		return boolValue(false)
+	}
+	if l.isString {
+		switch n.op {
+		case "==":
+			return boolValue(l.str == r.str)
+		case "!=":
+			return boolValue(l.str != r.str)
+		}
+		return boolValue(false)
+	}
+	switch n.op {
+	case "<":
+		return boolValue(l.number < r.number)
+	case "<=":
+		return boolValue(l.number <= r.number)
+	case ">":
+		return boolValue(l.number > r.number)
+	case ">=":
+		return boolValue(l.number >= r.number)
+	case "==":
+		return boolValue(l.number == r.number)
+	}
+	return boolValue(l.number != r.number)
+}
+
+type membership struct {
+	left node
+	list []node
+}
+
+func (n membership) eval(c Conditions) value {
+	l := n.left.eval(c)
+	for _, item := range n.list {
+		if v := item.eval(c); v == l {
+			return boolValue(true)
+		}
+	}
+	return boolValue(false)
+}
+
+type logical struct {
+	op          string
+	left, right node
+}
+
+func (n logical) eval(c Conditions) value {
+	if n.op == "and" {
+		return boolValue(n.left.eval(c).truth() && n.right.eval(c).truth())
+	}
+	return boolValue(n.left.eval(c).truth() || n.right.eval(c).truth())
+}
+
+type negation struct {
+	operand node
+}
+
+func (n negation) eval(c Conditions) value {
+	return boolValue(!n.operand.eval(c).truth())
+}
+
+// splits s into numbers, "strings", words, operators, parentheses and commas
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		ch := rune(s[i])
+		switch {
+		case unicode.IsSpace(ch):
+			i++
+		case ch == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, s[i:i+end+2])
+			i += end + 2
+		case strings.ContainsRune("(),", ch):
+			tokens = append(tokens, string(ch))
+			i++
+		case strings.ContainsRune("<>=!", ch):
+			j := i + 1
+			if j < len(s) && s[j] == '=' {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		case unicode.IsLetter(ch) || unicode.IsDigit(ch) || ch == '.' || ch == '-':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '.' || s[j] == '-' || s[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q", ch)
+		}
+	}
+	return tokens, nil
+}
+
+// parser reads a condition from tokens by recursive descent
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) expect(token string) error {
+	if t := p.next(); t != token {
+		return fmt.Errorf("expected %s, got %s", token, describe(t))
+	}
+	return nil
+}
+
+// returns token quoted for error messages, the end of the rule if it is empty
+func describe(token string) string {
+	if token == "" {
+		return "the end of the rule"
+	}
+	return strconv.Quote(token)
+}
+
+// parses operands joined by or, which binds weaker than and
+func (p *parser) or() (node, error) {
+	left, err := p.and()
+	for err == nil && p.peek() == "or" {
+		p.next()
+		var right node
+		right, err = p.and()
+		left = logical{"or", left, right}
+	}
+	return left, err
+}
+
+func (p *parser) and() (node, error) {
+	left, err := p.unary()
+	for err == nil && p.peek() == "and" {
+		p.next()
+		var right node
+		right, err = p.unary()
+		left = logical{"and", left, right}
+	}
+	return left, err
+}
+
+func (p *parser) unary() (node, error) {
+	switch p.peek() {
+	case "not":
+		p.next()
+		operand, err := p.unary()
+		return negation{operand}, err
+	case "(":
+		p.next()
+		n, err := p.or()
+		if err == nil {
+			err = p.expect(")")
+		}
+		return n, err
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (node, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.next()
+	switch op {
+	case "<", "<=", ">", ">=", "==", "!=":
+		right, err := p.operand()
+		return comparison{op, left, right}, err
+	case "in":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		var list []node
+		for {
+			item, err := p.operand()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+		return membership{left, list}, p.expect(")")
+	}
+	return nil, fmt.Errorf("expected a comparison, got %s", describe(op))
+}
+
+func (p *parser) operand() (node, error) {
+	t := p.next()
+	switch t {
+	case "amount", "converted", "from", "to":
+		return variable(t), nil
+	}
+	if strings.HasPrefix(t, `"`) {
+		return literal{str: strings.ToUpper(strings.Trim(t, `"`)), isString: true}, nil
+	}
+	n, err := strconv.ParseFloat(t, 64)
+	if err != nil {
+		return nil, fmt.Errorf("expected a number, string or amount, converted, from or to, got %s", describe(t))
+	}
+	return literal{number: n}, nil
+}
+
+// parses "[when condition then] action, ..."
+func parseRule(line string) (Rule, error) {
+	tokens, err := tokenize(line)
+	if err != nil {
+		return Rule{}, err
+	}
+	p := &parser{tokens: tokens}
+
+	var rule Rule
+	if p.peek() == "when" {
+		p.next()
+		rule.condition, err = p.or()
+		if err != nil {
+			return Rule{}, err
+		}
+		if err := p.expect("then"); err != nil {
+			return Rule{}, err
+		}
+	}
+
+	for {
+		action := p.next()
+		switch action {
+		case "percent", "fee", "round":
+		default:
+			return Rule{}, fmt.Errorf("expected percent, fee or round, got %s", describe(action))
+		}
+		number := p.next()
+		n, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			return Rule{}, fmt.Errorf("%s must be followed by a number, got %s", action, describe(number))
+		}
+		switch action {
+		case "percent":
+			rule.Percent += n
+		case "fee":
+			rule.Fee += n
+		case "round":
+			if n <= 0 {
+				return Rule{}, errors.New("round must be positive")
+			}
+			rule.Round = n
+		}
+		if p.peek() != "," {
+			break
+		}
+		p.next()
+	}
+	if p.pos < len(p.tokens) {
+		return Rule{}, fmt.Errorf("unexpected %s", describe(p.peek()))
+	}
+	return rule, nil
+}
@@ -0,0 +1,132 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(`
+# comments and empty lines are skipped
+
+when amount < 100 then percent 2
+when to == "jpy" then round 100
+fee 1.5, percent 0.2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("got %d rules, want 3", len(rules))
+	}
+	if rules[2].condition != nil || rules[2].Fee != 1.5 || rules[2].Percent != 0.2 {
+		t.Errorf("rule without when = %+v, want fee 1.5 and percent 0.2 always", rules[2])
+	}
+}
+
+func TestRulesApply(t *testing.T) {
+	tests := []struct {
+		rule string
+		c    Conditions
+		want bool
+	}{
+		{`when amount < 100 then fee 1`, Conditions{Amount: 99}, true},
+		{`when amount < 100 then fee 1`, Conditions{Amount: 100}, false},
+		{`when amount <= 100 then fee 1`, Conditions{Amount: 100}, true},
+		{`when converted >= 50 then fee 1`, Conditions{Converted: 50}, true},
+		{`when amount != 1 then fee 1`, Conditions{Amount: 1}, false},
+		// string literals are compared in upper case like currency codes
+		{`when to == "jpy" then fee 1`, Conditions{To: "JPY"}, true},
+		{`when from != "USD" then fee 1`, Conditions{From: "USD"}, false},
+		{`when from in ("USD", "CAD") then fee 1`, Conditions{From: "CAD"}, true},
+		{`when from in ("USD", "CAD") then fee 1`, Conditions{From: "EUR"}, false},
+		// strings and numbers are never equal
+		{`when from == 1 then fee 1`, Conditions{From: "1"}, false},
+		// and binds stronger than or
+		{`when amount < 10 or amount > 20 and from == "EUR" then fee 1`, Conditions{Amount: 5, From: "USD"}, true},
+		{`when amount < 10 or amount > 20 and from == "EUR" then fee 1`, Conditions{Amount: 25, From: "USD"}, false},
+		{`when (amount < 10 or amount > 20) and from == "EUR" then fee 1`, Conditions{Amount: 5, From: "USD"}, false},
+		// not binds stronger than and
+		{`when not amount < 10 and from == "EUR" then fee 1`, Conditions{Amount: 20, From: "EUR"}, true},
+		{`when not (amount < 10 and from == "EUR") then fee 1`, Conditions{Amount: 5, From: "EUR"}, false},
+		{`when not not amount < 10 then fee 1`, Conditions{Amount: 5}, true},
+		{`fee 1`, Conditions{}, true},
+	}
+	for _, test := range tests {
+		rules, err := ParseRules(strings.NewReader(test.rule))
+		if err != nil {
+			t.Errorf("%s: %v", test.rule, err)
+			continue
+		}
+		got := rules.Apply(test.c).Fee == 1
+		if got != test.want {
+			t.Errorf("%s with %+v applies = %v, want %v", test.rule, test.c, got, test.want)
+		}
+	}
+}
+
+func TestRulesApplyAddsUp(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(`
+percent 0.1
+when amount < 100 then percent 2, fee 1
+when to == "JPY" then round 100
+when to == "JPY" and amount < 100 then round 10
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := rules.Apply(Conditions{From: "EUR", To: "JPY", Amount: 50})
+	want := Adjustment{Markup{Percent: 2.1, Fee: 1}, 10}
+	if got != want {
+		t.Errorf("Apply = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{`when amount < 100 then`, "line 1: expected percent, fee or round, got the end of the rule"},
+		{`when amount < 100 then percent`, "line 1: percent must be followed by a number, got the end of the rule"},
+		{`when amount < 100 then percent two`, `line 1: percent must be followed by a number, got "two"`},
+		{`when amount < 100 then discount 2`, `line 1: expected percent, fee or round, got "discount"`},
+		{`when amount < 100 percent 2`, `line 1: expected then, got "percent"`},
+		{`when amount then fee 1`, `line 1: expected a comparison, got "then"`},
+		{`when amount < then fee 1`, `line 1: expected a number, string or amount, converted, from or to, got "then"`},
+		{`when (amount < 100 then fee 1`, `line 1: expected ), got "then"`},
+		{`when from in ("USD" then fee 1`, `line 1: expected ), got "then"`},
+		{`when to == "JPY then round 100`, "line 1: unterminated string"},
+		{`when amount < 100 then fee 1;`, `line 1: unexpected character ';'`},
+		{`round 0`, "line 1: round must be positive"},
+		{`fee 1 2`, `line 1: unexpected "2"`},
+		{"fee 1\n\nfee x", `line 3: fee must be followed by a number, got "x"`},
+	}
+	for _, test := range tests {
+		_, err := ParseRules(strings.NewReader(test.rule))
+		if err == nil {
+			t.Errorf("%s: no error, want %q", test.rule, test.want)
+			continue
+		}
+		if got := strings.TrimPrefix(err.Error(), "rules: "); got != test.want {
+			t.Errorf("%s: error %q, want %q", test.rule, got, test.want)
+		}
+	}
+}
+
+func TestRoundToMultiple(t *testing.T) {
+	tests := []struct {
+		x, m, want float64
+	}{
+		{1234, 100, 1200},
+		{1250, 100, 1300},
+		{1.234, 0.05, 1.25},
+		{1.234, 0, 1.234},
+		{1.234, -1, 1.234},
+	}
+	for _, test := range tests {
+		if got := RoundToMultiple(test.x, test.m); got != test.want {
+			t.Errorf("RoundToMultiple(%v, %v) = %v, want %v", test.x, test.m, got, test.want)
+		}
+	}
+}
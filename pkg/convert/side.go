@@ -0,0 +1,70 @@
+package convert
+
+import (
+	"currconv/pkg/rates"
+)
+
+// Side selects which rate of the spread a conversion uses
+// for the pair FROM/TO the customer buys FROM at the ask rate and sells FROM at the bid rate
+type Side string
+
+const (
+	Mid  Side = "mid"
+	Buy  Side = "buy"
+	Sell Side = "sell"
+)
+
+// ParseSide returns the side named s, Mid if s is empty
+func ParseSide(s string) (Side, bool) {
+	switch Side(s) {
+	case "", Mid:
+		return Mid, true
+	case Buy, Sell:
+		return Side(s), true
+	}
+	return "", false
+}
+
+// Spread is applied around the mid rate of currencies the provider doesn't supply bid and ask rates for
+type Spread struct {
+	// total width of the spread in percent of the mid rate
+	Percent float64
+	// replaces Percent for individual currencies
+	Currencies map[string]float64
+}
+
+// returns the lowest and highest value of currency in base currency
+func (s Spread) bidAsk(d rates.Data, currency string) (float64, float64) {
+	bid, okBid := d.Bid[currency]
+	ask, okAsk := d.Ask[currency]
+	if okBid && okAsk {
+		return bid, ask
+	}
+
+	mid := d.Rates[currency]
+	if currency == d.Base {
+		// the spread of a pair with the base currency is covered by the other currency
+		return mid, mid
+	}
+
+	percent, ok := s.Currencies[currency]
+	if !ok {
+		percent = s.Percent
+	}
+	return mid * (1 - percent/200), mid * (1 + percent/200)
+}
+
+// SideRate returns how much one unit of from costs (Buy) or yields (Sell) in to
+// Mid returns the mid-market rate
+func SideRate(d rates.Data, from string, to string, side Side, s Spread) float64 {
+	fromBid, fromAsk := s.bidAsk(d, from)
+	toBid, toAsk := s.bidAsk(d, to)
+
+	switch side {
+	case Buy:
+		return toAsk / fromBid
+	case Sell:
+		return toBid / fromAsk
+	}
+	return Rate(d, from, to)
+}
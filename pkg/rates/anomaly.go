@@ -0,0 +1,120 @@
+package rates
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Anomaly is a rate that moved implausibly far since the previous refresh, most likely a glitch of the provider
+type Anomaly struct {
+	Currency string
+	Previous float64
+	Rate     float64
+	// change in percent
+	Change float64
+	// time between the last accepted and the new rate
+	Elapsed time.Duration
+	// whether the previous rate was kept instead of the new one
+	Rejected bool
+}
+
+// Guarded is a Provider that compares the latest rates of Provider with the ones it returned before
+// and reports rates that changed by more than MaxChange percent per Window
+// the first rates fetched are taken as they are
+type Guarded struct {
+	Provider  Provider
+	MaxChange float64
+	// changes over more than Window may be proportionally larger, e.g. twice MaxChange over twice Window
+	Window time.Duration
+	// Reject keeps the previous rate of currencies that jumped, until enough time has passed to allow the change
+	Reject bool
+	// OnAnomaly is called with the anomalies of a refresh, if there are any
+	OnAnomaly func([]Anomaly)
+
+	// guards previous and accepted
+	mutex    sync.Mutex
+	previous Data
+	// time of the last accepted rate of the currencies whose new rates are rejected, changes are measured from it
+	// so they are allowed once enough time has passed
+	accepted map[string]time.Time
+}
+
+// Latest returns the most recent rates of Provider, with the implausible ones replaced by the previous rates if Reject is set
+func (g *Guarded) Latest(ctx context.Context) (Data, error) {
+	d, err := g.Provider.Latest(ctx)
+	if err != nil {
+		return d, err
+	}
+
+	g.mutex.Lock()
+	d, anomalies := g.check(g.previous, d)
+	g.previous = d
+	g.mutex.Unlock()
+
+	if len(anomalies) > 0 && g.OnAnomaly != nil {
+		g.OnAnomaly(anomalies)
+	}
+	return d, nil
+}
+
+// Historical returns the rates of a past date (YYYY-MM-DD) of Provider unchecked, they were settled long ago
+func (g *Guarded) Historical(ctx context.Context, date string) (Data, error) {
+	return g.Provider.Historical(ctx, date)
+}
+
+// compares the rates of d with those of previous and returns d, with the previous rates kept for the anomalies if Reject is set
+// the mutex must be held by the caller
+func (g *Guarded) check(previous Data, d Data) (Data, []Anomaly) {
+	if previous.Timestamp == 0 || previous.Base != d.Base || !d.Time().After(previous.Time()) {
+		return d, nil
+	}
+
+	var anomalies []Anomaly
+	for currency, rate := range d.Rates {
+		old, ok := previous.Rates[currency]
+		if !ok || old == 0 {
+			continue
+		}
+		// a rate kept because it was rejected before is as old as when it was accepted
+		since, rejected := g.accepted[currency]
+		if !rejected {
+			since = previous.Time()
+		}
+		elapsed := d.Time().Sub(since)
+		allowed := g.MaxChange * math.Max(1, float64(elapsed)/float64(g.Window))
+		change := (rate/old - 1) * 100
+		if math.Abs(change) <= allowed {
+			delete(g.accepted, currency)
+			continue
+		}
+		anomalies = append(anomalies, Anomaly{currency, old, rate, change, elapsed, g.Reject})
+		if g.Reject && !rejected {
+			if g.accepted == nil {
+				g.accepted = make(map[string]time.Time)
+			}
+			g.accepted[currency] = since
+		}
+	}
+	sort.Slice(anomalies, func(i, j int) bool { return anomalies[i].Currency < anomalies[j].Currency })
+	if !g.Reject || len(anomalies) == 0 {
+		return d, anomalies
+	}
+
+	d.Rates = copyRates(d.Rates)
+	d.Bid, d.Ask = copyRates(d.Bid), copyRates(d.Ask)
+	for _, a := range anomalies {
+		d.Rates[a.Currency] = a.Previous
+		bid, okBid := previous.Bid[a.Currency]
+		ask, okAsk := previous.Ask[a.Currency]
+		if okBid && okAsk && d.Bid != nil && d.Ask != nil {
+			d.Bid[a.Currency], d.Ask[a.Currency] = bid, ask
+		} else {
+			delete(d.Bid, a.Currency)
+			delete(d.Ask, a.Currency)
+		}
+	}
+	return d, anomalies
+}
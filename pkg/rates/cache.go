@@ -0,0 +1,340 @@
+package rates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Cache stores the latest data of a provider and refreshes it once it is older than MaxAge
+// to limit the number of requests made to the provider
+type Cache struct {
+	// guards data
+	mutex sync.Mutex
+	// makes sure only one refresh runs at a time
+	refreshMutex sync.Mutex
+	// the refresh started by Get that the other calls of Get wait for, nil if none is running, guarded by mutex
+	refreshing *cacheRefresh
+	data       Data
+	// when the provider last reported that data hasn't changed
+	unchanged time.Time
+	// time until which the provider asked not to be sent requests
+	cooldown time.Time
+	// refreshes that failed in a row, the error of the last one and the time until which Get doesn't retry
+	failures int
+	lastErr  error
+	retryAt  time.Time
+	provider Provider
+	MaxAge   time.Duration
+	// time after which data that was reported unchanged is requested again, even though it is older than MaxAge
+	RecheckAfter time.Duration
+	// longest time a refresh started by Get may take, it doesn't end when the request that started it is canceled
+	RefreshTimeout time.Duration
+	// OnRefresh is called with the new data after every successful refresh
+	OnRefresh func(Data)
+	// Fallback is asked for data if the provider is unavailable, its data is only used if it is newer than the cached data
+	Fallback Provider
+	// OnLookup is called after every Get with its result, CacheHit, CacheMiss or CacheStale, e.g. to forward it to another metrics system
+	OnLookup func(result string)
+	// OnRefreshTimed is called after every refresh with its duration and error
+	OnRefreshTimed func(duration time.Duration, err error)
+
+	// guards stats
+	statsMutex sync.Mutex
+	stats      CacheStats
+}
+
+// cacheRefresh is a refresh started by Get and shared by all calls of Get waiting for it
+type cacheRefresh struct {
+	// closed once d, err and hit are set
+	done chan struct{}
+	d    Data
+	err  error
+	// whether another refresh made the data fresh before this one started
+	hit bool
+}
+
+// time Get waits before retrying after a failed refresh, doubled after every further failure up to maxRetryBackoff
+const (
+	minRetryBackoff = 5 * time.Second
+	maxRetryBackoff = 10 * time.Minute
+)
+
+// results of Get
+const (
+	// the cached data was fresh
+	CacheHit = "hit"
+	// the data was refreshed before it was returned
+	CacheMiss = "miss"
+	// the data couldn't be refreshed and the outdated data was returned
+	CacheStale = "stale"
+)
+
+// CacheStats counts the lookups and refreshes of a Cache
+type CacheStats struct {
+	// results of Get by CacheHit, CacheMiss and CacheStale
+	Lookups map[string]uint64
+	// durations of the refreshes, including those of Refresh
+	Refreshes       Histogram
+	RefreshFailures uint64
+}
+
+// HitRatio returns the share of the lookups answered from the cache without refreshing, 0 if there were none
+func (s CacheStats) HitRatio() float64 {
+	total := s.Lookups[CacheHit] + s.Lookups[CacheMiss] + s.Lookups[CacheStale]
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Lookups[CacheHit]) / float64(total)
+}
+
+// Status describes the cached data and whether it can be refreshed
+type Status struct {
+	// timestamp of the cached data, 0 if there is none yet
+	Timestamp int64
+	// whether the data needs to be refreshed
+	Stale bool
+	// whether the data was read from a static file
+	Static bool
+	// base currency and number of currencies of the cached data
+	Base       string
+	Currencies int
+	// time until which refreshes are suspended because the provider rate limited them, zero if they aren't
+	Cooldown time.Time
+	// time until which Get doesn't retry after refreshes failed, zero if the last one didn't
+	RetryAt time.Time
+}
+
+// NewCache returns an empty cache for provider that refreshes data older than maxAge
+func NewCache(provider Provider, maxAge time.Duration) *Cache {
+	return &Cache{provider: provider, MaxAge: maxAge, RecheckAfter: maxAge / 4, RefreshTimeout: 30 * time.Second}
+}
+
+// Get returns the cached data, refreshing it first if it is older than MaxAge
+// after a refresh failed the provider isn't asked again until a backoff doubling with every failure has passed,
+// the error of the last refresh is returned until then
+// concurrent calls wait for the same refresh, which runs until it is done or RefreshTimeout passes
+// even if the call that started it is canceled
+// if refreshing fails or ctx is canceled the outdated data is returned together with the error,
+// which wraps ErrStaleData unless there is no data yet
+func (c *Cache) Get(ctx context.Context) (Data, error) {
+	if d := c.cached(); c.fresh(d) {
+		c.lookup(CacheHit)
+		return d, nil
+	}
+
+	c.mutex.Lock()
+	r := c.refreshing
+	if r == nil {
+		r = &cacheRefresh{done: make(chan struct{})}
+		c.refreshing = r
+		go c.shareRefresh(context.WithoutCancel(ctx), r)
+	}
+	c.mutex.Unlock()
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		c.lookup(CacheStale)
+		d := c.cached()
+		if d.Timestamp == 0 {
+			return d, ctx.Err()
+		}
+		return d, fmt.Errorf("%w: %w", ErrStaleData, ctx.Err())
+	}
+	switch {
+	case r.hit:
+		c.lookup(CacheHit)
+	case errors.Is(r.err, ErrStaleData):
+		c.lookup(CacheStale)
+	default:
+		c.lookup(CacheMiss)
+	}
+	return r.d, r.err
+}
+
+// runs the refresh r for Get with ctx, which must not be canceled by the call of Get that started it
+func (c *Cache) shareRefresh(ctx context.Context, r *cacheRefresh) {
+	c.refreshMutex.Lock()
+	// Refresh may have refreshed the data while waiting for the lock
+	if d := c.cached(); c.fresh(d) {
+		r.d, r.hit = d, true
+	} else {
+		refreshCtx, cancel := context.WithTimeout(ctx, c.RefreshTimeout)
+		r.d, r.err = c.refresh(refreshCtx, false)
+		cancel()
+	}
+	c.refreshMutex.Unlock()
+
+	c.mutex.Lock()
+	c.refreshing = nil
+	c.mutex.Unlock()
+	close(r.done)
+}
+
+// counts a lookup with result and passes it to OnLookup
+func (c *Cache) lookup(result string) {
+	c.statsMutex.Lock()
+	if c.stats.Lookups == nil {
+		c.stats.Lookups = make(map[string]uint64)
+	}
+	c.stats.Lookups[result]++
+	c.statsMutex.Unlock()
+	if c.OnLookup != nil {
+		c.OnLookup(result)
+	}
+}
+
+// Stats returns the lookups and refreshes counted so far
+func (c *Cache) Stats() CacheStats {
+	c.statsMutex.Lock()
+	defer c.statsMutex.Unlock()
+	s := c.stats
+	s.Lookups = make(map[string]uint64, len(c.stats.Lookups))
+	for result, n := range c.stats.Lookups {
+		s.Lookups[result] = n
+	}
+	s.Refreshes.Buckets = append([]uint64(nil), c.stats.Refreshes.Buckets...)
+	return s
+}
+
+// Refresh fetches new data from the provider regardless of the age of the cached data and the backoff after
+// failed refreshes
+// the cached data is kept and returned together with the error if fetching fails, as with Get
+func (c *Cache) Refresh(ctx context.Context) (Data, error) {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+
+	return c.refresh(ctx, true)
+}
+
+// Status returns the state of the cache without refreshing the data
+func (c *Cache) Status() Status {
+	d := c.cached()
+	c.mutex.Lock()
+	cooldown, retryAt := c.cooldown, c.retryAt
+	c.mutex.Unlock()
+	if time.Now().After(cooldown) {
+		cooldown = time.Time{}
+	}
+	if time.Now().After(retryAt) {
+		retryAt = time.Time{}
+	}
+	return Status{d.Timestamp, !c.fresh(d), d.Static, d.Base, len(d.Rates), cooldown, retryAt}
+}
+
+// returns the cached data without refreshing it
+func (c *Cache) cached() Data {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.data
+}
+
+// checks whether d is younger than MaxAge or was reported unchanged less than RecheckAfter ago
+func (c *Cache) fresh(d Data) bool {
+	c.mutex.Lock()
+	unchanged := c.unchanged
+	c.mutex.Unlock()
+
+	return time.Since(d.Time()) <= c.MaxAge || time.Since(unchanged) <= c.RecheckAfter
+}
+
+// counts a failed request to the provider and sets the time of the next retry, a successful one (err is nil) resets them
+// rate limits are handled by the cooldown instead
+func (c *Cache) backOff(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var limited *RateLimitError
+	if err == nil || errors.As(err, &limited) {
+		c.failures, c.lastErr, c.retryAt = 0, nil, time.Time{}
+		return
+	}
+	backoff := maxRetryBackoff
+	if c.failures < 16 && minRetryBackoff<<c.failures < maxRetryBackoff {
+		backoff = minRetryBackoff << c.failures
+	}
+	c.failures++
+	c.lastErr = err
+	c.retryAt = time.Now().Add(backoff)
+}
+
+// fetches new data and counts the refresh, refreshMutex has to be held by the caller
+// force ignores the backoff after failed refreshes
+func (c *Cache) refresh(ctx context.Context, force bool) (Data, error) {
+	start := time.Now()
+	d, err := c.fetch(ctx, force)
+	duration := time.Since(start)
+
+	c.statsMutex.Lock()
+	c.stats.Refreshes.Observe(duration.Seconds())
+	if err != nil {
+		c.stats.RefreshFailures++
+	}
+	c.statsMutex.Unlock()
+	if c.OnRefreshTimed != nil {
+		c.OnRefreshTimed(duration, err)
+	}
+	return d, err
+}
+
+// fetches new data from the provider or the fallback, refreshMutex has to be held by the caller
+// the provider isn't asked while it rate limits requests, a RateLimitError is returned instead,
+// nor during the backoff after failed refreshes unless force is set, the error of the last one is returned instead
+func (c *Cache) fetch(ctx context.Context, force bool) (Data, error) {
+	c.mutex.Lock()
+	cooldown, retryAt, lastErr := c.cooldown, c.retryAt, c.lastErr
+	c.mutex.Unlock()
+
+	var d Data
+	var err error
+	switch {
+	case time.Now().Before(cooldown):
+		err = &RateLimitError{cooldown}
+	case !force && time.Now().Before(retryAt):
+		err = lastErr
+	default:
+		d, err = c.provider.Latest(ctx)
+		c.backOff(err)
+	}
+	var limited *RateLimitError
+	if errors.As(err, &limited) {
+		c.mutex.Lock()
+		c.cooldown = limited.RetryAt
+		c.mutex.Unlock()
+	}
+
+	cached := c.cached()
+	if errors.Is(err, ErrProviderUnavailable) && c.Fallback != nil {
+		fallback, fallbackErr := c.Fallback.Latest(ctx)
+		if fallbackErr == nil && fallback.Timestamp >= cached.Timestamp {
+			d, err = fallback, nil
+		}
+	}
+	if err == nil && d.Timestamp == cached.Timestamp && d.Base == cached.Base {
+		// the rates haven't been updated, keep the cached data without notifying OnRefresh
+		c.mutex.Lock()
+		c.unchanged = time.Now()
+		c.mutex.Unlock()
+		return cached, nil
+	}
+	if err != nil && cached.Timestamp != 0 {
+		return cached, fmt.Errorf("%w: %w", ErrStaleData, err)
+	}
+	if err != nil {
+		return cached, err
+	}
+
+	c.mutex.Lock()
+	c.data = d
+	c.unchanged = time.Time{}
+	c.mutex.Unlock()
+
+	if c.OnRefresh != nil {
+		c.OnRefresh(d)
+	}
+	return d, nil
+}
@@ -0,0 +1,51 @@
+package rates
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Chaos is a Provider that delays and fails requests to Provider on purpose,
+// to check how the server behaves when the real provider is slow or down
+type Chaos struct {
+	Provider Provider
+	// every request is delayed by a random duration up to Latency
+	Latency time.Duration
+	// share of requests that fail with ErrProviderUnavailable, between 0 and 1
+	FailureRate float64
+}
+
+// Latest returns the most recent rates of Provider after the injected delay, unless the request is made to fail
+func (c *Chaos) Latest(ctx context.Context) (Data, error) {
+	if err := c.inject(ctx); err != nil {
+		return Data{}, err
+	}
+	return c.Provider.Latest(ctx)
+}
+
+// Historical returns the rates of a past date (YYYY-MM-DD) of Provider after the injected delay, unless the request is made to fail
+func (c *Chaos) Historical(ctx context.Context, date string) (Data, error) {
+	if err := c.inject(ctx); err != nil {
+		return Data{}, err
+	}
+	return c.Provider.Historical(ctx, date)
+}
+
+// waits for the random delay and returns an error if the request is made to fail or ctx is done first
+func (c *Chaos) inject(ctx context.Context) error {
+	if c.Latency > 0 {
+		t := time.NewTimer(time.Duration(rand.Int63n(int64(c.Latency) + 1)))
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ErrProviderUnavailable, ctx.Err())
+		}
+	}
+	if rand.Float64() < c.FailureRate {
+		return fmt.Errorf("%w: injected failure", ErrProviderUnavailable)
+	}
+	return nil
+}
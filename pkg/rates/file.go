@@ -0,0 +1,107 @@
+package rates
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// File is a Provider reading the rates from a local file, as fallback when the API is down or as only source
+// json files use the format of the fixer API, csv files have a currency and a rate column and may start with a header
+// the returned data is marked Static and, unless the file says otherwise, dated with the file's modification time
+type File struct {
+	Path string
+	// base currency of csv files, defaults to EUR
+	Base string
+}
+
+// NewFile returns a Provider reading the rates from the json or csv file at path
+func NewFile(path string) *File {
+	return &File{Path: path, Base: "EUR"}
+}
+
+// Latest reads the rates of the file
+func (f *File) Latest(ctx context.Context) (Data, error) {
+	return f.read()
+}
+
+// Historical reads the rates of the file if they are of date (YYYY-MM-DD)
+func (f *File) Historical(ctx context.Context, date string) (Data, error) {
+	d, err := f.read()
+	if err == nil && d.Date != date {
+		return Data{}, fmt.Errorf("%w: %s has no rates of %s", ErrProviderUnavailable, f.Path, date)
+	}
+	return d, err
+}
+
+// reads and decodes the file, errors wrap ErrProviderUnavailable and, if the file can't be decoded, ErrInvalidResponse
+func (f *File) read() (Data, error) {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return Data{}, fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+	}
+	b, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return Data{}, fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+	}
+
+	var d Data
+	if strings.EqualFold(filepath.Ext(f.Path), ".csv") {
+		d, err = f.decodeCSV(string(b))
+	} else {
+		err = json.Unmarshal(b, &d)
+	}
+	if err == nil && len(d.Rates) == 0 {
+		err = errors.New("no rates")
+	}
+	if err != nil {
+		return Data{}, fmt.Errorf("%w: %w: %s: %w", ErrProviderUnavailable, ErrInvalidResponse, f.Path, err)
+	}
+
+	d.Success = true
+	d.Static = true
+	if d.Timestamp == 0 {
+		d.Timestamp = info.ModTime().Unix()
+	}
+	if d.Date == "" {
+		d.Date = d.Time().UTC().Format("2006-01-02")
+	}
+	if d.Base == "" {
+		d.Base = f.Base
+	}
+	if _, ok := d.Rates[d.Base]; !ok {
+		d.Rates[d.Base] = 1
+	}
+	return d, nil
+}
+
+// decodes rows of currency and rate, a first row whose rate isn't a number is skipped as header
+func (f *File) decodeCSV(s string) (Data, error) {
+	records, err := csv.NewReader(strings.NewReader(s)).ReadAll()
+	if err != nil {
+		return Data{}, err
+	}
+
+	d := Data{Rates: make(map[string]float64)}
+	for i, record := range records {
+		if len(record) < 2 {
+			return Data{}, fmt.Errorf("row %d must have a currency and a rate", i+1)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil && i == 0 {
+			continue
+		}
+		if err != nil || rate <= 0 {
+			return Data{}, fmt.Errorf("row %d must have a positive rate", i+1)
+		}
+		d.Rates[strings.ToUpper(strings.TrimSpace(record[0]))] = rate
+	}
+	return d, nil
+}
@@ -0,0 +1,166 @@
+package rates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// time to wait before the next request if a 429 response has no valid Retry-After header
+const defaultRetryAfter = time.Minute
+
+// Fixer is a Provider using the fixer.io API
+type Fixer struct {
+	APIKey string
+	// defaults to http://data.fixer.io/api/
+	BaseURL string
+	// base currency requested from the API, only supported by paid plans
+	// empty uses the API's default (EUR)
+	Base   string
+	Client *http.Client
+
+	// guards latest and quota
+	mutex sync.Mutex
+	// last response to a request of the latest rates, its ETag and Last-Modified are sent back to make the next one
+	// conditional, past rates don't change and are stored in the history, so their responses aren't kept
+	latest response
+	// requests left this month as reported by the last response that had rate limit headers
+	quota    int
+	hasQuota bool
+}
+
+// response stores the validators and decoded data of a response
+type response struct {
+	url          string
+	etag         string
+	lastModified string
+	data         Data
+}
+
+// NewFixer returns a Provider fetching rates from fixer.io with the given access key
+func NewFixer(apiKey string) *Fixer {
+	return &Fixer{
+		APIKey:  apiKey,
+		BaseURL: "http://data.fixer.io/api/",
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// QuotaRemaining returns the requests the plan has left as reported by the rate limit headers of the last response
+func (f *Fixer) QuotaRemaining() (int, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.quota, f.hasQuota
+}
+
+// stores the requests left given by the rate limit headers of a response, monthly ones if it has them
+func (f *Fixer) updateQuota(header http.Header) {
+	for _, name := range []string{"X-RateLimit-Remaining-Month", "X-RateLimit-Remaining"} {
+		if remaining, err := strconv.Atoi(header.Get(name)); err == nil {
+			f.mutex.Lock()
+			f.quota, f.hasQuota = remaining, true
+			f.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// Latest fetches the most recent rates
+func (f *Fixer) Latest(ctx context.Context) (Data, error) {
+	return f.fetch(ctx, "latest")
+}
+
+// Historical fetches the rates of a past date (YYYY-MM-DD)
+func (f *Fixer) Historical(ctx context.Context, date string) (Data, error) {
+	return f.fetch(ctx, date)
+}
+
+// sends a request to the given fixer API endpoint and decodes the response, the request is canceled with ctx
+// returns the data of the previous response without decoding it again if the API answers 304 Not Modified
+// errors of the request and its response wrap ErrProviderUnavailable, 429 responses return a RateLimitError
+func (f *Fixer) fetch(ctx context.Context, endpoint string) (Data, error) {
+	u := f.BaseURL + endpoint + "?access_key=" + f.APIKey
+	if f.Base != "" {
+		u += "&base=" + f.Base
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return Data{}, err
+	}
+	f.mutex.Lock()
+	last := f.latest
+	f.mutex.Unlock()
+	ok := endpoint == "latest" && last.url == u
+	if !ok {
+		last = response{}
+	}
+	if last.etag != "" {
+		req.Header.Set("If-None-Match", last.etag)
+	}
+	if last.lastModified != "" {
+		req.Header.Set("If-Modified-Since", last.lastModified)
+	}
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return Data{}, fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+	}
+	defer resp.Body.Close()
+	f.updateQuota(resp.Header)
+	if resp.StatusCode == http.StatusNotModified && ok {
+		return last.data, nil
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return Data{}, &RateLimitError{time.Now().Add(retryAfter(resp.Header.Get("Retry-After"), time.Now()))}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Data{}, fmt.Errorf("%w: %s", ErrProviderUnavailable, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Data{}, fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+	}
+
+	d, err := Decode(body)
+	if err != nil && !errors.Is(err, ErrProviderUnavailable) {
+		// the body isn't the json of the API
+		return d, fmt.Errorf("%w: %w: %w", ErrProviderUnavailable, ErrInvalidResponse, err)
+	}
+	if err == nil && endpoint == "latest" {
+		f.remember(u, resp.Header, d)
+	}
+	return d, err
+}
+
+// returns the time to wait given by a Retry-After header value in seconds or as http date
+func retryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if t.Before(now) {
+			return 0
+		}
+		return t.Sub(now)
+	}
+	return defaultRetryAfter
+}
+
+// stores a successful response to the request of the latest rates at url if it can be validated by a conditional
+// request later
+func (f *Fixer) remember(url string, header http.Header, d Data) {
+	r := response{url, header.Get("ETag"), header.Get("Last-Modified"), d}
+	if r.etag == "" && r.lastModified == "" {
+		return
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.latest = r
+}
@@ -0,0 +1,173 @@
+package rates
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// upper bounds in seconds of the buckets request durations are counted in
+var DurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// QuotaReporter is implemented by providers that know how many requests their plan has left
+type QuotaReporter interface {
+	// QuotaRemaining returns the requests left and whether the provider reported them
+	QuotaRemaining() (int, bool)
+}
+
+// Histogram counts observed durations in DurationBuckets
+type Histogram struct {
+	// cumulative counts of the observations up to each bucket's bound
+	Buckets []uint64
+	Count   uint64
+	Sum     float64
+}
+
+// Observe counts a duration given in seconds
+func (h *Histogram) Observe(seconds float64) {
+	if h.Buckets == nil {
+		h.Buckets = make([]uint64, len(DurationBuckets))
+	}
+	for i, bound := range DurationBuckets {
+		if seconds <= bound {
+			h.Buckets[i]++
+		}
+	}
+	h.Count++
+	h.Sum += seconds
+}
+
+// ProviderStats is the state of ProviderMetrics at one point in time
+type ProviderStats struct {
+	Name string
+	// request durations by method, latest or historical
+	Durations map[string]Histogram
+	// failed requests by ErrorClass
+	Errors              map[string]uint64
+	ConsecutiveFailures int
+	// zero if no request has succeeded yet
+	LastSuccess time.Time
+	// -1 if the provider doesn't report its quota
+	QuotaRemaining int
+}
+
+// ProviderMetrics records the requests made to a provider
+type ProviderMetrics struct {
+	Name string
+	// OnRequest is called after every request with its method, duration and error, e.g. to forward them to another metrics system
+	OnRequest func(method string, duration time.Duration, err error)
+
+	mutex               sync.Mutex
+	durations           map[string]*Histogram
+	errors              map[string]uint64
+	consecutiveFailures int
+	lastSuccess         time.Time
+	quota               QuotaReporter
+}
+
+// NewProviderMetrics returns empty metrics of the provider called name
+func NewProviderMetrics(name string) *ProviderMetrics {
+	return &ProviderMetrics{Name: name, durations: make(map[string]*Histogram), errors: make(map[string]uint64)}
+}
+
+// ErrorClass sorts err into timeout, canceled, rate_limited, unavailable or other
+func ErrorClass(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrRateLimited):
+		return "rate_limited"
+	case errors.Is(err, ErrProviderUnavailable):
+		return "unavailable"
+	}
+	return "other"
+}
+
+// records a request of method that took the given duration and failed with err, if it isn't nil
+func (m *ProviderMetrics) record(method string, duration time.Duration, err error) {
+	if m.OnRequest != nil {
+		defer m.OnRequest(method, duration, err)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	h, ok := m.durations[method]
+	if !ok {
+		h = &Histogram{}
+		m.durations[method] = h
+	}
+	h.Observe(duration.Seconds())
+	if err != nil {
+		m.errors[ErrorClass(err)]++
+		m.consecutiveFailures++
+		return
+	}
+	m.consecutiveFailures = 0
+	m.lastSuccess = time.Now()
+}
+
+// Stats returns a copy of the recorded metrics
+func (m *ProviderMetrics) Stats() ProviderStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s := ProviderStats{
+		Name:                m.Name,
+		Durations:           make(map[string]Histogram, len(m.durations)),
+		Errors:              make(map[string]uint64, len(m.errors)),
+		ConsecutiveFailures: m.consecutiveFailures,
+		LastSuccess:         m.lastSuccess,
+		QuotaRemaining:      -1,
+	}
+	for method, h := range m.durations {
+		c := *h
+		c.Buckets = append([]uint64(nil), h.Buckets...)
+		s.Durations[method] = c
+	}
+	for class, n := range m.errors {
+		s.Errors[class] = n
+	}
+	if m.quota != nil {
+		if remaining, ok := m.quota.QuotaRemaining(); ok {
+			s.QuotaRemaining = remaining
+		}
+	}
+	return s
+}
+
+// Instrumented is a Provider that records the duration and outcome of every request to Provider in Metrics
+type Instrumented struct {
+	Provider Provider
+	Metrics  *ProviderMetrics
+}
+
+// NewInstrumented returns p recording its requests in metrics, which report the quota of p if it implements QuotaReporter
+func NewInstrumented(p Provider, metrics *ProviderMetrics) *Instrumented {
+	if q, ok := p.(QuotaReporter); ok {
+		metrics.mutex.Lock()
+		metrics.quota = q
+		metrics.mutex.Unlock()
+	}
+	return &Instrumented{p, metrics}
+}
+
+// Latest returns the most recent rates of Provider
+func (i *Instrumented) Latest(ctx context.Context) (Data, error) {
+	start := time.Now()
+	d, err := i.Provider.Latest(ctx)
+	i.Metrics.record("latest", time.Since(start), err)
+	return d, err
+}
+
+// Historical returns the rates of a past date (YYYY-MM-DD) of Provider
+func (i *Instrumented) Historical(ctx context.Context, date string) (Data, error) {
+	start := time.Now()
+	d, err := i.Provider.Historical(ctx, date)
+	i.Metrics.record("historical", time.Since(start), err)
+	return d, err
+}
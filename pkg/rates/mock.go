@@ -0,0 +1,85 @@
+package rates
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"time"
+)
+
+// rates of one euro the mock rates vary around
+var mockRates = map[string]float64{
+	"EUR": 1,
+	"USD": 1.08,
+	"GBP": 0.86,
+	"JPY": 160,
+	"CHF": 0.95,
+	"AUD": 1.65,
+	"CAD": 1.47,
+	"CNY": 7.8,
+	"HKD": 8.45,
+	"SGD": 1.45,
+	"SEK": 11.4,
+	"NOK": 11.6,
+	"DKK": 7.46,
+	"PLN": 4.3,
+	"CZK": 25.2,
+	"INR": 90,
+	"BRL": 5.9,
+	"MXN": 19.5,
+	"ZAR": 20,
+	"TRY": 35,
+	"KRW": 1450,
+	"NZD": 1.8,
+}
+
+// Mock is a Provider serving deterministic fake rates without an API key or network access
+// the rates of a day only depend on its date, so every run of the server sees the same rates
+type Mock struct{}
+
+func init() {
+	RegisterProvider("mock", func() (Provider, error) {
+		return NewMock(), nil
+	})
+}
+
+// NewMock returns a Provider serving fake rates
+func NewMock() *Mock {
+	return &Mock{}
+}
+
+// Latest returns the rates of today, timestamped with the start of the current hour
+func (m *Mock) Latest(ctx context.Context) (Data, error) {
+	now := time.Now().UTC()
+	d := m.day(now.Format("2006-01-02"))
+	d.Timestamp = now.Truncate(time.Hour).Unix()
+	return d, nil
+}
+
+// Historical returns the rates of a past date (YYYY-MM-DD), timestamped with the end of that day
+func (m *Mock) Historical(ctx context.Context, date string) (Data, error) {
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return Data{}, fmt.Errorf("%w: invalid date %s", ErrProviderUnavailable, date)
+	}
+	d := m.day(date)
+	d.Timestamp = day.Add(24*time.Hour - time.Second).Unix()
+	return d, nil
+}
+
+// returns the rates of date, each rate deviates by up to 2% from mockRates depending on date and currency
+func (m *Mock) day(date string) Data {
+	d := Data{Success: true, Base: "EUR", Date: date, Rates: make(map[string]float64, len(mockRates))}
+	for currency, rate := range mockRates {
+		if currency == d.Base {
+			d.Rates[currency] = 1
+			continue
+		}
+		h := fnv.New32a()
+		h.Write([]byte(date + currency))
+		deviation := float64(h.Sum32()%4001)/100000 - 0.02
+		d.Rates[currency] = math.Round(rate*(1+deviation)*1e6) / 1e6
+	}
+	return d
+}
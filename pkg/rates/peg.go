@@ -0,0 +1,76 @@
+package rates
+
+import "context"
+
+// Peg fixes the rate of a currency to an anchor currency, Rate units of the currency are worth one unit of Anchor
+type Peg struct {
+	Anchor string
+	Rate   float64
+}
+
+// Pegged is a Provider that derives the rates of pegged currencies from their anchor
+// instead of using the quotes of Provider, which drift around the peg
+type Pegged struct {
+	Provider Provider
+	// pegs by the currency they fix
+	Pegs map[string]Peg
+}
+
+// Latest returns the most recent rates of Provider with the pegs applied
+func (p *Pegged) Latest(ctx context.Context) (Data, error) {
+	d, err := p.Provider.Latest(ctx)
+	if err != nil {
+		return d, err
+	}
+	return p.apply(d), nil
+}
+
+// Historical returns the rates of a past date (YYYY-MM-DD) of Provider with the pegs applied
+func (p *Pegged) Historical(ctx context.Context, date string) (Data, error) {
+	d, err := p.Provider.Historical(ctx, date)
+	if err != nil {
+		return d, err
+	}
+	return p.apply(d), nil
+}
+
+// returns d with the rates of the pegged currencies replaced, pegs whose anchor d doesn't quote are left out
+func (p *Pegged) apply(d Data) Data {
+	d.Rates = copyRates(d.Rates)
+	d.Bid, d.Ask = copyRates(d.Bid), copyRates(d.Ask)
+	for currency, peg := range p.Pegs {
+		if currency == d.Base {
+			continue
+		}
+		anchor, ok := d.Rates[peg.Anchor]
+		if peg.Anchor == d.Base {
+			anchor, ok = 1, true
+		}
+		if !ok {
+			continue
+		}
+		d.Rates[currency] = anchor * peg.Rate
+		// a peg has no spread of its own, it moves with the anchor's
+		bid, okBid := d.Bid[peg.Anchor]
+		ask, okAsk := d.Ask[peg.Anchor]
+		if okBid && okAsk {
+			d.Bid[currency], d.Ask[currency] = bid*peg.Rate, ask*peg.Rate
+		} else {
+			delete(d.Bid, currency)
+			delete(d.Ask, currency)
+		}
+	}
+	return d
+}
+
+// returns a copy of rates, nil for nil
+func copyRates(rates map[string]float64) map[string]float64 {
+	if rates == nil {
+		return nil
+	}
+	c := make(map[string]float64, len(rates))
+	for currency, rate := range rates {
+		c[currency] = rate
+	}
+	return c
+}
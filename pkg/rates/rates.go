@@ -0,0 +1,128 @@
+// Package rates fetches currency conversion rates from providers and caches them for re-use.
+package rates
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errors returned by this package, wrapped with the details of the failure so they can be checked with errors.Is
+var (
+	// ErrProviderUnavailable is returned if the provider can't be reached or doesn't answer with rates
+	ErrProviderUnavailable = errors.New("rates: provider unavailable")
+	// ErrStaleData is returned by Cache together with the outdated data if it can't be refreshed
+	ErrStaleData = errors.New("rates: data is stale")
+	// ErrUnknownCurrency is returned for currencies without a rate
+	ErrUnknownCurrency = errors.New("unknown currency")
+	// ErrRateLimited is matched by a RateLimitError
+	ErrRateLimited = errors.New("rates: rate limited")
+	// ErrInvalidResponse is returned together with ErrProviderUnavailable if the provider answers with something that can't be decoded
+	ErrInvalidResponse = errors.New("rates: invalid response")
+)
+
+// RateLimitError is returned if the provider answers 429 Too Many Requests
+// it matches both ErrRateLimited and ErrProviderUnavailable
+type RateLimitError struct {
+	// time after which the provider accepts requests again, as given by its Retry-After header
+	RetryAt time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return "rates: rate limited by the provider until " + e.RetryAt.UTC().Format(time.RFC3339)
+}
+
+// Is reports whether target is ErrRateLimited or ErrProviderUnavailable
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited || target == ErrProviderUnavailable
+}
+
+// Data stores data from api request for re-use
+type Data struct {
+	Success bool
+	// timestamp of api request
+	Timestamp int64
+	// base currency for calculations
+	Base string
+	Date string
+	// maps currency identifiers to their value in base currency
+	Rates map[string]float64
+	// lowest and highest value of each currency in base currency, only set by providers that supply a spread
+	Bid map[string]float64
+	Ask map[string]float64
+	// whether the rates were read from a static file instead of being fetched, set by File
+	Static bool `json:"-"`
+}
+
+// Provider fetches currency conversion data from an upstream source
+type Provider interface {
+	// Latest returns the most recent rates
+	Latest(ctx context.Context) (Data, error)
+	// Historical returns the rates of a past date (YYYY-MM-DD)
+	Historical(ctx context.Context, date string) (Data, error)
+}
+
+// Time returns the time the data was fetched at by the provider
+func (d Data) Time() time.Time {
+	return time.Unix(d.Timestamp, 0)
+}
+
+// Rebase returns d with all rates expressed in base instead of d.Base
+func (d Data) Rebase(base string) (Data, error) {
+	if base == d.Base {
+		return d, nil
+	}
+	rate, ok := d.Rates[base]
+	if !ok || rate == 0 {
+		return d, fmt.Errorf("rates: %w %s", ErrUnknownCurrency, base)
+	}
+
+	rebased := d
+	rebased.Base = base
+	rebased.Rates = make(map[string]float64, len(d.Rates))
+	for currency, r := range d.Rates {
+		rebased.Rates[currency] = r / rate
+	}
+	// the lowest value in the new base is reached at the highest value of the base and vice versa
+	if d.Bid != nil && d.Ask != nil {
+		baseBid, baseAsk := d.Bid[base], d.Ask[base]
+		rebased.Bid = make(map[string]float64, len(d.Bid))
+		rebased.Ask = make(map[string]float64, len(d.Ask))
+		for currency, bid := range d.Bid {
+			rebased.Bid[currency] = bid / baseAsk
+		}
+		for currency, ask := range d.Ask {
+			rebased.Ask[currency] = ask / baseBid
+		}
+	}
+	return rebased, nil
+}
+
+// Decode takes json as returned by the fixer API and creates a Data struct with corresponding values
+// returns the error reported by the API wrapping ErrProviderUnavailable if the request was unsuccessful
+func Decode(b []byte) (Data, error) {
+	var response struct {
+		Data
+		Error struct {
+			Code int
+			Type string
+			Info string
+		}
+	}
+
+	err := json.Unmarshal(b, &response)
+	if err != nil {
+		return Data{}, err
+	}
+
+	if !response.Success {
+		msg := response.Error.Info
+		if msg == "" {
+			msg = response.Error.Type
+		}
+		return response.Data, fmt.Errorf("%w: request failed: %s", ErrProviderUnavailable, msg)
+	}
+	return response.Data, nil
+}
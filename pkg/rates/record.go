@@ -0,0 +1,79 @@
+package rates
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// returns the file in dir storing the response to req
+// the query is hashed without the access key, so recordings can be shared without leaking it
+func recordingPath(dir string, req *http.Request) string {
+	query := req.URL.Query()
+	query.Del("access_key")
+	sum := sha256.Sum256([]byte(req.Method + " " + req.URL.Host + req.URL.Path + "?" + query.Encode()))
+	name := strings.Trim(strings.ReplaceAll(req.URL.Path, "/", "_"), "_")
+	return filepath.Join(dir, req.Method+"_"+name+"_"+hex.EncodeToString(sum[:])[:12]+".http")
+}
+
+// Recorder is an http.RoundTripper that stores every response it receives in Dir
+// the files hold the raw responses and can be served again by a Replayer, the last response of a request wins
+type Recorder struct {
+	Dir string
+	// sends the requests, defaults to http.DefaultTransport
+	Transport http.RoundTripper
+}
+
+// RoundTrip sends req and records its response
+func (rec *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := rec.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// read completely so the body can be both written to disk and returned
+	raw, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	err = os.MkdirAll(rec.Dir, 0755)
+	if err == nil {
+		err = ioutil.WriteFile(recordingPath(rec.Dir, req), raw, 0644)
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("rates: recording response: %w", err)
+	}
+	return resp, nil
+}
+
+// Replayer is an http.RoundTripper answering requests with the responses recorded in Dir, without network access
+type Replayer struct {
+	Dir string
+}
+
+// RoundTrip returns the recorded response to req byte for byte
+func (rep *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := recordingPath(rep.Dir, req)
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("rates: no recorded response for %s %s in %s", req.Method, req.URL.Path, rep.Dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), req)
+}
@@ -0,0 +1,58 @@
+package rates
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory creates a provider, reading its settings e.g. from environment variables
+type Factory func() (Provider, error)
+
+// ErrUnknownProvider is returned by NewProvider for names without a registered factory
+var ErrUnknownProvider = errors.New("rates: unknown provider")
+
+// factories of the registered providers by name
+var registry = struct {
+	sync.Mutex
+	factories map[string]Factory
+}{factories: make(map[string]Factory)}
+
+// RegisterProvider makes the provider created by factory available under name
+// packages adding providers call it from their init function and are compiled in with a blank import,
+// it panics if name is registered twice
+func RegisterProvider(name string, factory Factory) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.factories[name]; ok {
+		panic("rates: provider " + name + " registered twice")
+	}
+	registry.factories[name] = factory
+}
+
+// NewProvider creates the provider registered under name
+func NewProvider(name string) (Provider, error) {
+	registry.Lock()
+	factory, ok := registry.factories[name]
+	registry.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownProvider, name)
+	}
+	return factory()
+}
+
+// Providers returns the names of the registered providers in alphabetical order
+func Providers() []string {
+	registry.Lock()
+	defer registry.Unlock()
+
+	names := make([]string, 0, len(registry.factories))
+	for name := range registry.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
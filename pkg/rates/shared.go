@@ -0,0 +1,142 @@
+package rates
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SharedStore holds the latest rates shared by several replicas and the lock that decides which of them fetches them
+type SharedStore interface {
+	// Lock takes the lock for at most ttl, ok is false if another replica holds it
+	// release gives it up early and must be called once the rates are saved
+	Lock(ctx context.Context, ttl time.Duration) (release func(), ok bool, err error)
+	// Load returns the shared rates and when they were fetched, ok is false if none have been saved yet
+	Load(ctx context.Context) (d Data, fetched time.Time, ok bool, err error)
+	// Save shares d, which was fetched at fetched
+	Save(ctx context.Context, d Data, fetched time.Time) error
+	// LoadFailure returns the error of the last failed fetch and when it failed, ok is false if none has been saved yet
+	LoadFailure(ctx context.Context) (msg string, failed time.Time, ok bool, err error)
+	// SaveFailure shares that fetching the rates failed at failed with msg
+	SaveFailure(ctx context.Context, msg string, failed time.Time) error
+}
+
+// Shared is a Provider that lets only one of several replicas ask Provider for the latest rates per Interval,
+// the others read the rates it saved in Store, to protect the quota of the provider
+// if Store fails the rates are fetched from Provider directly, a replica shouldn't go down with the shared store
+type Shared struct {
+	Provider Provider
+	Store    SharedStore
+	// rates saved less than Interval ago are used instead of asking Provider
+	Interval time.Duration
+	// time the lock is held at most and other replicas wait for the rates of its holder, longer than a fetch takes
+	LockTTL time.Duration
+	// after a replica failed to fetch the rates the others use the shared rates for FailureTTL instead of asking
+	// Provider as well, so a provider that is down isn't asked by every replica at once
+	FailureTTL time.Duration
+	// OnError is called with the errors of Store, if it is set
+	OnError func(error)
+}
+
+// how often replicas waiting for the holder of the lock check whether it saved the rates
+const sharedPollInterval = 250 * time.Millisecond
+
+// Latest returns the shared rates if they were fetched less than Interval ago
+// otherwise it fetches them from Provider and saves them if it gets the lock, or waits for the replica holding it
+func (s *Shared) Latest(ctx context.Context) (Data, error) {
+	d, fetched, ok, err := s.Store.Load(ctx)
+	if err != nil {
+		s.fail(err)
+		return s.Provider.Latest(ctx)
+	}
+	if ok && time.Since(fetched) < s.Interval {
+		return d, nil
+	}
+	if msg, failed := s.recentFailure(ctx, fetched); failed {
+		return s.afterFailure(d, ok, msg)
+	}
+
+	release, locked, err := s.Store.Lock(ctx, s.LockTTL)
+	if err != nil {
+		s.fail(err)
+		return s.Provider.Latest(ctx)
+	}
+	if !locked {
+		return s.await(ctx, fetched)
+	}
+	defer release()
+
+	now := time.Now()
+	d, err = s.Provider.Latest(ctx)
+	if err != nil {
+		if saveErr := s.Store.SaveFailure(ctx, err.Error(), time.Now()); saveErr != nil {
+			s.fail(saveErr)
+		}
+		return d, err
+	}
+	if err := s.Store.Save(ctx, d, now); err != nil {
+		s.fail(err)
+	}
+	return d, nil
+}
+
+// waits up to LockTTL for another replica to save rates fetched after previous or a failure
+// the rates are fetched from Provider if it saves neither, the holder of the lock may have crashed
+func (s *Shared) await(ctx context.Context, previous time.Time) (Data, error) {
+	deadline := time.NewTimer(s.LockTTL)
+	defer deadline.Stop()
+	poll := time.NewTicker(sharedPollInterval)
+	defer poll.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return Data{}, ctx.Err()
+		case <-deadline.C:
+			return s.Provider.Latest(ctx)
+		case <-poll.C:
+			d, fetched, ok, err := s.Store.Load(ctx)
+			if err != nil {
+				s.fail(err)
+				return s.Provider.Latest(ctx)
+			}
+			if ok && fetched.After(previous) {
+				return d, nil
+			}
+			if msg, failed := s.recentFailure(ctx, previous); failed {
+				return s.afterFailure(d, ok, msg)
+			}
+		}
+	}
+}
+
+// returns the error of the replica that failed to fetch the rates after they were fetched at fetched,
+// failed is false if none did or it was more than FailureTTL ago
+func (s *Shared) recentFailure(ctx context.Context, fetched time.Time) (msg string, failed bool) {
+	msg, at, ok, err := s.Store.LoadFailure(ctx)
+	if err != nil {
+		s.fail(err)
+		return "", false
+	}
+	return msg, ok && at.After(fetched) && time.Since(at) < s.FailureTTL
+}
+
+// returns the outdated shared rates d after another replica failed to fetch new ones with msg,
+// or an error if there are none (ok is false)
+func (s *Shared) afterFailure(d Data, ok bool, msg string) (Data, error) {
+	if !ok {
+		return Data{}, fmt.Errorf("%w: another replica failed to fetch the rates: %s", ErrProviderUnavailable, msg)
+	}
+	return d, nil
+}
+
+// Historical returns the rates of a past date (YYYY-MM-DD) of Provider, they are requested rarely and stored in the history
+func (s *Shared) Historical(ctx context.Context, date string) (Data, error) {
+	return s.Provider.Historical(ctx, date)
+}
+
+// passes err to OnError
+func (s *Shared) fail(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
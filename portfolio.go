@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"currconv/pkg/convert"
+)
+
+// Holding is an amount of one currency in a portfolio
+type Holding struct {
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+}
+
+// Position stores a holding valued in the target currency
+type Position struct {
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+	Rate     float64 `json:"rate"`
+	Value    float64 `json:"value"`
+}
+
+// Portfolio stores the value of all holdings in the target currency
+type Portfolio struct {
+	To        string     `json:"to"`
+	Positions []Position `json:"positions"`
+	Total     float64    `json:"total"`
+	Timestamp int64      `json:"timestamp"`
+	Time      string     `json:"time"`
+	Date      string     `json:"date"`
+}
+
+// values the holdings posted as json array in the currency given by the to parameter
+// all positions use the same rate table
+func apiPortfolioHandler(w http.ResponseWriter, r *http.Request) {
+
+	var holdings []Holding
+	err := json.NewDecoder(r.Body).Decode(&holdings)
+	if bodyTooLarge(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "body must be a json array of {currency, amount} objects")
+		return
+	}
+
+	d := getCurrentData(r.Context())
+	to := currencyCode(r.URL.Query().Get("to"))
+	if _, ok := d.Rates[to]; !ok {
+		writeError(w, http.StatusBadRequest, "unknown currency "+to)
+		return
+	}
+
+	p := Portfolio{To: to, Positions: []Position{}, Timestamp: d.Timestamp, Time: rfc3339(d.Timestamp), Date: d.Date}
+	var total float64
+	for _, h := range holdings {
+		currency := currencyCode(h.Currency)
+		if _, ok := d.Rates[currency]; !ok {
+			writeError(w, http.StatusBadRequest, "unknown currency "+currency)
+			return
+		}
+		rate := midRate(d, currency, to)
+		value := h.Amount * rate
+		total += value
+		p.Positions = append(p.Positions, Position{currency, h.Amount, rate, convert.RoundTo2Decimals(value)})
+	}
+	// rounded once so the total doesn't carry the rounding errors of the positions
+	p.Total = convert.RoundTo2Decimals(total)
+	writeJSON(w, p)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"currconv/pkg/convert"
+)
+
+// most decimals a result can be shown with
+const maxDecimals = 8
+
+// Preferences are the display settings a visitor chose on /settings/, kept in their session
+type Preferences struct {
+	// digits after the decimal point of converted amounts
+	Decimals int
+	Rounding convert.Rounding
+	// locale of the pages and numbers, empty to follow the browser
+	Locale string
+	// currency the converter starts with and rate tables are expressed in, empty for the default
+	Base string
+}
+
+// preferences of visitors who haven't changed them, and of commands
+var defaultPreferences = Preferences{Decimals: 2, Rounding: convert.HalfUp}
+
+// SettingsPage stores variables for /settings/
+type SettingsPage struct {
+	Preferences
+	Roundings  []convert.Rounding
+	Languages  []string
+	Currencies []string
+	Message    string
+	Flashes    []string
+	CSRF       string
+}
+
+// returns the preferences stored in the session of r, the defaults for those it doesn't set
+func preferencesFor(r *http.Request) Preferences {
+	p := defaultPreferences
+	if n, err := strconv.Atoi(sessionValue(r, "decimals")); err == nil && n >= 0 && n <= maxDecimals {
+		p.Decimals = n
+	}
+	if rounding, ok := convert.ParseRounding(sessionValue(r, "rounding")); ok {
+		p.Rounding = rounding
+	}
+	p.Locale = sessionValue(r, "locale")
+	p.Base = sessionValue(r, "base")
+	return p
+}
+
+// rounds a converted amount as p asks for
+func (p Preferences) round(x float64) float64 {
+	return convert.Round(x, p.Decimals, p.Rounding)
+}
+
+// returns the languages pages are translated into, sorted
+func supportedLanguages() []string {
+	var languages []string
+	for language := range catalogs {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// shows the preferences of the session and stores the submitted ones
+func settingsHandler(w http.ResponseWriter, r *http.Request) {
+	d := getCurrentData(r.Context())
+	p := SettingsPage{
+		Preferences: preferencesFor(r),
+		Roundings:   convert.Roundings,
+		Languages:   supportedLanguages(),
+		Currencies:  sortedCurrencies(d),
+		CSRF:        csrfToken(r),
+	}
+
+	if r.Method != http.MethodPost {
+		p.Flashes = popFlashes(w, r)
+		renderTemplate(w, r, "settings", &p)
+		return
+	}
+
+	r.ParseForm()
+	decimals, err := strconv.Atoi(r.Form.Get("decimals"))
+	rounding, roundingOK := convert.ParseRounding(r.Form.Get("rounding"))
+	locale := r.Form.Get("locale")
+	_, localeOK := parseLocale(locale)
+	base := currencyCode(r.Form.Get("base"))
+	switch {
+	case err != nil || decimals < 0 || decimals > maxDecimals:
+		p.Message = "Decimals must be a number from 0 to " + strconv.Itoa(maxDecimals) + "."
+	case !roundingOK:
+		p.Message = "Rounding must be one of the listed modes."
+	case locale != "" && !localeOK:
+		p.Message = "Language must be one of " + strings.Join(p.Languages, ", ") + "."
+	case base != "" && checkCurrencies(d, base) != nil:
+		p.Message = unknownCurrency(base).Error() + "."
+	}
+	if p.Message != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, r, "settings", &p)
+		return
+	}
+
+	s := getSession(r)
+	if s.Values == nil {
+		s.Values = make(map[string]string)
+	}
+	s.Values["decimals"] = strconv.Itoa(decimals)
+	s.Values["rounding"] = string(rounding)
+	s.Values["locale"] = locale
+	s.Values["base"] = base
+	// saves the session with the preferences
+	addFlash(w, r, "Your settings were saved.")
+	http.Redirect(w, r, "/settings/", http.StatusSeeOther)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"currconv/pkg/convert"
+)
+
+var quotes = &Quotes{Quotes: make(map[string]Quote)}
+
+// Quote locks the rate of a pair until it expires
+type Quote struct {
+	ID   string  `json:"id"`
+	From string  `json:"from"`
+	To   string  `json:"to"`
+	Rate float64 `json:"rate"`
+	Side string  `json:"side"`
+	// timestamp and date of the data the rate was taken from
+	Timestamp int64  `json:"timestamp"`
+	Time      string `json:"time"`
+	Date      string `json:"date"`
+	ExpiresAt int64  `json:"expires_at"`
+	// ExpiresAt in RFC 3339
+	Expires string `json:"expires"`
+}
+
+// Quotes stores all quotes that have not expired yet
+type Quotes struct {
+	mutex  sync.Mutex
+	Quotes map[string]Quote
+}
+
+// returns how long quotes lock their rate, set by the quote_ttl environment variable (30 minutes by default)
+func getQuoteTTL() time.Duration {
+	ttl, err := time.ParseDuration(getEnv("quote_ttl", "30m"))
+	if err != nil {
+		log.Println(err)
+		return 30 * time.Minute
+	}
+	return ttl
+}
+
+// returns a random id of 16 hex characters
+func newQuoteID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Println(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// stores q under a new id and removes expired quotes
+// returns the stored quote
+func (qs *Quotes) add(q Quote) Quote {
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+
+	now := time.Now().Unix()
+	for id, old := range qs.Quotes {
+		if old.ExpiresAt <= now {
+			delete(qs.Quotes, id)
+		}
+	}
+
+	q.ID = newQuoteID()
+	qs.Quotes[q.ID] = q
+	return q
+}
+
+// returns the quote with the given id
+// expired is true if the quote exists but has expired
+func (qs *Quotes) get(id string) (q Quote, ok bool, expired bool) {
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+
+	q, ok = qs.Quotes[id]
+	if ok && q.ExpiresAt <= time.Now().Unix() {
+		delete(qs.Quotes, id)
+		return q, false, true
+	}
+	return q, ok, false
+}
+
+// creates a quote locking the current rate of the pair given by the from and to form values
+// the side form value (buy, sell or mid) selects the rate of the spread
+func apiQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	err := parseForm(r)
+	if bodyTooLarge(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "the form could not be parsed")
+		return
+	}
+
+	d := getCurrentData(r.Context())
+	from := currencyCode(r.Form.Get("from"))
+	to := currencyCode(r.Form.Get("to"))
+	d = withRetiredRates(d, from, to)
+	if err := checkCurrencies(d, from, to); err != nil {
+		failRequest(w, r, err)
+		return
+	}
+	side, ok := convert.ParseSide(r.Form.Get("side"))
+	if !ok {
+		writeError(w, http.StatusBadRequest, "side must be buy, sell or mid")
+		return
+	}
+
+	expires := time.Now().Add(getQuoteTTL()).Unix()
+	q := quotes.add(Quote{
+		From:      from,
+		To:        to,
+		Rate:      convert.SideRate(d, from, to, side, getSpread()),
+		Side:      string(side),
+		Timestamp: d.Timestamp,
+		Time:      rfc3339(d.Timestamp),
+		Date:      d.Date,
+		ExpiresAt: expires,
+		Expires:   rfc3339(expires),
+	})
+
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, q)
+}
+
+// converts amount with the locked rate of the quote with the given id and applies markup m
+// writes an error and returns false if the quote is unknown, expired or doesn't match from and to
+func convertWithQuote(w http.ResponseWriter, id string, from string, to string, amount float64, m convert.Markup, p Preferences) (Conversion, bool) {
+	q, ok, expired := quotes.get(id)
+	if expired {
+		writeError(w, http.StatusGone, "quote "+id+" has expired")
+		return Conversion{}, false
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown quote "+id)
+		return Conversion{}, false
+	}
+	if (from != "" && from != q.From) || (to != "" && to != q.To) {
+		writeError(w, http.StatusBadRequest, "quote "+id+" is for "+q.From+"/"+q.To)
+		return Conversion{}, false
+	}
+
+	result, baseResult, applied := applyRate(q.From, q.To, q.Rate, amount, m, p)
+	return Conversion{
+		From:       q.From,
+		To:         q.To,
+		Amount:     amount,
+		Rate:       q.Rate,
+		Side:       q.Side,
+		Result:     result,
+		BaseResult: baseResult,
+		Markup:     applied,
+		Timestamp:  q.Timestamp,
+		Time:       q.Time,
+		Date:       q.Date,
+		Quote:      q.ID,
+	}, true
+}
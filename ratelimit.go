@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limiter counts the requests of every client in the current minute
+type Limiter struct {
+	mutex sync.Mutex
+	// start of the current minute as unix time
+	window int64
+	counts map[string]int
+}
+
+// counts all API requests, keyed by "key:<api key>" or "ip:<address>"
+var limiter = &Limiter{counts: make(map[string]int)}
+
+// counts a request of client and returns the requests left in the current minute and when it ends
+// ok is false if client already used all limit requests
+func (l *Limiter) take(client string, limit int, now time.Time) (remaining int, reset int64, ok bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	window := now.Truncate(time.Minute).Unix()
+	if window != l.window {
+		// forget the clients of the previous minute so the map doesn't grow
+		l.window = window
+		l.counts = make(map[string]int)
+	}
+	reset = window + 60
+	if l.counts[client] >= limit {
+		return 0, reset, false
+	}
+	l.counts[client]++
+	return limit - l.counts[client], reset, true
+}
+
+// warns once that requests come through a proxy while trust_proxy isn't set
+var untrustedProxyWarning sync.Once
+
+// returns the address of the client sending r
+// the last address in X-Forwarded-For is used if trust_proxy is set, as added by the proxy in front of the server
+func clientIP(r *http.Request) string {
+	forwarded := r.Header.Get("X-Forwarded-For")
+	if getEnv("trust_proxy", "false") == "true" {
+		if forwarded != "" {
+			addresses := strings.Split(forwarded, ",")
+			return strings.TrimSpace(addresses[len(addresses)-1])
+		}
+	} else if forwarded != "" {
+		untrustedProxyWarning.Do(func() {
+			log.Println("warning: requests are forwarded by a proxy but trust_proxy is not set, " +
+				"all clients share the rate limit and address rules of the proxy's address")
+		})
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// returns the requests per minute allowed by the environment variable key, 0 for no limit
+func getRateLimit(key string, fallback string) int {
+	limit, err := strconv.Atoi(getEnv(key, fallback))
+	if err != nil || limit < 0 {
+		return 0
+	}
+	return limit
+}
+
+// returns the client r is counted as and its requests per minute
+// requests with a valid api key are limited by the key's rate_limit or else api_key_rate_limit (600 by default),
+// other requests by their address with rate_limit (60 by default)
+func rateLimitFor(r *http.Request) (string, int) {
+	if k, ok := serverFrom(r.Context()).apiKeys[getAPIKey(r)]; ok {
+		if k.RateLimit > 0 {
+			return "key:" + k.Key, k.RateLimit
+		}
+		return "key:" + k.Key, getRateLimit("api_key_rate_limit", "600")
+	}
+	return "ip:" + clientIP(r), getRateLimit("rate_limit", "60")
+}
+
+// counts r against the limit of its client and sets the X-RateLimit headers
+// writes a 429 response and returns false if the limit is used up
+func allowRequest(w http.ResponseWriter, r *http.Request) bool {
+	client, limit := rateLimitFor(r)
+	if limit == 0 {
+		return true
+	}
+
+	now := time.Now()
+	remaining, reset, ok := limiter.take(client, limit, now)
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+	if !ok {
+		w.Header().Set("Retry-After", strconv.FormatInt(reset-now.Unix(), 10))
+		writeError(w, http.StatusTooManyRequests, "rate limit of "+strconv.Itoa(limit)+" requests per minute exceeded")
+	}
+	return ok
+}
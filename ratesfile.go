@@ -0,0 +1,22 @@
+package main
+
+import (
+	"strings"
+
+	"currconv/pkg/rates"
+)
+
+// rates read from the file set by rates_file, nil if it isn't set
+var ratesFile = newRatesFile()
+
+// returns the provider reading the json or csv file set by rates_file, nil if it isn't set
+// csv rates are in the currency set by rates_file_base (EUR by default)
+func newRatesFile() *rates.File {
+	path := getEnv("rates_file", "")
+	if path == "" {
+		return nil
+	}
+	f := rates.NewFile(path)
+	f.Base = strings.ToUpper(getEnv("rates_file_base", "EUR"))
+	return f
+}
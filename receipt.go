@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"currconv/pkg/convert"
+)
+
+// renders a PDF receipt of the conversion given in the url query (same parameters as /convert/)
+func receiptHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	d, err := dataFor(r.Context(), query.Get("date"))
+	if err != nil {
+		failRequest(w, r, err)
+		return
+	}
+
+	from := currencyCode(query.Get("from"))
+	to := currencyCode(query.Get("to"))
+	d = withRetiredRates(d, from, to)
+	value, err := parseConversion(d, from, to, query.Get("value"))
+	if err != nil {
+		failRequest(w, r, err)
+		return
+	}
+
+	c := newConversion(d, from, to, value, convert.Mid, markupFor(r), defaultPreferences)
+	generated := time.Now().UTC()
+
+	lines := []string{
+		fmt.Sprintf("Amount:          %.2f %s", value, from),
+		fmt.Sprintf("Converted:       %.2f %s", c.Result, to),
+		fmt.Sprintf("Rate:            1 %s = %.6f %s (mid-market)", from, c.Rate, to),
+	}
+	if c.Markup != nil {
+		lines = append(lines,
+			fmt.Sprintf("At mid-market:   %.2f %s", c.BaseResult, to),
+			fmt.Sprintf("Markup:          %g%% + %.2f %s fee = %.2f %s", c.Markup.Percent, c.Markup.Fee, to, c.Markup.Amount, to))
+	}
+	// the optional vat parameter adds the tax on top of the converted amount
+	if vat, err := strconv.ParseFloat(query.Get("vat"), 64); err == nil && vat > 0 {
+		v := newVAT(c.Result, vat)
+		lines = append(lines,
+			fmt.Sprintf("Net:             %.2f %s", v.Net, to),
+			fmt.Sprintf("%-17s%.2f %s", fmt.Sprintf("VAT %g%%:", v.Percent), v.Tax, to),
+			fmt.Sprintf("Gross:           %.2f %s", v.Gross, to))
+	}
+	source := activeProvider()
+	switch {
+	case d.Static:
+		source = "rates file"
+	case source == "":
+		source = "unknown"
+	}
+	lines = append(lines,
+		"",
+		"Source:          "+source+" (base "+d.Base+")",
+		"Data date:       "+d.Date,
+		"Rates fetched:   "+time.Unix(d.Timestamp, 0).UTC().Format(time.RFC1123),
+		"Generated:       "+generated.Format(time.RFC1123),
+	)
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="receipt-`+from+`-`+to+`-`+generated.Format("20060102-150405")+`.pdf"`)
+	err = writePDF(w, "Currency conversion receipt", lines)
+	if err != nil {
+		log.Println(err)
+	}
+}
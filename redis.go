@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"currconv/pkg/rates"
+)
+
+// RedisError is an error reply of the server
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// Redis is a client for the few commands the replicas coordinate with, speaking RESP over a single connection
+type Redis struct {
+	mutex  sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+
+	Addr     string
+	Password string
+	DB       int
+	TLS      bool
+}
+
+// returns a client for url, e.g. redis://:password@localhost:6379/0, rediss:// connects over TLS
+func newRedis(rawurl string) (*Redis, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, errors.New("redis_url must be given as redis://[:password@]host[:port][/db]")
+	}
+	c := &Redis{Addr: u.Host, TLS: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		c.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.Password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.DB, err = strconv.Atoi(db); err != nil {
+			return nil, errors.New("the database of redis_url must be a number")
+		}
+	}
+	return c, nil
+}
+
+// connects to the server and selects the database, the mutex must be held by the caller
+func (c *Redis) connect(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	if c.TLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer}).DialContext(ctx, "tcp", c.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", c.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	c.conn, c.reader = conn, bufio.NewReader(conn)
+	if c.Password != "" {
+		if _, err := c.roundTrip("AUTH", c.Password); err != nil {
+			c.close()
+			return err
+		}
+	}
+	if c.DB != 0 {
+		if _, err := c.roundTrip("SELECT", strconv.Itoa(c.DB)); err != nil {
+			c.close()
+			return err
+		}
+	}
+	return nil
+}
+
+// closes the connection, the next command opens a new one
+func (c *Redis) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn, c.reader = nil, nil
+	}
+}
+
+// sends the command args and returns its reply: a string, an int64, nil or a []interface{} of those
+// error replies are returned as RedisError, other errors close the connection
+func (c *Redis) do(ctx context.Context, args ...string) (interface{}, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	c.conn.SetDeadline(deadline)
+	reply, err := c.roundTrip(args...)
+	var redisErr RedisError
+	if err != nil && !errors.As(err, &redisErr) {
+		c.close()
+	}
+	return reply, err
+}
+
+// writes args as array of bulk strings and reads the reply
+func (c *Redis) roundTrip(args ...string) (interface{}, error) {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+// reads a reply of the RESP2 protocol
+func (c *Redis) readReply() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RedisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// deletes key only if it still holds value, so a lock that expired and was taken by another replica isn't released
+const redisReleaseScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+
+// takes the lock key for ttl unless another replica holds it, release gives it up early
+func (c *Redis) lock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, false, err
+	}
+	value := hex.EncodeToString(token)
+	reply, err := c.do(ctx, "SET", key, value, "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	release := func() {
+		if _, err := c.do(context.Background(), "EVAL", redisReleaseScript, "1", key, value); err != nil {
+			log.Println("redis:", err)
+		}
+	}
+	return release, true, nil
+}
+
+// RedisRates is the SharedStore of the replicas using the same Redis server, its keys start with Prefix
+type RedisRates struct {
+	Redis  *Redis
+	Prefix string
+}
+
+// the rates saved in Redis with when they were fetched, Static isn't part of the json of Data
+type sharedRates struct {
+	Fetched int64
+	Static  bool
+	Data    rates.Data
+}
+
+func (s *RedisRates) Lock(ctx context.Context, ttl time.Duration) (func(), bool, error) {
+	return s.Redis.lock(ctx, s.Prefix+"refresh-lock", ttl)
+}
+
+func (s *RedisRates) Load(ctx context.Context) (rates.Data, time.Time, bool, error) {
+	reply, err := s.Redis.do(ctx, "GET", s.Prefix+"rates")
+	if err != nil || reply == nil {
+		return rates.Data{}, time.Time{}, false, err
+	}
+	var shared sharedRates
+	if err := json.Unmarshal([]byte(reply.(string)), &shared); err != nil {
+		return rates.Data{}, time.Time{}, false, err
+	}
+	shared.Data.Static = shared.Static
+	return shared.Data, time.Unix(0, shared.Fetched), true, nil
+}
+
+func (s *RedisRates) Save(ctx context.Context, d rates.Data, fetched time.Time) error {
+	b, err := json.Marshal(sharedRates{fetched.UnixNano(), d.Static, d})
+	if err != nil {
+		return err
+	}
+	_, err = s.Redis.do(ctx, "SET", s.Prefix+"rates", string(b))
+	return err
+}
+
+// a failed fetch of the rates saved in Redis
+type sharedFailure struct {
+	Failed int64
+	Error  string
+}
+
+func (s *RedisRates) LoadFailure(ctx context.Context) (string, time.Time, bool, error) {
+	reply, err := s.Redis.do(ctx, "GET", s.Prefix+"refresh-failure")
+	if err != nil || reply == nil {
+		return "", time.Time{}, false, err
+	}
+	var failure sharedFailure
+	if err := json.Unmarshal([]byte(reply.(string)), &failure); err != nil {
+		return "", time.Time{}, false, err
+	}
+	return failure.Error, time.Unix(0, failure.Failed), true, nil
+}
+
+func (s *RedisRates) SaveFailure(ctx context.Context, msg string, failed time.Time) error {
+	b, err := json.Marshal(sharedFailure{failed.UnixNano(), msg})
+	if err != nil {
+		return err
+	}
+	_, err = s.Redis.do(ctx, "SET", s.Prefix+"refresh-failure", string(b))
+	return err
+}
+
+// the client of the Redis server set by redis_url that replicas share state through, nil if none is set
+var sharedRedis = openSharedRedis()
+
+// returns the client of redis_url, nil if it isn't set or invalid
+func openSharedRedis() *Redis {
+	rawurl := getEnv("redis_url", "")
+	if rawurl == "" {
+		return nil
+	}
+	c, err := newRedis(rawurl)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	return c
+}
+
+// returns p wrapped so only one of the replicas sharing redis_url asks it for the latest rates per
+// shared_refresh_interval (5m), the others use the rates it saved there, p itself if no Redis server is set
+// the keys start with redis_prefix (currconv:), replicas of different deployments on one server need different prefixes
+func withSharedRefresh(p rates.Provider) rates.Provider {
+	if sharedRedis == nil {
+		return p
+	}
+	interval, err := time.ParseDuration(getEnv("shared_refresh_interval", "5m"))
+	if err != nil || interval <= 0 {
+		log.Println("shared_refresh_interval must be a positive duration, e.g. 5m")
+		interval = 5 * time.Minute
+	}
+	log.Println("Sharing refreshes with the replicas using", sharedRedis.Addr)
+	return &rates.Shared{
+		Provider: p,
+		Store:    &RedisRates{sharedRedis, getEnv("redis_prefix", "currconv:")},
+		Interval: interval,
+		LockTTL:  30 * time.Second,
+		// long enough that a provider that is down isn't asked by every replica, short enough to notice when it is back
+		FailureTTL: time.Minute,
+		OnError:    func(err error) { log.Println("warning: shared refresh:", err) },
+	}
+}
+
+// RedisHash stores json encoded values by field in a hash of the shared Redis server, so all replicas see the same ones
+type RedisHash struct {
+	Redis *Redis
+	Key   string
+}
+
+// returns the values of all fields
+func (h *RedisHash) all(ctx context.Context) (map[string]string, error) {
+	reply, err := h.Redis.do(ctx, "HGETALL", h.Key)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	list, _ := reply.([]interface{})
+	for i := 0; i+1 < len(list); i += 2 {
+		field, _ := list[i].(string)
+		value, _ := list[i+1].(string)
+		values[field] = value
+	}
+	return values, nil
+}
+
+// stores v json encoded as value of field
+func (h *RedisHash) set(ctx context.Context, field string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = h.Redis.do(ctx, "HSET", h.Key, field, string(b))
+	return err
+}
+
+// deletes field, returns whether it existed
+func (h *RedisHash) del(ctx context.Context, field string) (bool, error) {
+	reply, err := h.Redis.do(ctx, "HDEL", h.Key, field)
+	return reply == int64(1), err
+}
+
+// returns the number of fields
+func (h *RedisHash) len(ctx context.Context) (int64, error) {
+	reply, err := h.Redis.do(ctx, "HLEN", h.Key)
+	n, _ := reply.(int64)
+	return n, err
+}
+
+// returns a hash named key after redis_prefix in the shared Redis server, nil if none is set
+func sharedHash(key string) *RedisHash {
+	if sharedRedis == nil {
+		return nil
+	}
+	return &RedisHash{sharedRedis, getEnv("redis_prefix", "currconv:") + key}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// largest response body that is cached
+const maxCachedResponse = 256 << 10
+
+// CachedResponse stores a response to replay for identical requests
+type CachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// timestamp of the data the response was made with
+	Timestamp int64
+	Expires   time.Time
+}
+
+// recent responses of the cached routes by method, url, api key, Accept header, markup flag and preferences
+var responseCache = newLRU(1024)
+
+// lookups of responseCache by result: hit, miss or expired, if the response is too old or made with outdated rates
+var responseCacheStats = struct {
+	sync.Mutex
+	lookups map[string]uint64
+}{lookups: make(map[string]uint64)}
+
+// counts a lookup of responseCache with result
+func countResponseLookup(result string) {
+	responseCacheStats.Lock()
+	responseCacheStats.lookups[result]++
+	responseCacheStats.Unlock()
+	statsd.count("response_cache.lookups", 1, "result:"+result)
+}
+
+// returns the lookups of responseCache counted so far by result
+func responseCacheLookups() map[string]uint64 {
+	responseCacheStats.Lock()
+	defer responseCacheStats.Unlock()
+	lookups := make(map[string]uint64, len(responseCacheStats.lookups))
+	for result, n := range responseCacheStats.lookups {
+		lookups[result] = n
+	}
+	return lookups
+}
+
+// ResponseRecorder copies what a handler writes to the client into a CachedResponse
+type ResponseRecorder struct {
+	http.ResponseWriter
+	response CachedResponse
+	// set if the body is too large to be cached
+	tooLarge bool
+}
+
+func (rec *ResponseRecorder) WriteHeader(status int) {
+	if rec.response.Status == 0 {
+		rec.response.Status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *ResponseRecorder) Write(b []byte) (int, error) {
+	if rec.response.Status == 0 {
+		rec.response.Status = http.StatusOK
+	}
+	if len(rec.response.Body)+len(b) > maxCachedResponse {
+		rec.tooLarge = true
+	} else {
+		rec.response.Body = append(rec.response.Body, b...)
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// returns the time responses are cached for, set by response_cache_ttl (5s by default, 0 disables the cache)
+func getResponseCacheTTL() time.Duration {
+	ttl, err := time.ParseDuration(getEnv("response_cache_ttl", "5s"))
+	if err != nil {
+		return 5 * time.Second
+	}
+	return ttl
+}
+
+// wraps h so successful responses are served from memory to identical requests for response_cache_ttl
+// cached responses are dropped as soon as the current data changes, rate limit headers are never replayed
+func cached(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ttl := getResponseCacheTTL()
+		if ttl <= 0 {
+			h(w, r)
+			return
+		}
+
+		timestamp := getCurrentData(r.Context()).Timestamp
+		// clients differ in their markup while it is rolled out and in the preferences of their session
+		markup := strconv.FormatBool(featureEnabled(r, "markup"))
+		prefs := fmt.Sprint(preferencesFor(r))
+		key := strings.Join([]string{r.Method, r.URL.RequestURI(), getAPIKey(r), r.Header.Get("Accept"), markup, prefs}, " ")
+		v, ok := responseCache.get(key)
+		if ok {
+			c := v.(*CachedResponse)
+			if c.Timestamp == timestamp && time.Now().Before(c.Expires) {
+				countResponseLookup("hit")
+				for k, values := range c.Header {
+					w.Header()[k] = values
+				}
+				w.Header().Set("X-Cache", "HIT")
+				w.WriteHeader(c.Status)
+				w.Write(c.Body)
+				return
+			}
+			countResponseLookup("expired")
+		} else {
+			countResponseLookup("miss")
+		}
+
+		rec := &ResponseRecorder{ResponseWriter: w}
+		h(rec, r)
+		if rec.response.Status != http.StatusOK || rec.tooLarge {
+			return
+		}
+		rec.response.Header = w.Header().Clone()
+		for _, k := range []string{"X-Ratelimit-Limit", "X-Ratelimit-Remaining", "X-Ratelimit-Reset"} {
+			rec.response.Header.Del(k)
+		}
+		rec.response.Timestamp = timestamp
+		rec.response.Expires = time.Now().Add(ttl)
+		responseCache.add(key, &rec.response)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Route is a path pattern with the methods it accepts and its handler
+// segments of the pattern written as {name} match any non-empty segment, a final {name...} matches the rest of the path
+type Route struct {
+	Pattern  string
+	Methods  []string
+	Handler  http.HandlerFunc
+	segments []string
+}
+
+// Router dispatches requests to the route with the most specific pattern matching their path
+type Router struct {
+	routes []Route
+}
+
+// key of the path parameters in the request context
+type paramsKey struct{}
+
+// registers h for requests to pattern that use one of methods
+func (rt *Router) Handle(pattern string, h http.HandlerFunc, methods ...string) {
+	rt.routes = append(rt.routes, Route{pattern, methods, h, strings.Split(pattern, "/")[1:]})
+}
+
+// returns the parameters of the path split into segments if it matches the route
+func (route Route) match(segments []string) (map[string]string, bool) {
+	params := make(map[string]string)
+	for i, s := range route.segments {
+		if i >= len(segments) {
+			return nil, false
+		}
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "...}") {
+			params[s[1:len(s)-4]] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[s[1:len(s)-1]] = segments[i]
+			continue
+		}
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, len(segments) == len(route.segments)
+}
+
+// ranks patterns by their literal segments, parameters rank below literals and a final {name...} lowest
+func (route Route) specificity() int {
+	n := 0
+	for _, s := range route.segments {
+		switch {
+		case strings.HasSuffix(s, "...}"):
+		case strings.HasPrefix(s, "{"):
+			n++
+		default:
+			n += 2
+		}
+	}
+	return n
+}
+
+// returns the routes sharing the most specific pattern that matches path and the path parameters
+func (rt *Router) lookup(path string) ([]Route, map[string]string) {
+	segments := strings.Split(path, "/")[1:]
+	var best []Route
+	var bestParams map[string]string
+	for _, route := range rt.routes {
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		switch {
+		case len(best) == 0 || route.specificity() > best[0].specificity():
+			best, bestParams = []Route{route}, params
+		case route.Pattern == best[0].Pattern:
+			best = append(best, route)
+		}
+	}
+	return best, bestParams
+}
+
+// serves r with the matching route, redirects /path to /path/ if only the latter exists
+// writes a 404 if no route matches and a 405 if none of the matching routes accepts the method
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	routes, params := rt.lookup(r.URL.Path)
+	if len(routes) == 0 {
+		if routes, _ := rt.lookup(r.URL.Path + "/"); len(routes) > 0 && !strings.HasSuffix(r.URL.Path, "/") {
+			u := *r.URL
+			u.Path += "/"
+			http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
+			return
+		}
+		notFound(w, r)
+		return
+	}
+
+	setRoutePattern(r, routes[0].Pattern)
+	var allowed []string
+	for _, route := range routes {
+		for _, m := range route.Methods {
+			if m == r.Method {
+				route.Handler(w, r.WithContext(context.WithValue(r.Context(), paramsKey{}, params)))
+				return
+			}
+		}
+		allowed = append(allowed, route.Methods...)
+	}
+	allowMethods(w, r, allowed...)
+}
+
+// returns the path parameter name of r, e.g. from for the pattern /api/rate/{from}/{to}
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
+// returns the path parameter name of r or else the url parameter with the same name
+func getParam(r *http.Request, name string) string {
+	if v := pathParam(r, name); v != "" {
+		return v
+	}
+	return r.URL.Query().Get(name)
+}
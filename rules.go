@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"currconv/pkg/convert"
+)
+
+// fee and rounding rules applied to every conversion, read from the file set by rules_file
+var conversionRules = struct {
+	sync.Mutex
+	rules convert.Rules
+}{}
+
+// reads the rules from the file set by rules_file, no rules if it isn't set
+func loadRules() (convert.Rules, error) {
+	path := getEnv("rules_file", "")
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return convert.ParseRules(f)
+}
+
+// replaces the rules applied to conversions
+func setRules(rules convert.Rules) {
+	conversionRules.Lock()
+	defer conversionRules.Unlock()
+
+	conversionRules.rules = rules
+}
+
+// returns m with the fees of the rules matching the conversion of amount from from into to at rate added
+// and the multiple the result must be rounded to
+func applyRules(from string, to string, rate float64, amount float64, m convert.Markup) (convert.Markup, float64) {
+	conversionRules.Lock()
+	rules := conversionRules.rules
+	conversionRules.Unlock()
+
+	a := rules.Apply(convert.Conditions{From: from, To: to, Amount: amount, Converted: amount * rate})
+	m.Percent += a.Percent
+	m.Fee += a.Fee
+	return m, a.Round
+}
+
+// reads the rules file again so changed rules apply without a restart, the old rules are kept if it is invalid
+func adminRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := loadRules()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	setRules(rules)
+	log.Println("Loaded", len(rules), "conversion rules")
+	writeJSON(w, map[string]int{"rules": len(rules)})
+}
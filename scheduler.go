@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schedule stores the allowed values of each field of a cron expression
+type Schedule struct {
+	Minute, Hour, DayOfMonth, Month, DayOfWeek map[int]bool
+	// day of month and day of week are combined with OR if both are restricted, as in cron
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// Job is a task that is run by the scheduler whenever its schedule matches
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Run      func()
+}
+
+// parses a single cron field (e.g. "*", "5", "1-5", "*/15", "1,15,30") into its allowed values between min and max
+func parseField(field string, min int, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s < 1 {
+				return nil, errors.New("invalid step in cron field " + field)
+			}
+			step = s
+			part = part[:i]
+		}
+
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			start, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, errors.New("invalid value in cron field " + field)
+			}
+			end = start
+			if len(bounds) == 2 {
+				end, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, errors.New("invalid range in cron field " + field)
+				}
+			} else if step > 1 {
+				// "5/15" means every 15 starting at 5
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, errors.New("cron field " + field + " out of range")
+		}
+
+		for v := start; v <= end; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// parses a cron expression with the five fields minute, hour, day of month, month and day of week
+func parseSchedule(expr string) (Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return Schedule{}, errors.New("cron expression must have 5 fields: " + expr)
+	}
+
+	var s Schedule
+	var err error
+	if s.Minute, err = parseField(fields[0], 0, 59); err != nil {
+		return s, err
+	}
+	if s.Hour, err = parseField(fields[1], 0, 23); err != nil {
+		return s, err
+	}
+	if s.DayOfMonth, err = parseField(fields[2], 1, 31); err != nil {
+		return s, err
+	}
+	if s.Month, err = parseField(fields[3], 1, 12); err != nil {
+		return s, err
+	}
+	if s.DayOfWeek, err = parseField(fields[4], 0, 7); err != nil {
+		return s, err
+	}
+	// both 0 and 7 mean sunday
+	if s.DayOfWeek[7] {
+		s.DayOfWeek[0] = true
+	}
+	s.anyDayOfMonth = fields[2] == "*"
+	s.anyDayOfWeek = fields[4] == "*"
+	return s, nil
+}
+
+// checks whether the schedule allows the day of t
+func (s Schedule) matchesDay(t time.Time) bool {
+	dom := s.DayOfMonth[t.Day()]
+	dow := s.DayOfWeek[int(t.Weekday())]
+	switch {
+	case s.anyDayOfMonth && s.anyDayOfWeek:
+		return true
+	case s.anyDayOfMonth:
+		return dow
+	case s.anyDayOfWeek:
+		return dom
+	}
+	return dom || dow
+}
+
+// returns the first time after t matching the schedule
+// returns the zero time if nothing matches within the next 5 years
+func (s Schedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !s.Month[int(t.Month())] || !s.matchesDay(t) {
+			// skip to the start of the next day
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.Hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.Minute[t.Minute()] {
+			return t
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// schedules of the jobs started by startScheduler by their name
+var schedules = struct {
+	sync.Mutex
+	m map[string]Schedule
+}{m: make(map[string]Schedule)}
+
+// returns the next time the job name runs, zero if it isn't scheduled
+func nextRun(name string) time.Time {
+	schedules.Lock()
+	s, ok := schedules.m[name]
+	schedules.Unlock()
+	if !ok {
+		return time.Time{}
+	}
+	return s.next(time.Now())
+}
+
+// runs job every time its schedule matches
+func runJob(job Job) {
+	for {
+		next := job.Schedule.next(time.Now())
+		if next.IsZero() {
+			log.Println("Job", job.Name, "never runs again")
+			return
+		}
+		time.Sleep(time.Until(next))
+
+		log.Println("Running job", job.Name)
+		job.Run()
+	}
+}
+
+// creates a job whose schedule is read from the cron_<name> environment variable
+// the job is disabled if the variable is set to "off"
+func makeJob(name string, defaultSchedule string, run func()) (Job, bool) {
+	expr := getEnv("cron_"+name, defaultSchedule)
+	if expr == "off" {
+		return Job{}, false
+	}
+
+	s, err := parseSchedule(expr)
+	if err != nil {
+		log.Println("Job", name, "disabled:", err)
+		return Job{}, false
+	}
+	return Job{name, s, run}, true
+}
+
+// returns the number of days set by the environment variable key, 0 if it is not set or invalid
+func getRetentionDays(key string) int {
+	days, err := strconv.Atoi(getEnv(key, "0"))
+	if err != nil {
+		log.Println(err)
+		return 0
+	}
+	return days
+}
+
+// deletes local snapshots older than snapshot_retention_days
+// and backed up snapshots older than backup_retention_days (0 keeps them forever), which only the leader prunes
+func cleanup() {
+	if days := getRetentionDays("snapshot_retention_days"); days > 0 {
+		pruneSnapshots(time.Now().AddDate(0, 0, -days))
+	}
+	if days := getRetentionDays("backup_retention_days"); days > 0 && isLeader() {
+		pruneBackups(time.Now().AddDate(0, 0, -days))
+	}
+}
+
+// starts all background jobs, every replica refreshes its rates and cleans up its snapshots
+// while uploading snapshots and sending digests is left to the leader, the jobs are those of the server of ctx
+func startScheduler(ctx context.Context) {
+	jobs := []struct {
+		name     string
+		schedule string
+		run      func()
+	}{
+		{"refresh", "0 * * * *", func() { refreshCurrentData(ctx) }},
+		{"snapshot", "55 23 * * *", leaderOnly(func() { backupSnapshot(getCurrentData(ctx)) })},
+		{"digest", "0 7 * * *", leaderOnly(func() { sendDigests(ctx) })},
+		{"cleanup", "30 0 * * *", cleanup},
+	}
+
+	for _, j := range jobs {
+		job, ok := makeJob(j.name, j.schedule, j.run)
+		if ok {
+			schedules.Lock()
+			schedules.m[job.Name] = job.Schedule
+			schedules.Unlock()
+			go runJob(job)
+		}
+	}
+}
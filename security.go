@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// policy for all pages unless content_security_policy is set
+// inline scripts are allowed because the pages preselect the currencies with them
+const defaultCSP = "default-src 'self'; script-src 'self' 'unsafe-inline'; object-src 'none'; base-uri 'self'; form-action 'self'"
+
+// checks whether r was sent over TLS, directly or to a proxy in front of the server
+// X-Forwarded-Proto is only believed if trust_proxy is set, as with the client address, clients could send it
+// themselves otherwise, the last protocol in it is the one added by the proxy in front of the server
+func isTLS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	if getEnv("trust_proxy", "false") != "true" {
+		return false
+	}
+	protocols := strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")
+	return strings.TrimSpace(protocols[len(protocols)-1]) == "https"
+}
+
+// wraps h so every response carries the security headers
+// pages may be framed as set by frame_options (DENY or SAMEORIGIN), except /embed which may be framed
+// by the pages in embed_frame_ancestors (any page by default)
+func securityHeaders(h http.Handler) http.Handler {
+	csp := getEnv("content_security_policy", defaultCSP)
+	referrer := getEnv("referrer_policy", "strict-origin-when-cross-origin")
+	frameOptions := strings.ToUpper(getEnv("frame_options", "DENY"))
+	frameAncestors := "'none'"
+	if frameOptions == "SAMEORIGIN" {
+		frameAncestors = "'self'"
+	}
+	embedAncestors := getEnv("embed_frame_ancestors", "*")
+	hsts := "max-age=" + getEnv("hsts_max_age", "31536000")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", referrer)
+		if r.URL.Path == "/embed" {
+			header.Set("Content-Security-Policy", csp+"; frame-ancestors "+embedAncestors)
+		} else {
+			header.Set("Content-Security-Policy", csp+"; frame-ancestors "+frameAncestors)
+			header.Set("X-Frame-Options", frameOptions)
+		}
+		if isTLS(r) {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
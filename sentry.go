@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+var sentryClient = &http.Client{Timeout: 5 * time.Second}
+
+// the same error is reported at most once per sentryThrottle, so an outage doesn't flood the error tracker
+const sentryThrottle = time.Minute
+
+// when each error message was last reported
+var sentryReported = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+// headers left out of reports because they carry credentials
+var sentryHiddenHeaders = []string{"Authorization", "Cookie", "X-Api-Key", "X-Csrf-Token"}
+
+// url parameters left out of reports because they carry credentials
+var sentryHiddenParams = []string{"api_key", "access_token", "token", "password", "secret"}
+
+// SentryEvent is an error report in the format of the Sentry store API, which compatible trackers accept as well
+type SentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Message     string            `json:"message"`
+	Exception   *SentryException  `json:"exception,omitempty"`
+	Request     *SentryRequest    `json:"request,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// SentryException lists the error of an event with the stack it was raised on
+type SentryException struct {
+	Values []SentryExceptionValue `json:"values"`
+}
+
+type SentryExceptionValue struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Stacktrace *SentryStacktrace `json:"stacktrace,omitempty"`
+}
+
+type SentryStacktrace struct {
+	// oldest call first
+	Frames []SentryFrame `json:"frames"`
+}
+
+type SentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+}
+
+// SentryRequest is the request an event happened in
+type SentryRequest struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// returns the store url and auth header of the dsn set by sentry_dsn, e.g. https://key@sentry.example.com/42
+// ok is false if no dsn is set or it is invalid
+func sentryEndpoint() (string, string, bool) {
+	dsn := os.Getenv("sentry_dsn")
+	if dsn == "" {
+		return "", "", false
+	}
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.Host == "" {
+		log.Println("sentry_dsn must be given as https://key@host/project")
+		return "", "", false
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 || path[i+1:] == "" {
+		log.Println("sentry_dsn has no project id")
+		return "", "", false
+	}
+	auth := "Sentry sentry_version=7, sentry_client=currconv/1.0, sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	return u.Scheme + "://" + u.Host + path[:i] + "/api/" + path[i+1:] + "/store/", auth, true
+}
+
+// returns the request context of an event in r, without credentials
+func sentryRequest(r *http.Request) *SentryRequest {
+	query := r.URL.Query()
+	for _, name := range sentryHiddenParams {
+		query.Del(name)
+	}
+	u := *r.URL
+	u.Scheme, u.Host, u.RawQuery = "http", r.Host, ""
+	if isTLS(r) {
+		u.Scheme = "https"
+	}
+	headers := make(map[string]string, len(r.Header))
+	for name := range r.Header {
+		headers[name] = r.Header.Get(name)
+	}
+	for _, name := range sentryHiddenHeaders {
+		delete(headers, http.CanonicalHeaderKey(name))
+	}
+	return &SentryRequest{URL: u.String(), Method: r.Method, QueryString: query.Encode(), Headers: headers}
+}
+
+// returns the stack of the caller skip frames up, oldest call first
+func sentryStack(skip int) *SentryStacktrace {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var list []SentryFrame
+	for {
+		f, more := frames.Next()
+		list = append([]SentryFrame{{f.Function, f.File, f.Line}}, list...)
+		if !more {
+			break
+		}
+	}
+	return &SentryStacktrace{list}
+}
+
+// sends a report of message at level (error or fatal) with the type of the error, the stack and r if they aren't nil
+// nothing is sent if sentry_dsn isn't set or the message was reported within sentryThrottle
+func report(level string, errType string, message string, stack *SentryStacktrace, r *http.Request, tags map[string]string) {
+	endpoint, auth, ok := sentryEndpoint()
+	if !ok {
+		return
+	}
+	sentryReported.Lock()
+	last, seen := sentryReported.m[message]
+	if seen && time.Since(last) < sentryThrottle {
+		sentryReported.Unlock()
+		return
+	}
+	sentryReported.m[message] = time.Now()
+	sentryReported.Unlock()
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	hostname, _ := os.Hostname()
+	e := SentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       level,
+		Platform:    "go",
+		Logger:      "currconv",
+		ServerName:  hostname,
+		Environment: getEnv("sentry_environment", "production"),
+		Release:     os.Getenv("sentry_release"),
+		Message:     message,
+		Exception:   &SentryException{[]SentryExceptionValue{{errType, message, stack}}},
+		Tags:        tags,
+	}
+	if r != nil {
+		e.Request = sentryRequest(r)
+	}
+
+	go func() {
+		body, err := json.Marshal(e)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Sentry-Auth", auth)
+		resp, err := sentryClient.Do(req)
+		if err != nil {
+			log.Println("sentry:", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Println("sentry: report rejected:", resp.Status)
+		}
+	}()
+}
+
+// reports err, e.g. a failure to decode the rates of the provider, r is nil outside of requests
+func reportError(err error, r *http.Request) {
+	report("error", fmt.Sprintf("%T", err), err.Error(), sentryStack(1), r, nil)
+}
+
+// key of the error a request failed with in its context
+type requestErrorKey struct{}
+
+// notes err as the cause of the failure of r, reported if r is answered with a 5xx status
+func noteRequestError(r *http.Request, err error) {
+	if cause, ok := r.Context().Value(requestErrorKey{}).(*error); ok {
+		*cause = err
+	}
+}
+
+// returns the route pattern r was matched to, set by measureRequests and the router
+func requestRoute(r *http.Request) string {
+	if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+		return *route
+	}
+	return "unmatched"
+}
+
+// wraps h so panics and 5xx responses are reported with the request to the tracker set by sentry_dsn
+// panics are passed on after being reported, so the server still logs them
+func reportErrors(h http.Handler) http.Handler {
+	if _, _, ok := sentryEndpoint(); !ok {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var cause error
+		r = r.WithContext(context.WithValue(r.Context(), requestErrorKey{}, &cause))
+		rec := &StatusRecorder{ResponseWriter: w}
+		defer func() {
+			if p := recover(); p != nil {
+				if p != http.ErrAbortHandler {
+					report("fatal", "panic", fmt.Sprint(p), sentryStack(2), r, map[string]string{"route": requestRoute(r)})
+				}
+				panic(p)
+			}
+		}()
+		h.ServeHTTP(rec, r)
+
+		if rec.status < http.StatusInternalServerError {
+			return
+		}
+		route := requestRoute(r)
+		tags := map[string]string{"route": route, "status": fmt.Sprint(rec.status)}
+		if cause != nil {
+			report("error", fmt.Sprintf("%T", cause), cause.Error(), nil, r, tags)
+			return
+		}
+		report("error", "http", fmt.Sprintf("%s %s answered %d %s", r.Method, route, rec.status, http.StatusText(rec.status)), nil, r, tags)
+	})
+}
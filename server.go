@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"currconv/pkg/convert"
+	"currconv/pkg/rates"
+)
+
+// Config stores the settings of a server that NewServer applies, other settings are read from their environment variables
+type Config struct {
+	// age after which the cached rates are refreshed
+	MaxAge time.Duration
+	// asked for rates if the provider is unavailable, nil if there is none
+	Fallback rates.Provider
+	// keys accepted by the API, which can be used without a key if there are none
+	APIKeys map[string]APIKey
+	// fee and rounding rules applied to every conversion
+	Rules convert.Rules
+	// whether the refresh, snapshot, digest and cleanup jobs are scheduled
+	Jobs bool
+	// directory the templates of the pages are read from
+	TemplateDir string
+}
+
+// Store holds the state a server keeps across restarts, every field must be set
+type Store struct {
+	History    *History
+	Digests    *Digests
+	Permalinks *Permalinks
+	// virtual currencies defined by an admin
+	VirtualCurrencies *VirtualCurrencies
+	Alerts            *Alerts
+}
+
+// returns the configuration of the server started by main: rates refreshed hourly, the rates file as fallback,
+// the api keys set by api_keys and api_key_file, the rules in rules_file, the scheduled jobs and the templates in
+// template_dir (the working directory by default)
+// returns an error if the rules file can't be read, so no conversion is made without its fees
+func configFromEnv() (Config, error) {
+	rules, err := loadRules()
+	if err != nil {
+		return Config{}, err
+	}
+	cfg := Config{MaxAge: time.Hour, APIKeys: loadAPIKeys(), Rules: rules, Jobs: true, TemplateDir: getEnv("template_dir", ".")}
+	if ratesFile != nil {
+		cfg.Fallback = ratesFile
+	}
+	return cfg, nil
+}
+
+// returns the state stored in the files set by history_file, digest_file, permalink_file, virtual_currency_file and alerts_file
+// digest subscribers and alerts are stored in the shared Redis server instead if redis_url is set, since only the leader
+// sends them
+func loadStore() Store {
+	store := Store{
+		History:           loadHistory(getEnv("history_file", "history.json")),
+		Digests:           loadDigests(getEnv("digest_file", "digests.json")),
+		Permalinks:        loadPermalinks(getEnv("permalink_file", "permalinks.json")),
+		VirtualCurrencies: loadVirtualCurrencies(getEnv("virtual_currency_file", "currencies.json")),
+		Alerts:            loadAlerts(getEnv("alerts_file", "alerts.json")),
+	}
+	store.Digests.shared = sharedHash("digests")
+	store.Alerts.shared = sharedHash("alerts")
+	if sharedRedis != nil && (len(store.Digests.Subscribers) > 0 || len(store.Alerts.Alerts) > 0) {
+		log.Println("warning: the subscribers in digest_file and the alerts in alerts_file are ignored, they are stored in redis_url")
+	}
+	return store
+}
+
+// templates of the pages, read from Config.TemplateDir
+var templateFiles = []string{"index.html", "convert.html", "contact.html", "about.html", "digest.html", "embed.html", "shared.html", "budget.html", "bulk.html", "strength.html", "settings.html", "compare.html", "chart.html", "overlay.html", "alerts.html", "admin.html", "error.html"}
+
+// Server serves the whole site and API with the rates of its provider and the state of its store
+// its handlers and jobs find it in their context, see serverFrom
+type Server struct {
+	provider rates.Provider
+	// caches the latest rates, only refreshing them once they are older than Config.MaxAge to limit API requests made
+	cache             *rates.Cache
+	history           *History
+	digests           *Digests
+	permalinks        *Permalinks
+	virtualCurrencies *VirtualCurrencies
+	alerts            *Alerts
+	// maps api keys to their settings
+	apiKeys map[string]APIKey
+	// the templates of the pages, cloned with the functions of a locale on first use by templatesFor
+	templates          *template.Template
+	localizedTemplates struct {
+		sync.Mutex
+		m map[Locale]*template.Template
+	}
+	// fetches of past days from the provider by date, so concurrent requests for the same missing day cause a single
+	// upstream request, failed fetches are kept to answer requests for the day until they may be retried
+	historicalFetches struct {
+		sync.Mutex
+		m map[string]*HistoricalFetch
+	}
+	// held while /admin/backfill fetches past days into the history
+	backfilling sync.Mutex
+	handler     http.Handler
+}
+
+type serverKey struct{}
+
+// returns the server whose handler or job ctx was passed to
+func serverFrom(ctx context.Context) *Server {
+	return ctx.Value(serverKey{}).(*Server)
+}
+
+// returns ctx carrying s for the handlers and jobs of s
+func (s *Server) with(ctx context.Context) context.Context {
+	return context.WithValue(ctx, serverKey{}, s)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r.WithContext(s.with(r.Context())))
+}
+
+// returns the server of the whole site and API with the rates of p and the state in store
+// the rates are fetched once before it returns, an error is returned if the templates can't be read
+// the rules, feature flags and leader election are still set for the whole process
+func NewServer(cfg Config, p rates.Provider, store Store) (*Server, error) {
+	s := &Server{
+		provider:          p,
+		cache:             rates.NewCache(p, cfg.MaxAge),
+		history:           store.History,
+		digests:           store.Digests,
+		permalinks:        store.Permalinks,
+		virtualCurrencies: store.VirtualCurrencies,
+		alerts:            store.Alerts,
+		apiKeys:           cfg.APIKeys,
+	}
+	s.localizedTemplates.m = make(map[Locale]*template.Template)
+	s.historicalFetches.m = make(map[string]*HistoricalFetch)
+
+	ctx := s.with(context.Background())
+	paths := make([]string, len(templateFiles))
+	for i, name := range templateFiles {
+		paths[i] = filepath.Join(cfg.TemplateDir, name)
+	}
+	var err error
+	s.templates, err = template.New("").Funcs(templateFuncs(ctx, Locale{Language: defaultLanguage})).ParseFiles(paths...)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.OnRefresh = func(d rates.Data) { onRefresh(ctx, d) }
+	instrumentCache(s.cache)
+	if cfg.Fallback != nil && cfg.Fallback != p {
+		s.cache.Fallback = instrument("fallback", cfg.Fallback)
+	}
+	setRules(cfg.Rules)
+
+	startLeaderElection()
+	refreshCurrentData(ctx)
+	if cfg.Jobs {
+		startScheduler(ctx)
+	}
+
+	rt := &Router{}
+	rt.Handle("/", csrfProtect(indexHandler), readMethods...)
+	rt.Handle("/convert/", csrfProtect(convertHandler), readMethods...)
+	rt.Handle("/convert/{from}/{to}/{value}", csrfProtect(convertHandler), readMethods...)
+	rt.Handle("/redirect/", csrfProtect(redirectHandler), http.MethodPost)
+	rt.Handle("/receipt/", receiptHandler, readMethods...)
+	rt.Handle("/share/", csrfProtect(shareHandler), http.MethodPost)
+	rt.Handle("/c/{id}", permalinkHandler, readMethods...)
+	rt.Handle("/about/", makeGenericHandler("about"), readMethods...)
+	rt.Handle("/contact/", makeGenericHandler("contact"), readMethods...)
+	rt.Handle("/digest/", feature("digest", csrfProtect(digestHandler)), http.MethodGet, http.MethodHead, http.MethodPost)
+	rt.Handle("/budget/", budgetHandler, readMethods...)
+	rt.Handle("/bulk/", feature("bulk", csrfProtect(bulkHandler)), http.MethodGet, http.MethodHead, http.MethodPost)
+	rt.Handle("/strength/", strengthHandler, readMethods...)
+	rt.Handle("/compare/", compareHandler, readMethods...)
+	rt.Handle("/chart/{from}/{to}", chartHandler, readMethods...)
+	rt.Handle("/overlay/", overlayHandler, readMethods...)
+	rt.Handle("/settings/", csrfProtect(settingsHandler), http.MethodGet, http.MethodHead, http.MethodPost)
+	rt.Handle("/alerts/", alertsHandler, readMethods...)
+	rt.Handle("/sw.js", serviceWorkerHandler, readMethods...)
+	rt.Handle("/api/convert", apiHandler(cached(apiConvertHandler)), readMethods...)
+	rt.Handle("/api/convert/multi", apiHandler(cached(apiMultiConvertHandler)), readMethods...)
+	rt.Handle("/api/rate", apiHandler(cached(apiRateHandler)), readMethods...)
+	rt.Handle("/api/rate/{from}/{to}", apiHandler(cached(apiRateHandler)), readMethods...)
+	rt.Handle("/api/rates", apiHandler(cached(apiRatesHandler)), readMethods...)
+	rt.Handle("/api/quote", apiHandler(idempotent(apiQuoteHandler)), http.MethodPost)
+	rt.Handle("/api/historical", apiHandler(apiHistoricalHandler), readMethods...)
+	rt.Handle("/api/timeseries", apiHandler(apiTimeseriesHandler), readMethods...)
+	rt.Handle("/api/overlay", apiHandler(apiOverlayHandler), readMethods...)
+	rt.Handle("/api/sma", apiHandler(apiAverageHandler), readMethods...)
+	rt.Handle("/api/forecast", apiHandler(apiForecastHandler), readMethods...)
+	rt.Handle("/api/portfolio", apiHandler(apiPortfolioHandler), http.MethodPost)
+	rt.Handle("/api/trending", apiHandler(apiTrendingHandler), readMethods...)
+	rt.Handle("/api/strength", apiHandler(apiStrengthHandler), readMethods...)
+	rt.Handle("/api/bulk", apiHandler(feature("bulk", idempotent(apiBulkHandler))), http.MethodPost)
+	rt.Handle("/api/budget", apiHandler(apiBudgetHandler), readMethods...)
+	rt.Handle("/api/snapshots/", apiHandler(snapshotsHandler), readMethods...)
+	rt.Handle("/api/snapshots/{timestamp}", apiHandler(snapshotsHandler), readMethods...)
+	rt.Handle("/api/widget", apiHandler(cached(apiWidgetHandler)), readMethods...)
+	rt.Handle("/api/currencies", apiHandler(apiCurrenciesHandler), readMethods...)
+	rt.Handle("/api/currencies/groups", apiHandler(apiGroupsHandler), readMethods...)
+	rt.Handle("/api/status", apiHandler(apiStatusHandler), readMethods...)
+	rt.Handle("/api/push/key", apiHandler(apiPushKeyHandler), readMethods...)
+	rt.Handle("/api/alerts", apiHandler(idempotent(apiCreateAlertHandler)), http.MethodPost)
+	rt.Handle("/api/alerts/{id}", apiHandler(apiDeleteAlertHandler), http.MethodDelete)
+	rt.Handle("/healthz", healthzHandler, readMethods...)
+	rt.Handle("/readyz", readyzHandler, readMethods...)
+	rt.Handle("/widget.js", widgetScriptHandler, readMethods...)
+	rt.Handle("/embed", embedHandler, readMethods...)
+	rt.Handle("/export/rates.csv", exportRatesHandler, readMethods...)
+	rt.Handle("/export/history.csv", exportHistoryHandler, readMethods...)
+	rt.Handle("/export/history.parquet", exportHistoryParquetHandler, readMethods...)
+	rt.Handle("/export/rates.xlsx", exportXLSXHandler, readMethods...)
+	rt.Handle("/export/archive", exportArchiveHandler, readMethods...)
+
+	rt.Handle("/admin/{path...}", adminOnly(notFound), http.MethodGet, http.MethodHead, http.MethodPost)
+	rt.Handle("/admin/", adminOnly(adminDashboardHandler), readMethods...)
+	rt.Handle("/admin/refresh", adminOnly(adminRefreshHandler), http.MethodPost)
+	rt.Handle("/admin/cleanup", adminOnly(adminCleanupHandler), http.MethodPost)
+	rt.Handle("/admin/backfill", adminOnly(adminBackfillHandler), http.MethodPost)
+	rt.Handle("/admin/rules", adminOnly(adminRulesHandler), http.MethodPost)
+	rt.Handle("/admin/currencies", adminOnly(adminCurrenciesHandler), http.MethodGet, http.MethodHead, http.MethodPost)
+	rt.Handle("/admin/currencies/{code}", adminOnly(adminDeleteCurrencyHandler), http.MethodDelete)
+	rt.Handle("/admin/features", adminOnly(adminFeaturesHandler), http.MethodGet, http.MethodHead, http.MethodPost)
+	rt.Handle("/debug/{path...}", adminOnly(notFound), http.MethodGet, http.MethodHead, http.MethodPost)
+	rt.Handle("/debug/runtime", adminOnly(debugRuntimeHandler), readMethods...)
+	rt.Handle("/metrics", adminOnly(metricsHandler), readMethods...)
+
+	rt.Handle("/static/{file...}", staticHandler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static")))), readMethods...)
+
+	startStatsD(ctx)
+	s.handler = measureRequests(reportErrors(securityHeaders(ipFilter(limitRequests(timeoutRequests(withSessions(warnDeprecations(rt))))))))
+	return s, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"currconv/pkg/rates"
+)
+
+// returns a server with the mock rates and its state in a temporary directory
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("snapshot_dir", filepath.Join(dir, "snapshots"))
+	store := Store{
+		History:           loadHistory(filepath.Join(dir, "history.json")),
+		Digests:           loadDigests(filepath.Join(dir, "digests.json")),
+		Permalinks:        loadPermalinks(filepath.Join(dir, "permalinks.json")),
+		VirtualCurrencies: loadVirtualCurrencies(filepath.Join(dir, "currencies.json")),
+		Alerts:            loadAlerts(filepath.Join(dir, "alerts.json")),
+	}
+	s, err := NewServer(Config{MaxAge: time.Hour, TemplateDir: "."}, rates.NewMock(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestAPIConvert(t *testing.T) {
+	s := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/convert?from=EUR&to=USD&amount=100", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", w.Code, w.Body)
+	}
+	var c Conversion
+	if err := json.Unmarshal(w.Body.Bytes(), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.From != "EUR" || c.To != "USD" || c.Amount != 100 || math.Abs(c.Result-c.Rate*100) > 0.01 {
+		t.Errorf("conversion = %+v, want 100 EUR in USD at its rate", c)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/convert?from=EUR&to=XYZ&amount=100", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unknown currency: status %d, want 400", w.Code)
+	}
+}
+
+func TestInvalidAmounts(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, amount := range []string{"NaN", "Inf", "-Inf", "-1", "0", "abc"} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/convert?from=EUR&to=USD&amount="+amount, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("/api/convert with amount %s: status %d, want 400", amount, w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/convert/EUR/USD/"+amount, nil)
+		r.Header.Set("Accept", "application/json")
+		s.ServeHTTP(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("/convert/ with amount %s: status %d, want 400", amount, w.Code)
+		}
+	}
+}
+
+func TestCheckConversionForm(t *testing.T) {
+	ctx := newTestServer(t).with(context.Background())
+
+	for _, value := range []string{"NaN", "Inf", "-1", "0", "abc"} {
+		if errs := checkConversionForm(ctx, defaultLanguage, "EUR", "USD", value, ""); errs.Value == "" {
+			t.Errorf("amount %s was accepted", value)
+		}
+	}
+	if errs := checkConversionForm(ctx, defaultLanguage, "EUR", "USD", "10", ""); errs.any() {
+		t.Errorf("valid conversion has errors %+v", errs)
+	}
+}
+
+func TestConvertPage(t *testing.T) {
+	s := newTestServer(t)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/convert/EUR/USD/100", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), "USD") {
+		t.Error("the conversion page doesn't show the target currency")
+	}
+}
+
+func TestServersDontShareState(t *testing.T) {
+	t.Setenv("admin_token", "secret")
+	first, second := newTestServer(t), newTestServer(t)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/admin/currencies", strings.NewReader("code=TST&name=Test&value=1 EUR"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Authorization", "Bearer secret")
+	first.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200: %s", w.Code, w.Body)
+	}
+
+	if _, ok := first.virtualCurrencies.get("TST"); !ok {
+		t.Error("the virtual currency wasn't added to the first server")
+	}
+	if _, ok := second.virtualCurrencies.get("TST"); ok {
+		t.Error("the virtual currency of the first server was added to the second")
+	}
+}
+
+func TestAdminBasicAuthNeedsHeader(t *testing.T) {
+	t.Setenv("admin_user", "admin")
+	t.Setenv("admin_password", "secret")
+	s := newTestServer(t)
+
+	for _, header := range []string{"", "XMLHttpRequest"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/admin/cleanup", nil)
+		r.SetBasicAuth("admin", "secret")
+		if header != "" {
+			r.Header.Set("X-Requested-With", header)
+		}
+		s.ServeHTTP(w, r)
+		want := http.StatusForbidden
+		if header != "" {
+			want = http.StatusNoContent
+		}
+		if w.Code != want {
+			t.Errorf("X-Requested-With %q: status %d, want %d", header, w.Code, want)
+		}
+	}
+}
+
+func TestForwardedProtoNeedsTrustProxy(t *testing.T) {
+	for _, trust := range []string{"false", "true"} {
+		t.Setenv("trust_proxy", trust)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Forwarded-Proto", "https")
+		if got, want := isTLS(r), trust == "true"; got != want {
+			t.Errorf("trust_proxy %s: isTLS %v, want %v", trust, got, want)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"currconv/pkg/rates"
+)
+
+// services are only managed on Windows, elsewhere the working directory stays as it is
+func enterServiceDir() string {
+	return ""
+}
+
+// exits with an error, services are only supported on Windows
+// on Linux the server is run as systemd unit, optionally with socket activation
+func serviceCommand(p rates.Provider, args []string) {
+	fmt.Fprintln(os.Stderr, "services are only supported on Windows, use a systemd unit instead")
+	os.Exit(2)
+}
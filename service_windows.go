@@ -0,0 +1,393 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"currconv/pkg/rates"
+)
+
+// functions of the service control manager (SCM) in advapi32.dll
+var (
+	advapi32                         = syscall.NewLazyDLL("advapi32.dll")
+	procOpenSCManager                = advapi32.NewProc("OpenSCManagerW")
+	procCreateService                = advapi32.NewProc("CreateServiceW")
+	procOpenService                  = advapi32.NewProc("OpenServiceW")
+	procDeleteService                = advapi32.NewProc("DeleteService")
+	procCloseServiceHandle           = advapi32.NewProc("CloseServiceHandle")
+	procStartService                 = advapi32.NewProc("StartServiceW")
+	procControlService               = advapi32.NewProc("ControlService")
+	procQueryServiceStatus           = advapi32.NewProc("QueryServiceStatus")
+	procStartServiceCtrlDispatcher   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerEx = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus             = advapi32.NewProc("SetServiceStatus")
+	procRegSetValueEx                = advapi32.NewProc("RegSetValueExW")
+	procChangeServiceConfig2         = advapi32.NewProc("ChangeServiceConfig2W")
+)
+
+// returned by service run if the process wasn't started by the SCM
+var errNotStartedBySCM = errors.New("the service must be started by the service control manager, use service start")
+
+// constants of the SCM API
+const (
+	scManagerAllAccess = 0xF003F
+
+	serviceQueryStatus = 0x0004
+	serviceStart       = 0x0010
+	serviceStop        = 0x0020
+	serviceDelete      = 0x10000
+	serviceAllAccess   = 0xF01FF
+
+	serviceWin32OwnProcess = 0x10
+	serviceAutoStart       = 2
+	serviceErrorNormal     = 1
+	serviceConfigDesc      = 1
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	serviceStopped      = 1
+	serviceStartPending = 2
+	serviceStopPending  = 3
+	serviceRunning      = 4
+
+	serviceAcceptStop     = 1
+	serviceAcceptShutdown = 4
+
+	errorServiceNotActive          = 1062
+	errorFailedServiceControllerCx = 1063
+
+	keySetValue = 0x0002
+	regMultiSz  = 7
+)
+
+// serviceStatus is the SERVICE_STATUS reported to the SCM
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+// serviceTableEntry is a SERVICE_TABLE_ENTRYW passed to the dispatcher
+type serviceTableEntry struct {
+	Name *uint16
+	Proc uintptr
+}
+
+// the service run by this process, set by runService
+var service struct {
+	name   string
+	handle uintptr
+	status serviceStatus
+	// receives the stop and shutdown requests of the SCM
+	stop chan struct{}
+	// provider of the rates of the server
+	provider rates.Provider
+}
+
+// enters the directory of the executable if the process is started by the SCM, whose working directory is system32
+// the templates, locales and static files must be installed next to the executable
+func enterServiceDir() string {
+	if !strings.Contains(strings.Join(os.Args, " "), " service ") || os.Args[len(os.Args)-1] != "run" {
+		return ""
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	dir := filepath.Dir(exe)
+	if err := os.Chdir(dir); err != nil {
+		return ""
+	}
+	return dir
+}
+
+// manages the converter as Windows service: install, uninstall, start and stop it
+// run is the command the SCM starts the installed service with, it serves the rates of p
+func serviceCommand(p rates.Provider, args []string) {
+	flags := flag.NewFlagSet("service", flag.ExitOnError)
+	name := flags.String("name", "currconv", "name of the service")
+	env := flags.String("env", "", "comma separated environment variables whose current values are stored with the service on install, e.g. fixer_api_key,PORT")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: currencyconverter service [--name NAME] [--env VARS] install|uninstall|start|stop|run")
+		os.Exit(2)
+	}
+
+	var err error
+	switch flags.Arg(0) {
+	case "install":
+		err = installService(*name, *env)
+	case "uninstall":
+		err = uninstallService(*name)
+	case "start":
+		err = startService(*name)
+	case "stop":
+		err = stopService(*name)
+	case "run":
+		err = runService(p, *name)
+	default:
+		err = errors.New("unknown service command " + flags.Arg(0) + ", use install, uninstall, start, stop or run")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// returns the UTF-16 form of s for a system call, it must be converted to uintptr in the argument list of the call
+// so it is kept alive until the call returns
+func utf16Ptr(s string) *uint16 {
+	p, _ := syscall.UTF16PtrFromString(s)
+	return p
+}
+
+// opens the SCM of the local computer, the caller must close the handle
+func openSCManager() (uintptr, error) {
+	h, _, err := procOpenSCManager.Call(0, 0, scManagerAllAccess)
+	if h == 0 {
+		return 0, fmt.Errorf("can't open the service control manager: %w", err)
+	}
+	return h, nil
+}
+
+// opens the service name with access, the caller must close the handle
+func openService(name string, access uint32) (uintptr, func(), error) {
+	scm, err := openSCManager()
+	if err != nil {
+		return 0, nil, err
+	}
+	h, _, err := procOpenService.Call(scm, uintptr(unsafe.Pointer(utf16Ptr(name))), uintptr(access))
+	if h == 0 {
+		procCloseServiceHandle.Call(scm)
+		return 0, nil, fmt.Errorf("can't open service %s: %w", name, err)
+	}
+	return h, func() {
+		procCloseServiceHandle.Call(h)
+		procCloseServiceHandle.Call(scm)
+	}, nil
+}
+
+// returns the arguments the service is started with: the flags given before the service command and the service run command
+func serviceArgs(name string) string {
+	var args []string
+	for _, arg := range os.Args[1:] {
+		if arg == "service" {
+			break
+		}
+		args = append(args, syscall.EscapeArg(arg))
+	}
+	return strings.Join(append(args, "service", "--name", syscall.EscapeArg(name), "run"), " ")
+}
+
+// registers the executable as service name that starts automatically with the system, as LocalSystem
+// the current values of the environment variables env are stored in the Environment of the service
+func installService(name string, env string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	scm, err := openSCManager()
+	if err != nil {
+		return err
+	}
+	defer procCloseServiceHandle.Call(scm)
+
+	h, _, err := procCreateService.Call(scm, uintptr(unsafe.Pointer(utf16Ptr(name))), uintptr(unsafe.Pointer(utf16Ptr("Currency converter"))), serviceAllAccess,
+		serviceWin32OwnProcess, serviceAutoStart, serviceErrorNormal,
+		uintptr(unsafe.Pointer(utf16Ptr(syscall.EscapeArg(exe)+" "+serviceArgs(name)))), 0, 0, 0, 0, 0)
+	if h == 0 {
+		return fmt.Errorf("can't create service %s: %w", name, err)
+	}
+	defer procCloseServiceHandle.Call(h)
+
+	description, _ := syscall.UTF16PtrFromString("Converts between currencies with current exchange rates")
+	procChangeServiceConfig2.Call(h, serviceConfigDesc, uintptr(unsafe.Pointer(&description)))
+
+	if err := setServiceEnvironment(name, env); err != nil {
+		return err
+	}
+	fmt.Println("Installed service", name, "running in", filepath.Dir(exe))
+	return nil
+}
+
+// stores the current values of the comma separated environment variables names in the registry value the SCM
+// starts service name with, so its settings don't have to be set for the whole system
+func setServiceEnvironment(name string, names string) error {
+	var block []uint16
+	for _, key := range strings.Split(names, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			return errors.New("environment variable " + key + " is not set")
+		}
+		entry, err := syscall.UTF16FromString(key + "=" + value)
+		if err != nil {
+			return err
+		}
+		block = append(block, entry...)
+	}
+	if block == nil {
+		return nil
+	}
+	block = append(block, 0)
+
+	var key syscall.Handle
+	path, _ := syscall.UTF16PtrFromString(`SYSTEM\CurrentControlSet\Services\` + name)
+	if err := syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE, path, 0, keySetValue, &key); err != nil {
+		return fmt.Errorf("can't open the registry key of service %s: %w", name, err)
+	}
+	defer syscall.RegCloseKey(key)
+	r, _, _ := procRegSetValueEx.Call(uintptr(key), uintptr(unsafe.Pointer(utf16Ptr("Environment"))), 0, regMultiSz,
+		uintptr(unsafe.Pointer(&block[0])), uintptr(len(block)*2))
+	if r != 0 {
+		return fmt.Errorf("can't store the environment of service %s: %w", name, syscall.Errno(r))
+	}
+	return nil
+}
+
+// stops service name if it is running and deletes it
+func uninstallService(name string) error {
+	if err := stopService(name); err != nil && !errors.Is(err, syscall.Errno(errorServiceNotActive)) {
+		return err
+	}
+	h, done, err := openService(name, serviceDelete)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if r, _, err := procDeleteService.Call(h); r == 0 {
+		return fmt.Errorf("can't delete service %s: %w", name, err)
+	}
+	fmt.Println("Uninstalled service", name)
+	return nil
+}
+
+// asks the SCM to start service name
+func startService(name string) error {
+	h, done, err := openService(name, serviceStart)
+	if err != nil {
+		return err
+	}
+	defer done()
+	if r, _, err := procStartService.Call(h, 0, 0); r == 0 {
+		return fmt.Errorf("can't start service %s: %w", name, err)
+	}
+	fmt.Println("Started service", name)
+	return nil
+}
+
+// asks the SCM to stop service name and waits up to 30 seconds until it has stopped
+func stopService(name string) error {
+	h, done, err := openService(name, serviceStop|serviceQueryStatus)
+	if err != nil {
+		return err
+	}
+	defer done()
+	var status serviceStatus
+	if r, _, err := procControlService.Call(h, serviceControlStop, uintptr(unsafe.Pointer(&status))); r == 0 {
+		return fmt.Errorf("can't stop service %s: %w", name, err)
+	}
+	for deadline := time.Now().Add(30 * time.Second); status.CurrentState != serviceStopped; {
+		if time.Now().After(deadline) {
+			return errors.New("service " + name + " didn't stop within 30s")
+		}
+		time.Sleep(300 * time.Millisecond)
+		if r, _, err := procQueryServiceStatus.Call(h, uintptr(unsafe.Pointer(&status))); r == 0 {
+			return err
+		}
+	}
+	fmt.Println("Stopped service", name)
+	return nil
+}
+
+// reports state to the SCM, controls lists the requests the service accepts in that state
+func setServiceStatus(state uint32, controls uint32, exitCode uint32) {
+	service.status = serviceStatus{
+		ServiceType:      serviceWin32OwnProcess,
+		CurrentState:     state,
+		ControlsAccepted: controls,
+		Win32ExitCode:    exitCode,
+	}
+	if state == serviceStartPending || state == serviceStopPending {
+		service.status.WaitHint = 30000
+	}
+	procSetServiceStatus.Call(service.handle, uintptr(unsafe.Pointer(&service.status)))
+}
+
+// handles the requests of the SCM, stop and shutdown end the service
+func serviceHandler(control uintptr, eventType uintptr, eventData uintptr, context uintptr) uintptr {
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		setServiceStatus(serviceStopPending, 0, 0)
+		select {
+		case service.stop <- struct{}{}:
+		default:
+		}
+	case serviceControlInterrogate:
+		procSetServiceStatus.Call(service.handle, uintptr(unsafe.Pointer(&service.status)))
+	}
+	return 0
+}
+
+// runs the server until the SCM stops the service or the server fails, called by the dispatcher on its own thread
+func serviceMain(argc uintptr, argv uintptr) uintptr {
+	h, _, err := procRegisterServiceCtrlHandlerEx.Call(uintptr(unsafe.Pointer(utf16Ptr(service.name))), syscall.NewCallback(serviceHandler), 0)
+	if h == 0 {
+		log.Println("service:", err)
+		return 0
+	}
+	service.handle = h
+	setServiceStatus(serviceStartPending, 0, 0)
+
+	failed := make(chan error, 1)
+	go func() {
+		failed <- runServer(service.provider)
+	}()
+	setServiceStatus(serviceRunning, serviceAcceptStop|serviceAcceptShutdown, 0)
+
+	select {
+	case <-service.stop:
+		shutdown()
+		log.Println("Service", service.name, "stopped")
+		setServiceStatus(serviceStopped, 0, 0)
+	case err := <-failed:
+		log.Println("service:", err)
+		setServiceStatus(serviceStopped, 0, 1)
+	}
+	return 0
+}
+
+// connects the process to the SCM that started it and runs service name with the rates of p until it is stopped
+func runService(p rates.Provider, name string) error {
+	service.name = name
+	service.provider = p
+	service.stop = make(chan struct{}, 1)
+	table := []serviceTableEntry{{syscall.StringToUTF16Ptr(name), syscall.NewCallback(serviceMain)}, {nil, 0}}
+	r, _, err := procStartServiceCtrlDispatcher.Call(uintptr(unsafe.Pointer(&table[0])))
+	if r == 0 {
+		if errors.Is(err, syscall.Errno(errorFailedServiceControllerCx)) {
+			return errNotStartedBySCM
+		}
+		return err
+	}
+	return nil
+}
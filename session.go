@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// name of the cookie holding the session
+const sessionCookie = "session"
+
+// time a session is kept after its last change
+const sessionMaxAge = 30 * 24 * time.Hour
+
+// Session is the state of a visitor kept encrypted in their session cookie, so it can be neither read nor changed by them
+type Session struct {
+	// token forms must repeat, see csrfProtect
+	CSRF string `json:"csrf,omitempty"`
+	// messages shown once on the next page
+	Flashes []string `json:"flashes,omitempty"`
+	// remembered preferences and other small values by name
+	Values map[string]string `json:"values,omitempty"`
+	// unix time after which the cookie is no longer accepted
+	Expires int64 `json:"exp"`
+}
+
+// key of the request's session in its context
+type sessionKey struct{}
+
+// encrypts and authenticates the session cookies with the key set by session_key
+var sessionCipher = newSessionCipher(getEnv("session_key", ""))
+
+// returns AES-GCM with a 256 bit key derived from secret, a random key if secret is empty
+func newSessionCipher(secret string) cipher.AEAD {
+	key := sha256.Sum256([]byte(secret))
+	if secret == "" {
+		if _, err := rand.Read(key[:]); err != nil {
+			log.Println(err)
+		}
+	}
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+// warns that sessions and with them the csrf tokens of forms don't survive restarts and aren't accepted by other
+// instances if session_key isn't set, forms then fail with "Form expired"
+func warnSessionKey() {
+	if getEnv("session_key", "") == "" {
+		log.Println("warning: session_key is not set, sessions end when the server restarts and aren't shared by its instances")
+	}
+}
+
+// returns the cookie value holding s
+func encodeSession(s *Session) (string, error) {
+	return seal(s, sessionCookie)
+}
+
+// returns the session held by the cookie value, an error if it was changed, encrypted with another key or has expired
+func decodeSession(value string) (*Session, error) {
+	var s Session
+	if err := unseal(value, sessionCookie, &s); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > s.Expires {
+		return nil, errors.New("session expired")
+	}
+	return &s, nil
+}
+
+// returns v json encoded and encrypted with the session key, purpose keeps values sealed for one use from being
+// accepted by another
+func seal(v interface{}, purpose string) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, sessionCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sessionCipher.Seal(nonce, nonce, b, []byte(purpose))), nil
+}
+
+// decodes the value sealed for purpose into v, an error if it was changed or encrypted with another key
+func unseal(value string, purpose string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	if len(b) < sessionCipher.NonceSize() {
+		return errors.New("sealed value too short")
+	}
+	nonce, sealed := b[:sessionCipher.NonceSize()], b[sessionCipher.NonceSize():]
+	plain, err := sessionCipher.Open(nil, nonce, sealed, []byte(purpose))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plain, v)
+}
+
+// wraps h so handlers can read the session of a request with getSession
+// a missing or invalid cookie starts an empty session
+func withSessions(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := &Session{}
+		if c, err := r.Cookie(sessionCookie); err == nil {
+			if decoded, err := decodeSession(c.Value); err == nil {
+				s = decoded
+			}
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, s)))
+	})
+}
+
+// returns the session of r, changes are only kept after saveSession
+func getSession(r *http.Request) *Session {
+	if s, ok := r.Context().Value(sessionKey{}).(*Session); ok {
+		return s
+	}
+	// requests that didn't pass withSessions, e.g. in commands, get a session that isn't kept
+	return &Session{}
+}
+
+// sets the session cookie to the current state of the session of r, must be called before the response is written
+func saveSession(w http.ResponseWriter, r *http.Request) {
+	s := getSession(r)
+	s.Expires = time.Now().Add(sessionMaxAge).Unix()
+	value, err := encodeSession(s)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(sessionMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   isTLS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// stores message to be shown on the next page rendered for the session of r
+func addFlash(w http.ResponseWriter, r *http.Request, message string) {
+	s := getSession(r)
+	s.Flashes = append(s.Flashes, message)
+	saveSession(w, r)
+}
+
+// returns the flash messages of the session of r and removes them from it
+func popFlashes(w http.ResponseWriter, r *http.Request) []string {
+	s := getSession(r)
+	flashes := s.Flashes
+	if len(flashes) > 0 {
+		s.Flashes = nil
+		saveSession(w, r)
+	}
+	return flashes
+}
+
+// returns the value stored under name in the session of r, empty if there is none
+func sessionValue(r *http.Request, name string) string {
+	return getSession(r).Values[name]
+}
+
+// stores value under name in the session of r, an empty value removes it
+func setSessionValue(w http.ResponseWriter, r *http.Request, name string, value string) {
+	s := getSession(r)
+	if value == "" {
+		delete(s.Values, name)
+	} else {
+		if s.Values == nil {
+			s.Values = make(map[string]string)
+		}
+		s.Values[name] = value
+	}
+	saveSession(w, r)
+}
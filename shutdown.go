@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// the servers started by listenAndServe and listenAndServeTLS, which are shut down together
+var servers = struct {
+	sync.Mutex
+	list []*http.Server
+	// whether a shutdown has started, /readyz fails from then on
+	draining bool
+}{}
+
+// limits of reading requests, so slow clients can't hold connections open indefinitely
+// there is no write timeout since streamed responses take as long as they need, handlers are timed out by timeoutRequests
+const (
+	readHeaderTimeout = 10 * time.Second
+	// reading a whole request including its body, e.g. the csv of a bulk conversion
+	readTimeout = time.Minute
+	// time a keep-alive connection may wait for the next request
+	idleTimeout = 2 * time.Minute
+)
+
+// returns a server for h that is shut down gracefully by shutdown
+func newHTTPServer(h http.Handler) *http.Server {
+	s := &http.Server{Handler: h, ReadHeaderTimeout: readHeaderTimeout, ReadTimeout: readTimeout, IdleTimeout: idleTimeout}
+	servers.Lock()
+	servers.list = append(servers.list, s)
+	servers.Unlock()
+	return s
+}
+
+// checks whether the server is shutting down
+func isDraining() bool {
+	servers.Lock()
+	defer servers.Unlock()
+	return servers.draining
+}
+
+// returns a channel receiving SIGTERM and interrupts, the signals the server is asked to stop with
+func shutdownSignals() chan os.Signal {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, os.Interrupt)
+	return c
+}
+
+// shuts the servers down for a rolling deploy
+// /readyz fails for shutdown_delay (0s) before the listeners are closed, so load balancers can deregister the instance
+// while it still serves requests, keep-alive is turned off during the delay unless shutdown_keepalive is true,
+// so clients open their next connection to another instance
+// requests in flight are given shutdown_timeout (30s) to finish, the connections still open after it are closed
+func shutdown() {
+	delay, err := time.ParseDuration(getEnv("shutdown_delay", "0s"))
+	if err != nil || delay < 0 {
+		log.Println("shutdown_delay must be a duration, e.g. 10s")
+		delay = 0
+	}
+	timeout, err := time.ParseDuration(getEnv("shutdown_timeout", "30s"))
+	if err != nil || timeout <= 0 {
+		log.Println("shutdown_timeout must be a positive duration, e.g. 30s")
+		timeout = 30 * time.Second
+	}
+	keepAlive := getEnv("shutdown_keepalive", "false") == "true"
+
+	servers.Lock()
+	servers.draining = true
+	list := append([]*http.Server(nil), servers.list...)
+	servers.Unlock()
+
+	if delay > 0 {
+		log.Println("Shutting down in", delay)
+		for _, s := range list {
+			s.SetKeepAlivesEnabled(keepAlive)
+		}
+		time.Sleep(delay)
+	}
+
+	log.Println("Draining connections for up to", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, s := range list {
+		wg.Add(1)
+		go func(s *http.Server) {
+			defer wg.Done()
+			if err := s.Shutdown(ctx); err != nil {
+				log.Println("Closing connections that didn't finish within", timeout)
+				s.Close()
+			}
+		}(s)
+	}
+	wg.Wait()
+	resignLeadership()
+	log.Println("Server stopped")
+}
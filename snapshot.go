@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"currconv/pkg/rates"
+)
+
+// returns the directory snapshots are stored in, set by the snapshot_dir environment variable
+func getSnapshotDir() string {
+	return getEnv("snapshot_dir", "snapshots")
+}
+
+// stores d as an immutable snapshot named after its timestamp
+// snapshots that already exist are never overwritten
+func saveSnapshot(d rates.Data) {
+	if !d.Success {
+		return
+	}
+
+	dir := getSnapshotDir()
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	path := filepath.Join(dir, strconv.FormatInt(d.Timestamp, 10)+".json")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if os.IsExist(err) {
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+
+	err = json.NewEncoder(f).Encode(d)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// returns the timestamps of all stored snapshots, oldest first
+func listSnapshots() []int64 {
+	files, err := ioutil.ReadDir(getSnapshotDir())
+	if err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+	}
+
+	var timestamps []int64
+	for _, f := range files {
+		timestamp, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), ".json"), 10, 64)
+		if err == nil && strings.HasSuffix(f.Name(), ".json") {
+			timestamps = append(timestamps, timestamp)
+		}
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps
+}
+
+// deletes the stored snapshots that are older than cutoff
+func pruneSnapshots(cutoff time.Time) {
+	for _, timestamp := range listSnapshots() {
+		if !time.Unix(timestamp, 0).Before(cutoff) {
+			continue
+		}
+		err := os.Remove(filepath.Join(getSnapshotDir(), strconv.FormatInt(timestamp, 10)+".json"))
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// SnapshotInfo identifies a stored snapshot in the listing
+type SnapshotInfo struct {
+	Timestamp int64  `json:"timestamp"`
+	Time      string `json:"time"`
+}
+
+// lists all snapshots at /api/snapshots/ and serves a single snapshot at /api/snapshots/{timestamp}
+func snapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	name := pathParam(r, "timestamp")
+	if name == "" {
+		list := []SnapshotInfo{}
+		for _, timestamp := range listSnapshots() {
+			list = append(list, SnapshotInfo{timestamp, rfc3339(timestamp)})
+		}
+		writeJSON(w, list)
+		return
+	}
+
+	timestamp, err := strconv.ParseInt(name, 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(getSnapshotDir(), strconv.FormatInt(timestamp, 10)+".json"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// snapshots never change once written
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"currconv/pkg/rates"
+)
+
+// content hashes of the files in static/, computed on first use
+var staticVersions = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// returns the url of the static file name with a version parameter that changes with its content
+// files requested with a version parameter can be cached forever
+func staticURL(name string) string {
+	staticVersions.Lock()
+	defer staticVersions.Unlock()
+
+	version, ok := staticVersions.m[name]
+	if !ok {
+		b, err := ioutil.ReadFile(filepath.Join("static", name))
+		if err != nil {
+			return "/static/" + name
+		}
+		sum := sha256.Sum256(b)
+		version = hex.EncodeToString(sum[:4])
+		staticVersions.m[name] = version
+	}
+	return "/static/" + name + "?v=" + version
+}
+
+// serves the static files, marking versioned requests as immutable
+func staticHandler(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("v") != "" {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "public, max-age=3600")
+		}
+		h.ServeHTTP(w, r)
+	}
+}
+
+// sets a Warning header if d is the current data of the server of r and it is stale because it can't be refreshed
+// or if d was read from the rates file, which is also named by X-Rates-Source
+func setRateWarnings(w http.ResponseWriter, r *http.Request, d rates.Data) {
+	if s := serverFrom(r.Context()).cache.Status(); s.Stale && s.Timestamp == d.Timestamp {
+		w.Header().Add("Warning", `110 - "Response is Stale"`)
+	}
+	if d.Static {
+		w.Header().Add("Warning", `199 - "Static rates from a file"`)
+		w.Header().Set("X-Rates-Source", "file")
+	}
+}
+
+// sets Cache-Control and Expires so responses built from d are cached until the cache refreshes d
+// stale and static data is flagged with setRateWarnings
+func setCacheHeaders(w http.ResponseWriter, r *http.Request, d rates.Data) {
+	setRateWarnings(w, r, d)
+	expires := d.Time().Add(serverFrom(r.Context()).cache.MaxAge)
+	maxAge := int(time.Until(expires).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
+	w.Header().Set("Expires", expires.UTC().Format(http.TimeFormat))
+}
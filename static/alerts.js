@@ -0,0 +1,99 @@
+// subscribes this browser to push notifications and creates the rate alert of the form on /alerts/
+// the ids of the created alerts are kept in localStorage so they can be deleted again
+(function () {
+    var form = document.getElementById("alertform");
+    var status = document.getElementById("alertstatus");
+    var list = document.getElementById("alertlist");
+
+    if (!("serviceWorker" in navigator) || !("PushManager" in window)) {
+        status.textContent = "This browser doesn't support push notifications.";
+        form.querySelector("[type=submit]").disabled = true;
+        return;
+    }
+
+    function stored() {
+        return JSON.parse(localStorage.getItem("alerts") || "[]");
+    }
+
+    function store(alerts) {
+        localStorage.setItem("alerts", JSON.stringify(alerts));
+        render();
+    }
+
+    function render() {
+        list.textContent = "";
+        stored().forEach(function (alert) {
+            var row = list.insertRow();
+            var conditions = [];
+            if (alert.above) {
+                conditions.push("rises to " + alert.above);
+            }
+            if (alert.below) {
+                conditions.push("falls to " + alert.below);
+            }
+            row.insertCell().textContent = alert.from + "/" + alert.to + " " + conditions.join(" or ");
+            var remove = document.createElement("button");
+            remove.textContent = "Delete";
+            remove.addEventListener("click", function () {
+                fetch("/api/alerts/" + alert.id, {method: "DELETE"}).then(function () {
+                    store(stored().filter(function (a) { return a.id !== alert.id; }));
+                });
+            });
+            row.insertCell().appendChild(remove);
+        });
+    }
+
+    // converts the base64url public key of the server into the bytes PushManager expects
+    function decodeKey(key) {
+        var raw = atob(key.replace(/-/g, "+").replace(/_/g, "/"));
+        var bytes = new Uint8Array(raw.length);
+        for (var i = 0; i < raw.length; i++) {
+            bytes[i] = raw.charCodeAt(i);
+        }
+        return bytes;
+    }
+
+    function subscribe() {
+        return Promise.all([
+            navigator.serviceWorker.register("/sw.js").then(function () { return navigator.serviceWorker.ready; }),
+            fetch("/api/push/key").then(function (resp) { return resp.json(); })
+        ]).then(function (results) {
+            var registration = results[0];
+            return registration.pushManager.getSubscription().then(function (subscription) {
+                return subscription || registration.pushManager.subscribe({
+                    userVisibleOnly: true,
+                    applicationServerKey: decodeKey(results[1].public_key)
+                });
+            });
+        });
+    }
+
+    form.addEventListener("submit", function (event) {
+        event.preventDefault();
+        status.textContent = "";
+        subscribe().then(function (subscription) {
+            return fetch("/api/alerts", {
+                method: "POST",
+                headers: {"Content-Type": "application/json"},
+                body: JSON.stringify({
+                    pair: form.pair.value,
+                    above: parseFloat(form.above.value) || 0,
+                    below: parseFloat(form.below.value) || 0,
+                    subscription: subscription.toJSON()
+                })
+            });
+        }).then(function (resp) {
+            return resp.json().then(function (body) {
+                if (!resp.ok) {
+                    throw new Error(body.error);
+                }
+                store(stored().concat([{id: body.id, from: body.from, to: body.to, above: body.above, below: body.below}]));
+                status.textContent = "You will be notified in this browser.";
+            });
+        }).catch(function (err) {
+            status.textContent = "The alert could not be created: " + err.message;
+        });
+    });
+
+    render();
+})();
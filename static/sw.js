@@ -0,0 +1,13 @@
+// service worker showing the rate alerts pushed by the server and opening the chart of the pair when one is clicked
+self.addEventListener("push", function (event) {
+    var alert = event.data ? event.data.json() : {};
+    event.waitUntil(self.registration.showNotification(alert.title || "Currency Converter", {
+        body: alert.body,
+        data: {url: alert.url || "/"}
+    }));
+});
+
+self.addEventListener("notificationclick", function (event) {
+    event.notification.close();
+    event.waitUntil(self.clients.openWindow(event.notification.data.url));
+});
@@ -0,0 +1,61 @@
+// currency converter widget
+// usage: <script src="https://<host>/widget.js" data-from="USD" data-to="EUR" data-amount="100"></script>
+// optional attributes: data-class adds a class to the widget box, data-style="none" disables the default styling
+// the elements can be styled with the classes currconv-widget, currconv-amount, currconv-select and currconv-result
+(function () {
+    var script = document.currentScript;
+    var origin = new URL(script.src).origin;
+
+    var box = document.createElement("div");
+    box.className = "currconv-widget" + (script.dataset.class ? " " + script.dataset.class : "");
+    if (script.dataset.style !== "none") {
+        box.style.cssText = "display:inline-block;padding:10px;border:1px solid #ccc;border-radius:4px;font-family:Arial,Helvetica,sans-serif;background:#f1f1f1;color:#293241";
+    }
+
+    var amount = document.createElement("input");
+    amount.className = "currconv-amount";
+    amount.type = "number";
+    amount.step = "0.01";
+    amount.min = "0";
+    amount.value = script.dataset.amount || "1";
+
+    var from = document.createElement("select");
+    var to = document.createElement("select");
+    from.className = to.className = "currconv-select";
+
+    var result = document.createElement("div");
+    result.className = "currconv-result";
+
+    var credit = document.createElement("a");
+    credit.href = origin + "/";
+    credit.target = "_blank";
+    credit.textContent = "Currency Converter";
+    credit.style.fontSize = "8pt";
+
+    box.appendChild(amount);
+    box.appendChild(from);
+    box.appendChild(document.createTextNode(" → "));
+    box.appendChild(to);
+    box.appendChild(result);
+    box.appendChild(credit);
+    script.parentNode.insertBefore(box, script.nextSibling);
+
+    var url = origin + "/api/widget?from=" + encodeURIComponent(script.dataset.from || "") + "&to=" + encodeURIComponent(script.dataset.to || "");
+    fetch(url).then(function (response) {
+        return response.json();
+    }).then(function (data) {
+        data.currencies.forEach(function (currency) {
+            from.add(new Option(currency, currency, false, currency === data.from));
+            to.add(new Option(currency, currency, false, currency === data.to));
+        });
+
+        var update = function () {
+            var value = parseFloat(amount.value) / data.rates[from.value] * data.rates[to.value];
+            result.textContent = isNaN(value) ? "" : value.toFixed(2) + " " + to.value;
+        };
+        amount.addEventListener("input", update);
+        from.addEventListener("change", update);
+        to.addEventListener("change", update);
+        update();
+    });
+})();
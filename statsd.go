@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsd sends the metrics to the server set by statsd_addr, it does nothing until startStatsD connects it
+var statsd = &StatsD{}
+
+// StatsD sends metrics over UDP in the StatsD format, with DogStatsD tags unless Plain is set
+type StatsD struct {
+	mutex sync.Mutex
+	conn  net.Conn
+	// prepended to every metric name, e.g. "currconv."
+	Prefix string
+	// tags added to every metric, e.g. env:prod
+	Tags []string
+	// leaves the tags out for servers that don't understand them
+	Plain bool
+}
+
+// replaces the characters the StatsD line format uses as separators
+var statsdReplacer = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_")
+
+// connects statsd to statsd_addr (host:port, off by default) and sends the gauges every statsd_interval (10s)
+// statsd_prefix is prepended to the names, statsd_tags (comma separated, e.g. "env:prod,region:eu") are added to every metric
+// set statsd_format to statsd for servers without DogStatsD tags, the gauges are those of the server of ctx
+func startStatsD(ctx context.Context) {
+	addr := getEnv("statsd_addr", "")
+	if addr == "" {
+		return
+	}
+	interval, err := time.ParseDuration(getEnv("statsd_interval", "10s"))
+	if err != nil || interval <= 0 {
+		log.Println("statsd_interval must be a positive duration, e.g. 10s")
+		interval = 10 * time.Second
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		log.Println("statsd:", err)
+		return
+	}
+
+	statsd.mutex.Lock()
+	statsd.conn = conn
+	statsd.Prefix = getEnv("statsd_prefix", "currconv.")
+	statsd.Tags = nil
+	for _, tag := range strings.Split(getEnv("statsd_tags", ""), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			statsd.Tags = append(statsd.Tags, statsdReplacer.Replace(tag))
+		}
+	}
+	statsd.Plain = getEnv("statsd_format", "dogstatsd") == "statsd"
+	statsd.mutex.Unlock()
+
+	log.Println("Sending metrics to", addr)
+	go func() {
+		for range time.Tick(interval) {
+			sendGauges(ctx)
+		}
+	}()
+}
+
+// sends one metric of the given StatsD type with tags in addition to the configured ones
+// metrics are dropped if statsd isn't connected or the packet can't be sent, they are not worth blocking a request for
+func (s *StatsD) send(name string, value string, kind string, tags ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn == nil {
+		return
+	}
+
+	line := s.Prefix + name + ":" + value + "|" + kind
+	if !s.Plain && len(s.Tags)+len(tags) > 0 {
+		all := append([]string(nil), s.Tags...)
+		for _, tag := range tags {
+			all = append(all, statsdReplacer.Replace(tag))
+		}
+		line += "|#" + strings.Join(all, ",")
+	}
+	s.conn.Write([]byte(line))
+}
+
+// sends a duration in milliseconds
+func (s *StatsD) timing(name string, d time.Duration, tags ...string) {
+	s.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64), "ms", tags...)
+}
+
+// adds n to a counter
+func (s *StatsD) count(name string, n int, tags ...string) {
+	s.send(name, strconv.Itoa(n), "c", tags...)
+}
+
+// sets a gauge
+func (s *StatsD) gauge(name string, v float64, tags ...string) {
+	s.send(name, strconv.FormatFloat(v, 'f', -1, 64), "g", tags...)
+}
+
+// sends the current values of the gauges also exported at /metrics
+func sendGauges(ctx context.Context) {
+	age, stale := ratesAge(ctx)
+	if age >= 0 {
+		statsd.gauge("rates.age_seconds", age)
+	}
+	statsd.gauge("rates.stale", float64(boolMetric(stale)))
+	statsd.gauge("rates.cache.hit_ratio", serverFrom(ctx).cache.Stats().HitRatio())
+
+	for _, s := range providerStats() {
+		tag := "provider:" + s.Name
+		statsd.gauge("provider.consecutive_failures", float64(s.ConsecutiveFailures), tag)
+		if s.QuotaRemaining >= 0 {
+			statsd.gauge("provider.quota_remaining", float64(s.QuotaRemaining), tag)
+		}
+		if !s.LastSuccess.IsZero() {
+			statsd.gauge("provider.seconds_since_last_success", time.Since(s.LastSuccess).Round(time.Second).Seconds(), tag)
+		}
+	}
+}
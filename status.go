@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Status stores the state of the current rates for /api/status, so integrators can check the data quality with one call
+type Status struct {
+	// provider that last delivered rates, the configured one if none has yet
+	Provider string `json:"provider,omitempty"`
+	Base     string `json:"base,omitempty"`
+	// timestamp of the rates, 0 if none have been fetched yet
+	Timestamp int64  `json:"timestamp"`
+	Time      string `json:"time,omitempty"`
+	// age of the rates in seconds, omitted if none have been fetched yet
+	AgeSeconds float64 `json:"age_seconds,omitempty"`
+	// when the refresh job runs next, omitted if it isn't scheduled
+	NextRefresh string `json:"next_refresh,omitempty"`
+	// number of currencies quoted by the provider, without the derived ones
+	Currencies int `json:"currencies"`
+	// whether the rates are outdated because they can't be refreshed
+	Stale bool `json:"stale"`
+	// whether the rates were read from the rates file
+	Static bool `json:"static"`
+	// whether rates are replaced or added to those of the provider, by the sources listed in Overrides
+	OverridesActive bool     `json:"overrides_active"`
+	Overrides       []string `json:"overrides,omitempty"`
+	// time until which the provider asked not to be sent requests, omitted if it doesn't rate limit them
+	CooldownUntil   string `json:"cooldown_until,omitempty"`
+	CooldownSeconds int    `json:"cooldown_seconds,omitempty"`
+	// time until which the rates aren't requested again after refreshes failed, omitted if the last one didn't
+	RetryAt string `json:"retry_at,omitempty"`
+}
+
+// returns the name of the provider that delivered rates last, the first instrumented one if none has
+func activeProvider() string {
+	stats := providerStats()
+	if len(stats) == 0 {
+		return ""
+	}
+	active := stats[0]
+	for _, s := range stats[1:] {
+		if s.LastSuccess.After(active.LastSuccess) {
+			active = s
+		}
+	}
+	return active.Name
+}
+
+// returns what replaces or adds to the rates of the provider of the server of ctx: pegs, virtual currencies and the rates file
+func activeOverrides(ctx context.Context, static bool) []string {
+	var overrides []string
+	if len(peggedCurrencies) > 0 {
+		overrides = append(overrides, "pegs")
+	}
+	if len(serverFrom(ctx).virtualCurrencies.all()) > 0 {
+		overrides = append(overrides, "virtual_currencies")
+	}
+	if static {
+		overrides = append(overrides, "rates_file")
+	}
+	return overrides
+}
+
+// returns the state of the current rates of the server of ctx without refreshing them
+func currentStatus(ctx context.Context) Status {
+	s := serverFrom(ctx).cache.Status()
+	status := Status{
+		Provider:   activeProvider(),
+		Base:       s.Base,
+		Timestamp:  s.Timestamp,
+		Currencies: s.Currencies,
+		Stale:      s.Stale,
+		Static:     s.Static,
+		Overrides:  activeOverrides(ctx, s.Static),
+	}
+	status.OverridesActive = len(status.Overrides) > 0
+	if s.Timestamp != 0 {
+		status.Time = rfc3339(s.Timestamp)
+		status.AgeSeconds, _ = ratesAge(ctx)
+	}
+	if next := nextRun("refresh"); !next.IsZero() {
+		status.NextRefresh = next.UTC().Format(time.RFC3339)
+	}
+	if !s.Cooldown.IsZero() {
+		status.CooldownUntil = s.Cooldown.UTC().Format(time.RFC3339)
+		status.CooldownSeconds = int(time.Until(s.Cooldown).Seconds()) + 1
+	}
+	if !s.RetryAt.IsZero() {
+		status.RetryAt = s.RetryAt.UTC().Format(time.RFC3339)
+	}
+	return status
+}
+
+// writes the state of the current rates as json without refreshing them
+func apiStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, currentStatus(r.Context()))
+}
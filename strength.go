@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"currconv/pkg/convert"
+	"currconv/pkg/rates"
+)
+
+// Strength stores the average appreciation of a currency against the basket in percent
+type Strength struct {
+	Currency string  `json:"currency"`
+	Score    float64 `json:"score"`
+}
+
+// StrengthIndex ranks currencies by their strength between two days, strongest first
+type StrengthIndex struct {
+	Start      string     `json:"start"`
+	End        string     `json:"end"`
+	Basket     []string   `json:"basket"`
+	Currencies []Strength `json:"currencies"`
+}
+
+// StrengthPage stores variables for /strength/
+type StrengthPage struct {
+	Index   *StrengthIndex
+	Message string
+}
+
+// splits a comma separated list of currencies
+func splitCurrencies(s string) []string {
+	var currencies []string
+	for _, c := range strings.Split(s, ",") {
+		if c = currencyCode(c); c != "" {
+			currencies = append(currencies, c)
+		}
+	}
+	return currencies
+}
+
+// computes the strength of every currency between the oldest stored day of the window and the current data
+// the score is the average change in percent of the currency's value in each basket currency
+// results are cached until the data or the history of the server of ctx changes
+func strengthIndex(ctx context.Context, current rates.Data, window int, basket []string) (StrengthIndex, error) {
+	history := serverFrom(ctx).history
+	now := time.Now()
+	key := "strength " + strconv.Itoa(history.getVersion()) + " " + strconv.FormatInt(current.Timestamp, 10) + " " +
+		now.Format("2006-01-02") + " " + strconv.Itoa(window) + " " + strings.Join(basket, ",")
+	if v, ok := queryCache.get(key); ok {
+		return v.(StrengthIndex), nil
+	}
+
+	days := history.lastDays(now, window+1)
+	if len(days) == 0 || days[0].Date == current.Date {
+		return StrengthIndex{}, errors.New("not enough history for a window of " + strconv.Itoa(window) + " days")
+	}
+	start := days[0]
+
+	index := StrengthIndex{Start: start.Date, End: current.Date, Currencies: []Strength{}}
+	for _, b := range basket {
+		if convert.Available(start, b) && convert.Available(current, b) {
+			index.Basket = append(index.Basket, b)
+		}
+	}
+	if len(index.Basket) == 0 {
+		return index, errors.New("no basket currency is available on both days")
+	}
+
+	for currency := range current.Rates {
+		if !convert.Available(start, currency) {
+			continue
+		}
+		var sum float64
+		var n int
+		for _, b := range index.Basket {
+			if b == currency {
+				continue
+			}
+			before := convert.Rate(start, currency, b)
+			if before == 0 {
+				continue
+			}
+			sum += (convert.Rate(current, currency, b)/before - 1) * 100
+			n++
+		}
+		if n > 0 {
+			index.Currencies = append(index.Currencies, Strength{currency, convert.RoundTo2Decimals(sum / float64(n))})
+		}
+	}
+
+	sort.Slice(index.Currencies, func(i, j int) bool {
+		if index.Currencies[i].Score != index.Currencies[j].Score {
+			return index.Currencies[i].Score > index.Currencies[j].Score
+		}
+		return index.Currencies[i].Currency < index.Currencies[j].Currency
+	})
+	queryCache.add(key, index)
+	return index, nil
+}
+
+// returns the strength index for the window (days, 30 by default) and basket (comma separated) parameters of r
+// the basket defaults to the strength_basket environment variable
+func strengthFor(r *http.Request) (StrengthIndex, error) {
+	query := r.URL.Query()
+	window, err := strconv.Atoi(getQueryDefault(query.Get("window"), "30"))
+	if err != nil || window < 1 {
+		return StrengthIndex{}, errors.New("window must be a positive number of days")
+	}
+	basket := splitCurrencies(getQueryDefault(query.Get("basket"), getEnv("strength_basket", "USD,EUR,JPY,GBP,CNY,CHF,AUD,CAD")))
+	return strengthIndex(r.Context(), getCurrentData(r.Context()), window, basket)
+}
+
+// returns v or fallback if v is empty
+func getQueryDefault(v string, fallback string) string {
+	if v == "" {
+		return fallback
+	}
+	return v
+}
+
+// writes the strength index as json
+func apiStrengthHandler(w http.ResponseWriter, r *http.Request) {
+	index, err := strengthFor(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, index)
+}
+
+// renders the currencies ranked by strength
+func strengthHandler(w http.ResponseWriter, r *http.Request) {
+	index, err := strengthFor(r)
+	if err != nil {
+		renderTemplate(w, r, "strength", &StrengthPage{Message: err.Error()})
+		return
+	}
+	renderTemplate(w, r, "strength", &StrengthPage{Index: &index})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"currconv/pkg/rates"
+)
+
+// most suggestions offered for an unknown currency
+const maxSuggestions = 3
+
+// UnknownCurrencyError is returned for a currency without rates, it wraps rates.ErrUnknownCurrency
+type UnknownCurrencyError struct {
+	Currency string
+	err      error
+}
+
+func (e *UnknownCurrencyError) Error() string {
+	return e.err.Error()
+}
+
+func (e *UnknownCurrencyError) Unwrap() error {
+	return e.err
+}
+
+// returns the number of single character insertions, deletions and substitutions turning a into b
+func editDistance(a string, b string) int {
+	s, t := []rune(a), []rune(b)
+	previous := make([]int, len(t)+1)
+	current := make([]int, len(t)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		current[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(t)]
+}
+
+// returns up to maxSuggestions currencies of d whose code or a word of their name is close to input, closest first
+// a short input may be one edit away, a longer one two
+func suggestCurrencies(ctx context.Context, d rates.Data, input string) []string {
+	input = strings.ToUpper(strings.TrimSpace(input))
+	if input == "" {
+		return nil
+	}
+	threshold := 1
+	if len([]rune(input)) > 4 {
+		threshold = 2
+	}
+
+	distances := make(map[string]int)
+	for code := range d.Rates {
+		best := editDistance(input, code)
+		// the words of the names in all languages are matched as well as the whole names, "yen" finds Japanese Yen
+		for _, name := range currencyNames(ctx, code) {
+			name = strings.ToUpper(name)
+			for _, candidate := range append(strings.Fields(name), name) {
+				if distance := editDistance(input, candidate); distance < best {
+					best = distance
+				}
+			}
+		}
+		if best <= threshold {
+			distances[code] = best
+		}
+	}
+
+	suggestions := make([]string, 0, len(distances))
+	for code := range distances {
+		suggestions = append(suggestions, code)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if distances[a] != distances[b] {
+			return distances[a] < distances[b]
+		}
+		return a < b
+	})
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+	return suggestions
+}
+
+// returns the currencies of the current data the currency err is about might have meant, nil if err isn't about an unknown currency
+// retired currencies are explained by their error instead
+func suggestionsFor(r *http.Request, err error) []string {
+	var unknown *UnknownCurrencyError
+	if !errors.As(err, &unknown) {
+		return nil
+	}
+	if _, retired := retiredCurrencies[unknown.Currency]; retired {
+		return nil
+	}
+	return suggestCurrencies(r.Context(), getCurrentData(r.Context()), unknown.Currency)
+}
+
+// returns "did you mean A, B or C?" for suggestions
+func didYouMean(suggestions []string) string {
+	if len(suggestions) == 1 {
+		return "did you mean " + suggestions[0] + "?"
+	}
+	return "did you mean " + strings.Join(suggestions[:len(suggestions)-1], ", ") + " or " + suggestions[len(suggestions)-1] + "?"
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TimeoutWriter buffers a response until the handler finishes and discards it if the handler took too long
+type TimeoutWriter struct {
+	mutex    sync.Mutex
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	timedOut bool
+}
+
+func (tw *TimeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *TimeoutWriter) WriteHeader(status int) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	if tw.status == 0 {
+		tw.status = status
+	}
+}
+
+func (tw *TimeoutWriter) Write(b []byte) (int, error) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.body.Write(b)
+}
+
+// returns the time handlers may take, set by request_timeout (30s by default, 0 disables the timeout)
+func getRequestTimeout() time.Duration {
+	timeout, err := time.ParseDuration(getEnv("request_timeout", "30s"))
+	if err != nil {
+		return 30 * time.Second
+	}
+	return timeout
+}
+
+// checks whether the response to r is streamed, streamed responses are neither buffered nor timed out
+func isStreamed(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/bulk/", "/api/bulk", "/api/timeseries", "/export/history.csv", "/export/history.parquet", "/export/rates.xlsx", "/export/archive":
+		return true
+	}
+	return false
+}
+
+// wraps h so a request is answered with 504 once it takes longer than request_timeout
+// the context of the request is canceled then, which also cancels its upstream requests
+func timeoutRequests(h http.Handler) http.Handler {
+	timeout := getRequestTimeout()
+	if timeout <= 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isStreamed(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		tw := &TimeoutWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panics := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panics <- p
+				}
+			}()
+			h.ServeHTTP(tw, r)
+			close(done)
+		}()
+
+		select {
+		case p := <-panics:
+			// rethrown so the server logs it like any other panic
+			panic(p)
+		case <-done:
+			tw.mutex.Lock()
+			defer tw.mutex.Unlock()
+			for k, v := range tw.header {
+				w.Header()[k] = v
+			}
+			if tw.status == 0 {
+				tw.status = http.StatusOK
+			}
+			w.WriteHeader(tw.status)
+			w.Write(tw.body.Bytes())
+		case <-ctx.Done():
+			tw.mutex.Lock()
+			tw.timedOut = true
+			tw.mutex.Unlock()
+			// nothing can be sent if the client is gone
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				requestError(w, r, http.StatusGatewayTimeout, "Request timed out", "The request took longer than "+timeout.String()+", please try again later.")
+			}
+		}
+	})
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"time"
+	// embeds the timezone database for hosts without /usr/share/zoneinfo
+	_ "time/tzdata"
+)
+
+// layout of timestamps shown on pages
+const timeLayout = "2 Jan 2006, 15:04 MST"
+
+// returns the timezone given by the tz parameter (e.g. Europe/Berlin) or the tz cookie, UTC otherwise
+// remembers a valid tz parameter in the tz cookie
+func getTimezone(w http.ResponseWriter, r *http.Request) *time.Location {
+	if name := r.URL.Query().Get("tz"); name != "" {
+		if loc, err := time.LoadLocation(name); err == nil {
+			http.SetCookie(w, &http.Cookie{Name: "tz", Value: name, Path: "/", MaxAge: 365 * 24 * 3600})
+			return loc
+		}
+	}
+	if c, err := r.Cookie("tz"); err == nil {
+		if loc, err := time.LoadLocation(c.Value); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// formats the unix timestamp in the timezone requested by r
+func formatTimestamp(w http.ResponseWriter, r *http.Request, timestamp int64) string {
+	return time.Unix(timestamp, 0).In(getTimezone(w, r)).Format(timeLayout)
+}
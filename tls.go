@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+)
+
+// checks whether a certificate and key are configured with tls_cert and tls_key
+func tlsEnabled() bool {
+	return os.Getenv("tls_cert") != "" && os.Getenv("tls_key") != ""
+}
+
+// returns a handler redirecting every request permanently to the same url on the https origin at port
+func redirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			// no port in the host header
+			host = r.Host
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// serves h over https on port and redirects plain http on http_port (80 by default) to it
+// with socket activation the sockets named https and http are used, or the first two sockets of the unit
+func listenAndServeTLS(port string, h http.Handler) error {
+	l, err := listen("https", ":"+port)
+	if err != nil {
+		return err
+	}
+	redirect, err := listen("http", ":"+getEnv("http_port", "80"))
+	if err != nil {
+		log.Println(err)
+	} else {
+		go func() {
+			if err := newHTTPServer(redirectToHTTPS(port)).Serve(redirect); err != http.ErrServerClosed {
+				log.Println(err)
+			}
+		}()
+	}
+	return newHTTPServer(h).ServeTLS(l, os.Getenv("tls_cert"), os.Getenv("tls_key"))
+}
+
+// serves h over plain http on port, or on the socket passed by systemd
+func listenAndServe(port string, h http.Handler) error {
+	l, err := listen("http", ":"+port)
+	if err != nil {
+		return err
+	}
+	return newHTTPServer(h).Serve(l)
+}
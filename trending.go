@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"currconv/pkg/convert"
+	"currconv/pkg/rates"
+)
+
+var refreshes = &Refreshes{}
+
+// Refreshes remembers the data of the last two refreshes
+type Refreshes struct {
+	mutex    sync.Mutex
+	previous rates.Data
+	latest   rates.Data
+}
+
+// Move stores the change of a currency's value in percent
+type Move struct {
+	Currency string  `json:"currency"`
+	Change   float64 `json:"change"`
+}
+
+// Trending lists the currencies with the biggest moves, biggest first
+type Trending struct {
+	// refresh or day
+	Since string `json:"since"`
+	// date and timestamp of the data the moves are measured from
+	PreviousDate      string `json:"previous_date"`
+	PreviousTimestamp int64  `json:"previous_timestamp"`
+	PreviousTime      string `json:"previous_time"`
+	// currency the values are measured in
+	Against string `json:"against"`
+	Movers  []Move `json:"movers"`
+}
+
+// IndexPage stores variables for /
+type IndexPage struct {
+	// currencies selected by default
+	From   string
+	To     string
+	Movers []Move
+	CSRF   string
+	// input of a submitted form that is shown again with its errors
+	Value  string
+	Date   string
+	Errors FormErrors
+}
+
+// stores d as the latest refresh
+func (rs *Refreshes) record(d rates.Data) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	rs.previous = rs.latest
+	rs.latest = d
+}
+
+// returns the data of the refresh before the latest one
+func (rs *Refreshes) getPrevious() (rates.Data, bool) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	return rs.previous, rs.previous.Success
+}
+
+// returns the most recent day before the day of d stored by the server of ctx, looking back up to a week
+func previousDay(ctx context.Context, d rates.Data) (rates.Data, bool) {
+	date, err := time.Parse("2006-01-02", d.Date)
+	if err != nil {
+		return rates.Data{}, false
+	}
+	days := serverFrom(ctx).history.lastDays(date.AddDate(0, 0, -1), 7)
+	if len(days) == 0 {
+		return rates.Data{}, false
+	}
+	return days[len(days)-1], true
+}
+
+// returns the limit currencies whose value in against changed the most between previous and current
+func trending(previous rates.Data, current rates.Data, against string, limit int) []Move {
+	movers := []Move{}
+	for currency := range current.Rates {
+		if currency == against || !convert.Available(previous, currency, against) {
+			continue
+		}
+		before := convert.Rate(previous, currency, against)
+		if before == 0 {
+			continue
+		}
+		change := (convert.Rate(current, currency, against)/before - 1) * 100
+		movers = append(movers, Move{currency, convert.RoundTo2Decimals(change)})
+	}
+
+	sort.Slice(movers, func(i, j int) bool {
+		a, b := math.Abs(movers[i].Change), math.Abs(movers[j].Change)
+		if a != b {
+			return a > b
+		}
+		return movers[i].Currency < movers[j].Currency
+	})
+	if len(movers) > limit {
+		movers = movers[:limit]
+	}
+	return movers
+}
+
+// returns the trending currencies for the since (refresh or day), against and limit parameters of r
+func trendingFor(r *http.Request) (Trending, error) {
+	query := r.URL.Query()
+	d := getCurrentData(r.Context())
+
+	t := Trending{
+		Since:   getQueryDefault(query.Get("since"), "day"),
+		Against: currencyCode(getQueryDefault(query.Get("against"), getEnv("trending_against", "USD"))),
+	}
+	if !convert.Available(d, t.Against) {
+		return t, unknownCurrency(t.Against)
+	}
+	limit, err := strconv.Atoi(getQueryDefault(query.Get("limit"), "10"))
+	if err != nil || limit < 1 {
+		return t, errors.New("limit must be a positive number")
+	}
+
+	var previous rates.Data
+	var ok bool
+	switch t.Since {
+	case "refresh":
+		previous, ok = refreshes.getPrevious()
+	case "day":
+		previous, ok = previousDay(r.Context(), d)
+	default:
+		return t, errors.New("since must be refresh or day")
+	}
+	if !ok {
+		return t, errors.New("no previous rates to compare with")
+	}
+
+	t.PreviousDate = previous.Date
+	t.PreviousTimestamp = previous.Timestamp
+	t.PreviousTime = rfc3339(previous.Timestamp)
+	t.Movers = trending(previous, d, t.Against, limit)
+	return t, nil
+}
+
+// writes the currencies with the biggest moves as json
+func apiTrendingHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := trendingFor(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, t)
+}
+
+// renders the index page with the default currencies of the visitor's locale and the biggest movers since the previous day
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	p := IndexPage{Value: "1"}
+	p.From, p.To = defaultCurrencies(getLocale(r))
+	if base := preferencesFor(r).Base; base != "" {
+		if base == p.To {
+			p.To = p.From
+		}
+		p.From = base
+	}
+	renderIndex(w, r, p)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"currconv/pkg/rates"
+)
+
+// codes of virtual currencies, distinct from real ones by their length or digits
+var virtualCodePattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{2,9}$`)
+
+// VirtualCurrency is a currency defined by an admin, e.g. loyalty points or internal budget units
+// Value is what one unit is worth in real currencies, a fixed amount like "0.01 EUR"
+// or a formula adding up amounts like "0.6 USD + 0.4 EUR"
+type VirtualCurrency struct {
+	Code  string `json:"code"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// parsed Value
+	terms []valueTerm
+}
+
+// valueTerm is one amount of a real currency in the value of a virtual currency
+type valueTerm struct {
+	amount   float64
+	currency string
+}
+
+// VirtualCurrencies stores the virtual currencies by their code
+type VirtualCurrencies struct {
+	mutex sync.Mutex
+	// file the currencies are persisted to
+	path       string
+	Currencies map[string]VirtualCurrency
+}
+
+// parses the value of a virtual currency, amounts of real currencies joined by +
+func parseVirtualValue(value string) ([]valueTerm, error) {
+	var terms []valueTerm
+	for _, term := range strings.Split(value, "+") {
+		fields := strings.Fields(term)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%q must be an amount followed by a currency, e.g. 0.01 EUR", strings.TrimSpace(term))
+		}
+		amount, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil || amount <= 0 {
+			return nil, fmt.Errorf("%w: %s must be a positive number", ErrInvalidAmount, fields[0])
+		}
+		terms = append(terms, valueTerm{amount, strings.ToUpper(fields[1])})
+	}
+	return terms, nil
+}
+
+// reads the virtual currencies stored at path
+// returns no currencies if the file does not exist yet
+func loadVirtualCurrencies(path string) *VirtualCurrencies {
+	v := &VirtualCurrencies{path: path, Currencies: make(map[string]VirtualCurrency)}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return v
+	}
+	if err != nil {
+		log.Println(err)
+		return v
+	}
+
+	err = json.Unmarshal(b, &v.Currencies)
+	if err != nil {
+		log.Println(err)
+	}
+	for code, c := range v.Currencies {
+		c.terms, err = parseVirtualValue(c.Value)
+		if err != nil {
+			log.Println("virtual currency", code+":", err)
+			delete(v.Currencies, code)
+			continue
+		}
+		v.Currencies[code] = c
+	}
+	return v
+}
+
+// writes the currencies to disk, the mutex must be held
+func (v *VirtualCurrencies) save() {
+	b, err := json.Marshal(v.Currencies)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = ioutil.WriteFile(v.path, b, 0644)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// checks c against the real currencies of d and stores it, replacing a currency with the same code
+func (v *VirtualCurrencies) add(c VirtualCurrency, d rates.Data) error {
+	c.Code = strings.ToUpper(strings.TrimSpace(c.Code))
+	if !virtualCodePattern.MatchString(c.Code) {
+		return errors.New("code must be 3 to 10 letters or digits starting with a letter")
+	}
+	if _, exists := d.Rates[c.Code]; exists || c.Code == d.Base {
+		return fmt.Errorf("%s is a real currency", c.Code)
+	}
+	if _, retired := retiredCurrencies[c.Code]; retired {
+		return fmt.Errorf("%s is a retired currency", c.Code)
+	}
+	terms, err := parseVirtualValue(c.Value)
+	if err != nil {
+		return err
+	}
+	for _, t := range terms {
+		if _, ok := d.Rates[t.currency]; !ok && t.currency != d.Base {
+			return fmt.Errorf("%w, the value must be given in real currencies", unknownCurrency(t.currency))
+		}
+	}
+	c.terms = terms
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.Currencies[c.Code] = c
+	v.save()
+	return nil
+}
+
+// deletes the currency with code, returns whether it existed
+func (v *VirtualCurrencies) remove(code string) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if _, ok := v.Currencies[code]; !ok {
+		return false
+	}
+	delete(v.Currencies, code)
+	v.save()
+	return true
+}
+
+// returns the currency with code and whether it exists
+func (v *VirtualCurrencies) get(code string) (VirtualCurrency, bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	c, ok := v.Currencies[code]
+	return c, ok
+}
+
+// returns the currencies sorted by code
+func (v *VirtualCurrencies) all() []VirtualCurrency {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	list := make([]VirtualCurrency, 0, len(v.Currencies))
+	for _, c := range v.Currencies {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	return list
+}
+
+// returns d with the rates of the virtual currencies of the server of ctx added, d itself is left unchanged
+// currencies whose value refers to a currency missing from d are left out
+func withVirtualRates(ctx context.Context, d rates.Data) rates.Data {
+	if d.Rates == nil {
+		return d
+	}
+	list := serverFrom(ctx).virtualCurrencies.all()
+	if len(list) == 0 {
+		return d
+	}
+
+	withRates := make(map[string]float64, len(d.Rates)+len(list))
+	for currency, rate := range d.Rates {
+		withRates[currency] = rate
+	}
+	for _, c := range list {
+		// value of one unit in the base currency
+		inBase := 0.0
+		for _, t := range c.terms {
+			rate, ok := d.Rates[t.currency]
+			if t.currency == d.Base {
+				rate, ok = 1, true
+			}
+			if !ok || rate == 0 {
+				inBase = 0
+				break
+			}
+			inBase += t.amount / rate
+		}
+		if inBase > 0 {
+			withRates[c.Code] = 1 / inBase
+		}
+	}
+	d.Rates = withRates
+	return d
+}
+
+// lists the virtual currencies, adds one with the form values code, name and value on POST
+func adminCurrenciesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if err := parseForm(r); bodyTooLarge(w, r, err) {
+			return
+		}
+		d, err := serverFrom(r.Context()).cache.Get(r.Context())
+		if err != nil && !errors.Is(err, rates.ErrStaleData) {
+			failRequest(w, r, err)
+			return
+		}
+		c := VirtualCurrency{Code: r.FormValue("code"), Name: strings.TrimSpace(r.FormValue("name")), Value: strings.TrimSpace(r.FormValue("value"))}
+		if err := serverFrom(r.Context()).virtualCurrencies.add(c, d); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Println("Virtual currency", strings.ToUpper(c.Code), "set to", c.Value)
+	}
+	writeJSON(w, serverFrom(r.Context()).virtualCurrencies.all())
+}
+
+// deletes the virtual currency named by the path
+func adminDeleteCurrencyHandler(w http.ResponseWriter, r *http.Request) {
+	code := strings.ToUpper(pathParam(r, "code"))
+	if !serverFrom(r.Context()).virtualCurrencies.remove(code) {
+		writeError(w, http.StatusNotFound, "no virtual currency "+code)
+		return
+	}
+	log.Println("Virtual currency", code, "deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"currconv/pkg/rates"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// returns the subscriber urls set in the webhook_urls environment variable (comma separated)
+func getWebhookURLs() []string {
+	var urls []string
+	for _, url := range strings.Split(os.Getenv("webhook_urls"), ",") {
+		url = strings.TrimSpace(url)
+		if url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// returns the hex encoded HMAC-SHA256 of body using secret
+func sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// warns that no webhooks are sent if webhook_urls is set without webhook_secret
+func warnWebhookSecret() {
+	if len(getWebhookURLs()) > 0 && os.Getenv("webhook_secret") == "" {
+		log.Println("warning: webhook_secret is not set, no webhooks are sent since subscribers couldn't verify them")
+	}
+}
+
+// posts d as json to every webhook subscriber
+// the body is signed with webhook_secret and the signature sent in the X-Signature header,
+// nothing is sent without a secret, subscribers couldn't tell the rates from forged ones
+// only the leader sends them, every replica sharing the refreshes is notified of the same rates
+func notifyWebhooks(d rates.Data) {
+	urls := getWebhookURLs()
+	if len(urls) == 0 || os.Getenv("webhook_secret") == "" || !isLeader() {
+		return
+	}
+
+	body, err := json.Marshal(d)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	signature := "sha256=" + sign(body, os.Getenv("webhook_secret"))
+
+	for _, url := range urls {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Signature", signature)
+
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Println("Webhook", url, "returned", resp.Status)
+		}
+	}
+}
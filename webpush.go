@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// sends pushes only to public addresses, so subscriptions can't make the server post to internal services
+var pushClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		Proxy:       http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{Timeout: 5 * time.Second, Control: rejectPrivateAddress}).DialContext,
+	},
+}
+
+// refuses connections to loopback, private, link-local and other addresses that aren't publicly routed,
+// checked after the host name is resolved so it can't be bypassed through DNS
+func rejectPrivateAddress(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return fmt.Errorf("push services at %s aren't allowed", host)
+	}
+	return nil
+}
+
+// host names of the push services of the major browsers, a subscription's endpoint must be on one of them or
+// their subdomains unless push_hosts lists others
+const defaultPushHosts = "fcm.googleapis.com,push.services.mozilla.com,notify.windows.com,push.apple.com"
+
+// checks whether host is one of the push services set by push_hosts or a subdomain of one
+func allowedPushHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range strings.Split(getEnv("push_hosts", defaultPushHosts), ",") {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed != "" && (host == allowed || strings.HasSuffix(host, "."+allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// errPushGone is returned by sendPush if the push service no longer knows the subscription
+var errPushGone = errors.New("push subscription expired or unsubscribed")
+
+// VAPID key identifying the server to the push services, loaded on first use
+var vapid struct {
+	once sync.Once
+	key  *ecdsa.PrivateKey
+}
+
+// PushSubscription is the subscription a browser returns from PushManager.subscribe()
+type PushSubscription struct {
+	Endpoint string   `json:"endpoint"`
+	Keys     PushKeys `json:"keys"`
+}
+
+// PushKeys are the public key and authentication secret of a push subscription, base64url encoded
+type PushKeys struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+// decodes base64url with or without padding, as browsers send it either way
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// returns the VAPID key set by vapid_private_key (the base64url encoded private scalar) or else the one stored in vapid_key_file,
+// generating and storing one there if the file does not exist yet
+// the key must stay the same, subscriptions made with another key are rejected by the push services
+func vapidKey() *ecdsa.PrivateKey {
+	vapid.once.Do(func() {
+		encoded := os.Getenv("vapid_private_key")
+		path := getEnv("vapid_key_file", "vapid.key")
+		if encoded == "" {
+			b, err := ioutil.ReadFile(path)
+			if err != nil && !os.IsNotExist(err) {
+				log.Println(err)
+			}
+			encoded = strings.TrimSpace(string(b))
+		}
+		if encoded != "" {
+			b, err := decodeBase64URL(encoded)
+			if err == nil {
+				vapid.key, err = ecdsa.ParseRawPrivateKey(elliptic.P256(), b)
+			}
+			if err == nil {
+				return
+			}
+			log.Println("vapid key:", err)
+		}
+
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		vapid.key = key
+		b, err := key.Bytes()
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(base64.RawURLEncoding.EncodeToString(b)), 0600)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+		log.Println("Generated a new VAPID key, stored in", path)
+	})
+	return vapid.key
+}
+
+// returns the uncompressed public VAPID key, base64url encoded, which browsers subscribe with as applicationServerKey
+func vapidPublicKey() (string, error) {
+	key := vapidKey()
+	if key == nil {
+		return "", errors.New("no vapid key")
+	}
+	pub, err := key.ECDH()
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(pub.PublicKey().Bytes()), nil
+}
+
+// returns the Authorization header for a push to endpoint, a JWT signed with the VAPID key (RFC 8292)
+// the subject is the contact set by vapid_subject, a mailto: or https: url
+func vapidAuthorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	publicKey, err := vapidPublicKey()
+	if err != nil {
+		return "", err
+	}
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": getEnv("vapid_subject", "mailto:"+getEnv("smtp_from", "admin@localhost")),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, vapidKey(), hash[:])
+	if err != nil {
+		return "", err
+	}
+	// ES256 signatures are r and s as 32 bytes each, not ASN.1
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return "vapid t=" + unsigned + "." + base64.RawURLEncoding.EncodeToString(signature) + ", k=" + publicKey, nil
+}
+
+// encrypts payload for the browser of sub as a single aes128gcm record (RFC 8291)
+func encryptPush(sub PushSubscription, payload []byte) ([]byte, error) {
+	uaKey, err := decodeBase64URL(sub.Keys.P256dh)
+	if err != nil {
+		return nil, err
+	}
+	authSecret, err := decodeBase64URL(sub.Keys.Auth)
+	if err != nil {
+		return nil, err
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// every message is encrypted with a new key of the server and a new salt
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+	shared, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	prkKey, err := hkdf.Extract(sha256.New, shared, authSecret)
+	if err != nil {
+		return nil, err
+	}
+	ikm, err := hkdf.Expand(sha256.New, prkKey, "WebPush: info\x00"+string(uaKey)+string(asPublic), 32)
+	if err != nil {
+		return nil, err
+	}
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// header: salt, record size, length and value of the key id, which is the server's public key
+	var body bytes.Buffer
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(4096))
+	body.WriteByte(byte(len(asPublic)))
+	body.Write(asPublic)
+	// 2 marks the last record, there is only one
+	return gcm.Seal(body.Bytes(), nonce, append(append([]byte{}, payload...), 2), nil), nil
+}
+
+// sends payload to the browser of sub through its push service
+// returns errPushGone if the subscription no longer exists and should be deleted
+func sendPush(ctx context.Context, sub PushSubscription, payload []byte) error {
+	body, err := encryptPush(sub, payload)
+	if err != nil {
+		return err
+	}
+	auth, err := vapidAuthorization(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	// alerts are pointless once the rate has moved on
+	req.Header.Set("TTL", "86400")
+	req.Header.Set("Urgency", "high")
+
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return errPushGone
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("push to %s failed: %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Widget stores the data the embeddable widget needs to convert amounts in the browser
+type Widget struct {
+	// default currencies preselected in the widget
+	From       string             `json:"from"`
+	To         string             `json:"to"`
+	Currencies []string           `json:"currencies"`
+	Base       string             `json:"base"`
+	Timestamp  int64              `json:"timestamp"`
+	Time       string             `json:"time"`
+	Rates      map[string]float64 `json:"rates"`
+}
+
+// serves the widget script that renders a converter box on third-party pages
+func widgetScriptHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/javascript")
+	// third-party pages reference the script without a version, so it is only cached briefly
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	http.ServeFile(w, r, "static/widget.js")
+}
+
+// writes the rates and default currencies for the widget as json
+// the defaults can be given in the url query and fall back to widget_from and widget_to (EUR and USD by default)
+// can be requested from any origin since the widget runs on third-party pages
+func apiWidgetHandler(w http.ResponseWriter, r *http.Request) {
+	d := getCurrentData(r.Context())
+
+	from := currencyCode(r.URL.Query().Get("from"))
+	if _, ok := d.Rates[from]; !ok {
+		from = getEnv("widget_from", "EUR")
+	}
+	to := currencyCode(r.URL.Query().Get("to"))
+	if _, ok := d.Rates[to]; !ok {
+		to = getEnv("widget_to", "USD")
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	setCacheHeaders(w, r, d)
+	writeJSON(w, Widget{from, to, sortedCurrencies(d), d.Base, d.Timestamp, rfc3339(d.Timestamp), d.Rates})
+}